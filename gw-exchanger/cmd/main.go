@@ -1,32 +1,143 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"gw-exchanger/internal/config"
+	"gw-exchanger/internal/health"
+	"gw-exchanger/internal/metrics"
+	"gw-exchanger/internal/migrate"
+	"gw-exchanger/internal/storages"
 	"gw-exchanger/internal/storages/postgres"
+	"gw-exchanger/internal/tracing"
+	"gw-exchanger/migrations"
 	utils "gw-exchanger/pkg"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	grpc "gw-exchanger/internal/server"
 
-	"log"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
-	utils.LogInfo("Starting gw-exchanger service...")
-	config.SetDefaults()
+	cfg, err := config.LoadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logger, err := utils.NewLogger(cfg.LogLevel, cfg.LogFormat, os.Stdout)
+	if err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("Starting gw-exchanger service...")
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.TracingExporter, cfg.TracingOTLPEndpoint, cfg.TracingServiceName, cfg.TracingSampleRatio, os.Stdout)
+	if err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+
+	storage, err := postgres.NewPostgresStorage(cfg.DatabaseURL, postgres.Options{
+		MaxOpenConns:    cfg.DBMaxOpenConns,
+		MaxIdleConns:    cfg.DBMaxIdleConns,
+		ConnMaxLifetime: cfg.DBConnMaxLifetime,
+		ConnectTimeout:  cfg.DBConnectTimeout,
+	})
+	if err != nil {
+		fatal("Failed to initialize storage", err)
+	}
 
-	cfg, err := config.LoadConfig("config.env")
+	// The schema is changed by the migrate subcommand only, the server refuses to run on an outdated one
+	migrator, err := migrate.New(storage.DB(), migrations.FS)
 	if err != nil {
-		log.Fatalf("Failed to load configuration: %v", err)
+		fatal("Invalid migrations", err)
+	}
+	if len(cfg.Command) > 0 {
+		err := migrate.Run(context.Background(), migrator, cfg.Command[1:], os.Stdout)
+		storage.Close()
+		if err != nil {
+			fatal("Migration failed", err)
+		}
+		return
+	}
+	if err := migrator.Check(context.Background()); err != nil {
+		fatal("Refusing to start, apply the migrations with the migrate up command", err)
 	}
 
-	storage, err := postgres.NewPostgresStorage(cfg.DatabaseURL)
+	// Stop on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Prometheus metrics and the health probes are served on their own port, next to gRPC
+	metrics.RegisterDB(storage.DB())
+	metrics.RegisterRateAge(storage)
+	probes := health.NewChecker(cfg.HealthCheckTimeout)
+	probes.Add("database", storage.Ping)
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", probes.Live)
+	mux.HandleFunc("GET /readyz", probes.Ready)
+	metricsServer := &http.Server{Addr: ":" + cfg.MetricsPort, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		slog.Info("Metrics server is running", slog.String("port", cfg.MetricsPort))
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server stopped", slog.Any("error", err))
+		}
+	}()
+
+	// The rate-age metric above reads the database directly, calls go through the cache when enabled
+	server, err := grpc.NewServer(storages.NewCachedStorage(storage, cfg.RatesCacheTTL), grpc.Options{
+		RequestTimeout:  cfg.GRPCRequestTimeout,
+		TLSCertFile:     cfg.TLSCertFile,
+		TLSKeyFile:      cfg.TLSKeyFile,
+		TLSClientCAFile: cfg.TLSClientCAFile,
+	})
 	if err != nil {
-		log.Fatalf("Failed to initialize storage: %v", err)
+		fatal("Failed to create gRPC server", err)
 	}
-	defer storage.Close()
+	go server.WatchStorage(ctx, cfg.HealthCheckInterval)
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start(cfg.GRPCPort)
+	}()
 
-	server := grpc.NewServer(storage)
-	if err := server.Start(cfg.GRPCPort); err != nil {
-		log.Fatalf("Failed to start gRPC server: %v", err)
+	select {
+	case err := <-serverErr:
+		fatal("Failed to start gRPC server", err)
+	case <-ctx.Done():
 	}
+
+	// Let running calls finish before closing the database they use
+	slog.Info("Shutting down, waiting for running calls", slog.Duration("timeout", cfg.ShutdownTimeout))
+	probes.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	server.Stop(shutdownCtx)
+	if err := metricsServer.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error stopping metrics server", slog.Any("error", err))
+	}
+	if err := storage.Close(); err != nil {
+		slog.Error("Error closing database", slog.Any("error", err))
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", slog.Any("error", err))
+	}
+	slog.Info("gw-exchanger service stopped")
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
 }
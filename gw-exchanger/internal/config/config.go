@@ -1,26 +1,156 @@
 package config
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
+// Supported rate providers.
+const ProviderPostgres = "postgres"
+
 type Config struct {
-	DatabaseURL string `mapstructure:"DATABASE_URL"`
-	GRPCPort    string `mapstructure:"GRPC_PORT"`
-}
+	DatabaseURL       string        `mapstructure:"DATABASE_URL"`
+	DBMaxOpenConns    int           `mapstructure:"DB_MAX_OPEN_CONNS"`
+	DBMaxIdleConns    int           `mapstructure:"DB_MAX_IDLE_CONNS"`
+	DBConnMaxLifetime time.Duration `mapstructure:"DB_CONN_MAX_LIFETIME"`
+	DBConnectTimeout  time.Duration `mapstructure:"DB_CONNECT_TIMEOUT"`
+
+	GRPCPort           string        `mapstructure:"GRPC_PORT"`
+	GRPCRequestTimeout time.Duration `mapstructure:"GRPC_REQUEST_TIMEOUT"`
+	// TLS is enabled when a certificate and key are set, client certificates are required when a
+	// client CA is set.
+	TLSCertFile     string `mapstructure:"GRPC_TLS_CERT_FILE"`
+	TLSKeyFile      string `mapstructure:"GRPC_TLS_KEY_FILE"`
+	TLSClientCAFile string `mapstructure:"GRPC_TLS_CLIENT_CA_FILE"`
+
+	MetricsPort         string        `mapstructure:"METRICS_PORT"`
+	HealthCheckTimeout  time.Duration `mapstructure:"HEALTH_CHECK_TIMEOUT"`
+	HealthCheckInterval time.Duration `mapstructure:"HEALTH_CHECK_INTERVAL"`
+	ShutdownTimeout     time.Duration `mapstructure:"SHUTDOWN_TIMEOUT"`
+
+	// RatesProvider is the source of the exchange rates, RatesCacheTTL how long they are served from
+	// memory before being read again (0 disables the cache).
+	RatesProvider string        `mapstructure:"RATES_PROVIDER"`
+	RatesCacheTTL time.Duration `mapstructure:"RATES_CACHE_TTL"`
+
+	LogLevel  string `mapstructure:"LOG_LEVEL"`
+	LogFormat string `mapstructure:"LOG_FORMAT"`
+
+	TracingExporter     string  `mapstructure:"TRACING_EXPORTER"`
+	TracingOTLPEndpoint string  `mapstructure:"OTEL_EXPORTER_OTLP_ENDPOINT"`
+	TracingServiceName  string  `mapstructure:"OTEL_SERVICE_NAME"`
+	TracingSampleRatio  float64 `mapstructure:"TRACING_SAMPLE_RATIO"`
 
-func LoadConfig(path string) (*Config, error) {
-	viper.SetConfigFile(path)
-	viper.AutomaticEnv()
+	// Command is the subcommand given after the flags with its arguments, e.g. migrate up. It is
+	// empty when the server should run.
+	Command []string `mapstructure:"-"`
+}
 
-	if err := viper.ReadInConfig(); err != nil {
+// LoadConfig reads the configuration file given with -c in args, if any, and the environment. Environment
+// variables override the file and defaults are only used for settings found in neither. Without -c the
+// file config.env is read when it exists. The only subcommand accepted after the flags is migrate.
+func LoadConfig(args []string) (*Config, error) {
+	flags := flag.NewFlagSet("gw-exchanger", flag.ContinueOnError)
+	path := flags.String("c", "config.env", "path of the config file")
+	if err := flags.Parse(args); err != nil {
 		return nil, err
 	}
+	if flags.NArg() > 0 && flags.Arg(0) != "migrate" {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	explicit := false
+	flags.Visit(func(f *flag.Flag) { explicit = f.Name == "c" || explicit })
+
+	v := viper.New()
+	setDefaults(v)
+	v.AutomaticEnv()
+	v.SetConfigFile(*path)
+	v.SetConfigType("env")
+	if err := v.ReadInConfig(); err != nil && (explicit || !errors.Is(err, fs.ErrNotExist)) {
+		return nil, fmt.Errorf("failed to read config file %s: %w", *path, err)
+	}
 
 	var config Config
-	if err := viper.Unmarshal(&config); err != nil {
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	if err := config.Validate(); err != nil {
 		return nil, err
 	}
-
+	config.Command = flags.Args()
 	return &config, nil
 }
+
+// Validate reports every invalid setting.
+func (c *Config) Validate() error {
+	var errs []error
+	fail := func(key string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+
+	if u, err := url.Parse(c.DatabaseURL); c.DatabaseURL == "" || err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		fail("DATABASE_URL", "must be a postgres:// URL")
+	}
+	if c.DBMaxOpenConns < 1 {
+		fail("DB_MAX_OPEN_CONNS", "must be at least 1")
+	}
+	if c.DBMaxIdleConns < 0 || c.DBMaxIdleConns > c.DBMaxOpenConns {
+		fail("DB_MAX_IDLE_CONNS", "must be between 0 and DB_MAX_OPEN_CONNS (%d)", c.DBMaxOpenConns)
+	}
+	for _, port := range []struct{ key, value string }{{"GRPC_PORT", c.GRPCPort}, {"METRICS_PORT", c.MetricsPort}} {
+		if n, err := strconv.Atoi(port.value); err != nil || n < 1 || n > 65535 {
+			fail(port.key, "invalid port %q", port.value)
+		}
+	}
+	if c.GRPCPort == c.MetricsPort {
+		fail("METRICS_PORT", "must differ from GRPC_PORT")
+	}
+	for _, timeout := range []struct {
+		key   string
+		value time.Duration
+	}{
+		{"DB_CONN_MAX_LIFETIME", c.DBConnMaxLifetime},
+		{"DB_CONNECT_TIMEOUT", c.DBConnectTimeout},
+		{"GRPC_REQUEST_TIMEOUT", c.GRPCRequestTimeout},
+		{"HEALTH_CHECK_TIMEOUT", c.HealthCheckTimeout},
+		{"HEALTH_CHECK_INTERVAL", c.HealthCheckInterval},
+		{"SHUTDOWN_TIMEOUT", c.ShutdownTimeout},
+	} {
+		if timeout.value <= 0 {
+			fail(timeout.key, "must be a positive duration")
+		}
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		fail("GRPC_TLS_CERT_FILE", "GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE must be set together")
+	}
+	if c.TLSClientCAFile != "" && c.TLSCertFile == "" {
+		fail("GRPC_TLS_CLIENT_CA_FILE", "requires GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE")
+	}
+	if c.RatesProvider != ProviderPostgres {
+		fail("RATES_PROVIDER", "unknown provider %q, supported: %s", c.RatesProvider, ProviderPostgres)
+	}
+	if c.RatesCacheTTL < 0 {
+		fail("RATES_CACHE_TTL", "must not be negative")
+	}
+	switch strings.ToLower(c.TracingExporter) {
+	case "none", "stdout", "otlp":
+	default:
+		fail("TRACING_EXPORTER", "must be none, stdout or otlp")
+	}
+	if c.TracingSampleRatio < 0 || c.TracingSampleRatio > 1 {
+		fail("TRACING_SAMPLE_RATIO", "must be between 0 and 1")
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
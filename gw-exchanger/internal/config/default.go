@@ -1,8 +1,40 @@
 package config
 
-import "os"
+import "github.com/spf13/viper"
 
-func SetDefaults() {
-	_ = os.Setenv("DATABASE_URL", "postgres://admin:securepassword@db_server:5432/my_database?sslmode=disable")
-	_ = os.Setenv("GRPC_PORT", "50051")
+// defaults are used for the settings that are neither in the config file nor in the environment.
+var defaults = map[string]any{
+	"DATABASE_URL":         "postgres://admin:securepassword@db_server:5432/my_database?sslmode=disable",
+	"DB_MAX_OPEN_CONNS":    20,
+	"DB_MAX_IDLE_CONNS":    10,
+	"DB_CONN_MAX_LIFETIME": "5m",
+	"DB_CONNECT_TIMEOUT":   "5s",
+
+	"GRPC_PORT":               "50051",
+	"GRPC_REQUEST_TIMEOUT":    "5s",
+	"GRPC_TLS_CERT_FILE":      "",
+	"GRPC_TLS_KEY_FILE":       "",
+	"GRPC_TLS_CLIENT_CA_FILE": "",
+
+	"METRICS_PORT":          "9090",
+	"HEALTH_CHECK_TIMEOUT":  "2s",
+	"HEALTH_CHECK_INTERVAL": "5s",
+	"SHUTDOWN_TIMEOUT":      "25s",
+
+	"RATES_PROVIDER":  "postgres",
+	"RATES_CACHE_TTL": "0s",
+
+	"LOG_LEVEL":  "info",
+	"LOG_FORMAT": "json",
+
+	"TRACING_EXPORTER":            "none",
+	"OTEL_EXPORTER_OTLP_ENDPOINT": "",
+	"OTEL_SERVICE_NAME":           "gw-exchanger",
+	"TRACING_SAMPLE_RATIO":        1.0,
+}
+
+func setDefaults(v *viper.Viper) {
+	for key, value := range defaults {
+		v.SetDefault(key, value)
+	}
 }
@@ -0,0 +1,65 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func ready(t *testing.T, c *Checker) (int, Report) {
+	rec := httptest.NewRecorder()
+	c.Ready(rec, httptest.NewRequest("GET", "/readyz", nil))
+	var report Report
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	return rec.Code, report
+}
+
+func TestReady_ReportsTheDatabase(t *testing.T) {
+	var databaseErr error
+	c := NewChecker(time.Second)
+	c.Add("database", func(ctx context.Context) error { return databaseErr })
+
+	code, report := ready(t, c)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusOK, report.Status)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+
+	databaseErr = errors.New("connection refused")
+	code, report = ready(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusUnavailable, report.Status)
+	assert.Equal(t, "connection refused", report.Checks["database"].Error)
+}
+
+func TestReady_TimesOutSlowChecks(t *testing.T) {
+	c := NewChecker(10 * time.Millisecond)
+	c.Add("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	code, report := ready(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks["database"].Error)
+}
+
+func TestReady_FailsWhileDraining(t *testing.T) {
+	c := NewChecker(time.Second)
+	c.Add("database", func(ctx context.Context) error { return nil })
+	c.Drain()
+
+	code, report := ready(t, c)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, StatusOK, report.Checks["database"].Status)
+
+	rec := httptest.NewRecorder()
+	c.Live(rec, httptest.NewRequest("GET", "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
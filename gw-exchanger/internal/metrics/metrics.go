@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"time"
+
+	"gw-exchanger/internal/storages"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "exchanger",
+		Name:      "grpc_requests_total",
+		Help:      "gRPC requests handled, by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "exchanger",
+		Name:      "grpc_request_duration_seconds",
+		Help:      "Latency of gRPC requests, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+// UnaryServerInterceptor counts gRPC requests and observes their latency.
+func UnaryServerInterceptor(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	grpcRequests.WithLabelValues(info.FullMethod, status.Code(err).String()).Inc()
+	grpcDuration.WithLabelValues(info.FullMethod).Observe(time.Since(start).Seconds())
+	return resp, err
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "exchanger"))
+}
+
+// RegisterRateAge exports the age of every exchange rate in storage, read on each scrape.
+func RegisterRateAge(storage storages.Storage) {
+	prometheus.MustRegister(&rateAgeCollector{storage: storage})
+}
+
+var rateAgeDesc = prometheus.NewDesc(
+	"exchanger_rate_age_seconds",
+	"Time since the exchange rate was last updated.",
+	[]string{"from", "to"}, nil,
+)
+
+// rateAgeCollector reads the exchange rates when scraped, so the age is always current.
+type rateAgeCollector struct {
+	storage storages.Storage
+}
+
+func (c *rateAgeCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- rateAgeDesc
+}
+
+func (c *rateAgeCollector) Collect(ch chan<- prometheus.Metric) {
+	rates, err := c.storage.ListExchangeRates(context.Background())
+	if err != nil {
+		slog.Error("Failed to collect exchange rate age", slog.Any("error", err))
+		return
+	}
+	now := time.Now()
+	for _, rate := range rates {
+		ch <- prometheus.MustNewConstMetric(rateAgeDesc, prometheus.GaugeValue, now.Sub(rate.UpdatedAt).Seconds(), rate.FromCurrency, rate.ToCurrency)
+	}
+}
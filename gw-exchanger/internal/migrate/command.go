@@ -0,0 +1,70 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Usage describes the arguments of the migrate subcommand.
+const Usage = `usage: migrate up | down [N] | status
+  up        apply every pending migration
+  down [N]  revert the last N applied migrations, 1 by default
+  status    list the migrations and when they were applied`
+
+// Run executes the migrate subcommand given by args and writes its report to w.
+func Run(ctx context.Context, m *Migrator, args []string, w io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate command\n%s", Usage)
+	}
+
+	switch {
+	case args[0] == "up" && len(args) == 1:
+		done, err := m.Up(ctx)
+		report(w, "Applied", done)
+		if err == nil && len(done) == 0 {
+			fmt.Fprintf(w, "Schema is up to date at version %03d\n", m.Latest())
+		}
+		return err
+
+	case args[0] == "down" && len(args) <= 2:
+		steps := 1
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid number of migrations to revert %q", args[1])
+			}
+			steps = n
+		}
+		done, err := m.Down(ctx, steps)
+		report(w, "Reverted", done)
+		return err
+
+	case args[0] == "status" && len(args) == 1:
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if !s.AppliedAt.IsZero() {
+				appliedAt = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(tw, "%03d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return tw.Flush()
+	}
+	return fmt.Errorf("invalid migrate command %q\n%s", strings.Join(args, " "), Usage)
+}
+
+func report(w io.Writer, verb string, migrations []Migration) {
+	for _, m := range migrations {
+		fmt.Fprintf(w, "%s %03d_%s\n", verb, m.Version, m.Name)
+	}
+}
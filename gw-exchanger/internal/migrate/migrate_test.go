@@ -0,0 +1,138 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"gw-exchanger/migrations"
+	"regexp"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func file(content string) *fstest.MapFile {
+	return &fstest.MapFile{Data: []byte(content)}
+}
+
+func TestLoad_SortsByVersion(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"010_add_limits.up.sql":     file("ALTER TABLE users ADD COLUMN x INTEGER;"),
+		"010_add_limits.down.sql":   file("ALTER TABLE users DROP COLUMN x;"),
+		"002_create_users.up.sql":   file("CREATE TABLE users ();"),
+		"002_create_users.down.sql": file("DROP TABLE users;"),
+	})
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+	assert.Equal(t, Migration{Version: 2, Name: "create_users", Up: "CREATE TABLE users ();", Down: "DROP TABLE users;"}, migrations[0])
+	assert.Equal(t, int64(10), migrations[1].Version)
+}
+
+func TestLoad_RejectsInvalidFiles(t *testing.T) {
+	for name, fsys := range map[string]fstest.MapFS{
+		"invalid migration file name": {"create_users.sql": file("CREATE TABLE users ();")},
+		"needs both an up and a down": {"001_create_users.up.sql": file("CREATE TABLE users ();")},
+		"is used by create_users and create_wallets": {
+			"001_create_users.up.sql":     file("CREATE TABLE users ();"),
+			"001_create_users.down.sql":   file("DROP TABLE users;"),
+			"001_create_wallets.up.sql":   file("CREATE TABLE wallets ();"),
+			"001_create_wallets.down.sql": file("DROP TABLE wallets;"),
+		},
+	} {
+		_, err := Load(fsys)
+		assert.ErrorContains(t, err, name)
+	}
+}
+
+func TestLoad_EmbeddedMigrations(t *testing.T) {
+	loaded, err := Load(migrations.FS)
+	require.NoError(t, err)
+	for i, m := range loaded {
+		assert.Equal(t, int64(i+1), m.Version, "migration versions must not have gaps")
+	}
+}
+
+func TestPending(t *testing.T) {
+	all := []Migration{{Version: 1}, {Version: 2}, {Version: 3}}
+	pending := Pending(all, map[int64]time.Time{1: time.Now(), 3: time.Now(), 4: time.Now()})
+	assert.Equal(t, []Migration{{Version: 2}}, pending)
+}
+
+func TestCheck(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m := &Migrator{db: db, migrations: []Migration{{Version: 1, Name: "create_users"}, {Version: 2, Name: "add_roles"}}}
+
+	// A newer binary applied version 3, which this one does not know
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT version, applied_at, (.+) FROM public.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at", "checksum"}).
+			AddRow(1, time.Now(), m.migrations[0].Checksum()).AddRow(2, time.Now(), nil).AddRow(3, time.Now(), "3"))
+	assert.NoError(t, m.Check(context.Background()))
+
+	// A database never migrated
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	err = m.Check(context.Background())
+	assert.ErrorIs(t, err, ErrSchemaBehind)
+	assert.ErrorContains(t, err, "2 pending migrations, first is 001_create_users")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestCheck_ChecksumMismatch(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	m := &Migrator{db: db, migrations: []Migration{{Version: 1, Name: "create_users", Up: "CREATE TABLE users ();"}}}
+
+	// The up script was edited after it was applied
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT version, applied_at, (.+) FROM public.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at", "checksum"}).
+			AddRow(1, time.Now(), Migration{Up: "CREATE TABLE users (id INTEGER);"}.Checksum()))
+	err = m.Check(context.Background())
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+	assert.ErrorContains(t, err, "001_create_users")
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestUp_RecordsChecksums(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+	users := Migration{Version: 1, Name: "create_users", Up: "CREATE TABLE users ();"}
+	roles := Migration{Version: 2, Name: "add_roles", Up: "ALTER TABLE users ADD COLUMN role TEXT;"}
+	m := &Migrator{db: db, migrations: []Migration{users, roles}}
+
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS public.schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("ADD COLUMN IF NOT EXISTS checksum").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT to_regclass").WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectQuery("SELECT version, applied_at, (.+) FROM public.schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version", "applied_at", "checksum"}).AddRow(1, time.Now(), nil))
+	// Version 1 was applied before checksums were kept
+	mock.ExpectExec("UPDATE public.schema_migrations SET checksum").
+		WithArgs(int64(1), users.Checksum()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(roles.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO public.schema_migrations").
+		WithArgs(int64(2), "add_roles", roles.Checksum()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	done, err := m.Up(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []Migration{roles}, done)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestRun_InvalidCommand(t *testing.T) {
+	var out bytes.Buffer
+	for _, args := range [][]string{nil, {"sideways"}, {"down", "0"}, {"up", "2"}} {
+		assert.Error(t, Run(context.Background(), &Migrator{}, args, &out))
+	}
+	assert.Empty(t, out.String())
+}
@@ -2,13 +2,25 @@ package grpc
 
 import (
 	"context"
+	"errors"
+	"gw-exchanger/internal/storages"
+	"time"
 
 	pb "github.com/SafetyDuck5676/grpc_duck/proto-exchange"
 	// pb "gw-exchanger/internal/grpc/proto-exchange/grpc/pb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
+// RateUpdatedAtHeader is the response header carrying the time the rate was last updated, in RFC 3339 format.
+// The shared proto has no field for it, so it is sent as metadata.
+const RateUpdatedAtHeader = "rate-updated-at"
+
 func (s *Server) GetExchangeRates(ctx context.Context, req *pb.Empty) (*pb.ExchangeRatesResponse, error) {
-	rates, err := s.storage.GetExchangeRates()
+	rates, err := s.storage.GetExchangeRates(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -17,14 +29,19 @@ func (s *Server) GetExchangeRates(ctx context.Context, req *pb.Empty) (*pb.Excha
 }
 
 func (s *Server) GetExchangeRateForCurrency(ctx context.Context, req *pb.CurrencyRequest) (*pb.ExchangeRateResponse, error) {
-	rate, err := s.storage.GetExchangeRate(req.FromCurrency, req.ToCurrency)
-	if err != nil {
+	rate, err := s.storage.GetExchangeRate(ctx, req.FromCurrency, req.ToCurrency)
+	if errors.Is(err, storages.ErrRateNotFound) {
+		return nil, status.Error(codes.NotFound, err.Error())
+	} else if err != nil {
+		return nil, err
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(RateUpdatedAtHeader, rate.UpdatedAt.UTC().Format(time.RFC3339Nano))); err != nil {
 		return nil, err
 	}
 
 	return &pb.ExchangeRateResponse{
 		FromCurrency: req.FromCurrency,
 		ToCurrency:   req.ToCurrency,
-		Rate:         rate,
+		Rate:         rate.Rate,
 	}, nil
 }
@@ -0,0 +1,42 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gw-exchanger/internal/storages"
+	"testing"
+
+	pb "github.com/SafetyDuck5676/grpc_duck/proto-exchange"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeStorage returns err for every rate lookup.
+type fakeStorage struct {
+	storages.Storage
+
+	err error
+}
+
+func (f *fakeStorage) GetExchangeRate(ctx context.Context, fromCurrency, toCurrency string) (storages.ExchangeRate, error) {
+	return storages.ExchangeRate{}, f.err
+}
+
+func TestGetExchangeRateForCurrency_UnknownPair(t *testing.T) {
+	s := &Server{storage: &fakeStorage{err: fmt.Errorf("%w: USD to XYZ", storages.ErrRateNotFound)}}
+
+	_, err := s.GetExchangeRateForCurrency(context.Background(), &pb.CurrencyRequest{FromCurrency: "USD", ToCurrency: "XYZ"})
+
+	assert.Equal(t, codes.NotFound, status.Code(err))
+	assert.Contains(t, status.Convert(err).Message(), "USD to XYZ")
+}
+
+func TestGetExchangeRateForCurrency_StorageError(t *testing.T) {
+	s := &Server{storage: &fakeStorage{err: errors.New("connection refused")}}
+
+	_, err := s.GetExchangeRateForCurrency(context.Background(), &pb.CurrencyRequest{FromCurrency: "USD", ToCurrency: "EUR"})
+
+	assert.NotEqual(t, codes.NotFound, status.Code(err))
+}
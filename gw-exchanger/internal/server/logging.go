@@ -0,0 +1,43 @@
+package grpc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	utils "gw-exchanger/pkg"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// logRequests is a unary interceptor that stores the method and the caller's request ID in the context and
+// logs every call with its status code and duration.
+func logRequests(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	start := time.Now()
+
+	fields := []slog.Attr{slog.String("method", info.FullMethod)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get("x-request-id"); len(ids) > 0 {
+			fields = append(fields, slog.String("request_id", ids[0]))
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, slog.String("peer", p.Addr.String()))
+	}
+	ctx = utils.WithFields(ctx, fields...)
+
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	attrs := []slog.Attr{slog.String("code", code.String()), slog.Duration("duration", time.Since(start))}
+	if err != nil {
+		attrs = append(attrs, slog.Any("error", err))
+		slog.LogAttrs(ctx, slog.LevelError, "grpc request", attrs...)
+	} else {
+		slog.LogAttrs(ctx, slog.LevelInfo, "grpc request", attrs...)
+	}
+	return resp, err
+}
@@ -1,32 +1,154 @@
 package grpc
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"gw-exchanger/internal/metrics"
 	"gw-exchanger/internal/storages"
-	"log"
+	"log/slog"
 	"net"
+	"os"
+	"time"
 
 	pb "github.com/SafetyDuck5676/grpc_duck/proto-exchange"
 	// pb "gw-exchanger/internal/grpc/proto-exchange/grpc/pb"
 
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Server struct {
 	pb.UnimplementedExchangeServiceServer // Встраиваем необходимую структуру
 	storage                               storages.Storage
+	grpcServer                            *grpc.Server
+	health                                *health.Server
 }
 
-func NewServer(storage storages.Storage) *Server {
-	return &Server{storage: storage}
+// Options holds the gRPC server settings.
+type Options struct {
+	// RequestTimeout bounds the handling of every call.
+	RequestTimeout time.Duration
+	// TLS is enabled when a certificate and key are set, client certificates are required when a
+	// client CA is set.
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
 }
 
+func NewServer(storage storages.Storage, opts Options) (*Server, error) {
+	serverOpts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(logRequests, metrics.UnaryServerInterceptor, withTimeout(opts.RequestTimeout)),
+	}
+	if opts.TLSCertFile != "" {
+		creds, err := loadTLS(opts)
+		if err != nil {
+			return nil, err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+
+	s := &Server{storage: storage, health: health.NewServer()}
+	s.grpcServer = grpc.NewServer(serverOpts...)
+	pb.RegisterExchangeServiceServer(s.grpcServer, s)
+	healthpb.RegisterHealthServer(s.grpcServer, s.health)
+	return s, nil
+}
+
+// loadTLS loads the server certificate and, when configured, the CA verifying client certificates.
+func loadTLS(opts Options) (credentials.TransportCredentials, error) {
+	cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	cfg := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if opts.TLSClientCAFile != "" {
+		pem, err := os.ReadFile(opts.TLSClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read TLS client CA: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in TLS client CA %s", opts.TLSClientCAFile)
+		}
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return credentials.NewTLS(cfg), nil
+}
+
+// withTimeout is a unary interceptor that bounds the handling of every call.
+func withTimeout(timeout time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+		return handler(ctx, req)
+	}
+}
+
+// Start serves gRPC requests on port until Stop is called.
 func (s *Server) Start(port string) error {
 	listener, err := net.Listen("tcp", ":"+port)
 	if err != nil {
 		return err
 	}
-	grpcServer := grpc.NewServer()
-	pb.RegisterExchangeServiceServer(grpcServer, s)
-	log.Printf("gRPC server is running on port %s", port)
-	return grpcServer.Serve(listener)
+	slog.Info("gRPC server is running", slog.String("port", port))
+	return s.grpcServer.Serve(listener)
+}
+
+// WatchStorage updates the gRPC health status every interval until ctx is done, reporting the service as
+// not serving while the storage is unreachable.
+func (s *Server) WatchStorage(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	serving := true
+	for {
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := s.storage.Ping(pingCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+
+		status := healthpb.HealthCheckResponse_SERVING
+		if err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		if serving != (err == nil) {
+			serving = err == nil
+			slog.Warn("Storage health changed", slog.String("status", status.String()), slog.Any("error", err))
+		}
+		s.health.SetServingStatus("", status)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Stop stops accepting new calls and waits for the running ones to finish. Calls still running when ctx
+// is done are cancelled.
+func (s *Server) Stop(ctx context.Context) {
+	// Tell health checking clients first, so they stop routing calls here
+	s.health.Shutdown()
+
+	stopped := make(chan struct{})
+	go func() {
+		s.grpcServer.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for gRPC calls to finish")
+		s.grpcServer.Stop()
+	}
 }
@@ -0,0 +1,80 @@
+package storages
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// CachedStorage serves exchange rates from memory for ttl before reading them from the underlying storage
+// again.
+type CachedStorage struct {
+	Storage
+	ttl time.Duration
+
+	mu      sync.Mutex
+	rates   map[string]float64
+	ratesAt time.Time
+	pairs   map[[2]string]cachedRate
+}
+
+type cachedRate struct {
+	rate ExchangeRate
+	at   time.Time
+}
+
+// NewCachedStorage returns storage wrapped in a cache, or storage itself when ttl is zero.
+func NewCachedStorage(storage Storage, ttl time.Duration) Storage {
+	if ttl <= 0 {
+		return storage
+	}
+	return &CachedStorage{Storage: storage, ttl: ttl, pairs: make(map[[2]string]cachedRate)}
+}
+
+func (c *CachedStorage) GetExchangeRates(ctx context.Context) (map[string]float64, error) {
+	c.mu.Lock()
+	if c.rates != nil && time.Since(c.ratesAt) < c.ttl {
+		rates := copyRates(c.rates)
+		c.mu.Unlock()
+		return rates, nil
+	}
+	c.mu.Unlock()
+
+	rates, err := c.Storage.GetExchangeRates(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rates, c.ratesAt = copyRates(rates), time.Now()
+	return rates, nil
+}
+
+func (c *CachedStorage) GetExchangeRate(ctx context.Context, fromCurrency, toCurrency string) (ExchangeRate, error) {
+	key := [2]string{fromCurrency, toCurrency}
+	c.mu.Lock()
+	if cached, ok := c.pairs[key]; ok && time.Since(cached.at) < c.ttl {
+		c.mu.Unlock()
+		return cached.rate, nil
+	}
+	c.mu.Unlock()
+
+	rate, err := c.Storage.GetExchangeRate(ctx, fromCurrency, toCurrency)
+	if err != nil {
+		return ExchangeRate{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pairs[key] = cachedRate{rate: rate, at: time.Now()}
+	return rate, nil
+}
+
+func copyRates(rates map[string]float64) map[string]float64 {
+	copied := make(map[string]float64, len(rates))
+	for currency, rate := range rates {
+		copied[currency] = rate
+	}
+	return copied
+}
@@ -1,7 +1,10 @@
 package storages
 
+import "time"
+
 type ExchangeRate struct {
 	FromCurrency string
 	ToCurrency   string
 	Rate         float32
+	UpdatedAt    time.Time
 }
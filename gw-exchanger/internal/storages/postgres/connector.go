@@ -1,28 +1,62 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
+	"time"
 
+	"github.com/XSAM/otelsql"
 	_ "github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 )
 
 type PostgresStorage struct {
 	db *sql.DB
 }
 
-func NewPostgresStorage(dsn string) (*PostgresStorage, error) {
-	fmt.Printf("Connecting to database with DSN: %s\n", dsn)
-	db, err := sql.Open("postgres", dsn)
+// Options holds the connection pool settings.
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	// ConnectTimeout bounds the initial connection check.
+	ConnectTimeout time.Duration
+}
+
+func NewPostgresStorage(dsn string, opts Options) (*PostgresStorage, error) {
+	slog.Info("Connecting to database", slog.String("dsn", dsn))
+	db, err := otelsql.Open("postgres", dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
-	if err := db.Ping(); err != nil {
+	db.SetMaxOpenConns(opts.MaxOpenConns)
+	db.SetMaxIdleConns(opts.MaxIdleConns)
+	db.SetConnMaxLifetime(opts.ConnMaxLifetime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.ConnectTimeout)
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
 		return nil, fmt.Errorf("failed to ping database: %w", err)
 	}
 	return &PostgresStorage{db: db}, nil
 }
 
+// DB returns the connection pool, e.g. to export its statistics.
+func (ps *PostgresStorage) DB() *sql.DB {
+	return ps.db
+}
+
+// Ping checks that a database connection can be established.
+func (ps *PostgresStorage) Ping(ctx context.Context) error {
+	return ps.db.PingContext(ctx)
+}
+
 func (ps *PostgresStorage) Close() error {
 	return ps.db.Close()
 }
@@ -1,11 +1,15 @@
 package postgres
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"gw-exchanger/internal/storages"
 )
 
-func (ps *PostgresStorage) GetExchangeRates() (map[string]float64, error) {
-	rows, err := ps.db.Query("SELECT from_currency, rate FROM exchange_rates")
+func (ps *PostgresStorage) GetExchangeRates(ctx context.Context) (map[string]float64, error) {
+	rows, err := ps.db.QueryContext(ctx, "SELECT from_currency, rate FROM exchange_rates")
 	if err != nil {
 		return nil, fmt.Errorf("failed to query exchange rates: %w", err)
 	}
@@ -24,13 +28,34 @@ func (ps *PostgresStorage) GetExchangeRates() (map[string]float64, error) {
 	return rates, nil
 }
 
-func (ps *PostgresStorage) GetExchangeRate(fromCurrency, toCurrency string) (float32, error) {
-	var rate float32
-	query := "SELECT rate FROM exchange_rates WHERE from_currency = $1 AND to_currency = $2"
-	err := ps.db.QueryRow(query, fromCurrency, toCurrency).Scan(&rate)
-	if err != nil {
-		return 0, fmt.Errorf("failed to get exchange rate: %w", err)
+func (ps *PostgresStorage) GetExchangeRate(ctx context.Context, fromCurrency, toCurrency string) (storages.ExchangeRate, error) {
+	rate := storages.ExchangeRate{FromCurrency: fromCurrency, ToCurrency: toCurrency}
+	query := "SELECT rate, updated_at FROM exchange_rates WHERE from_currency = $1 AND to_currency = $2"
+	err := ps.db.QueryRowContext(ctx, query, fromCurrency, toCurrency).Scan(&rate.Rate, &rate.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return storages.ExchangeRate{}, fmt.Errorf("%w: %s to %s", storages.ErrRateNotFound, fromCurrency, toCurrency)
+	} else if err != nil {
+		return storages.ExchangeRate{}, fmt.Errorf("failed to get exchange rate: %w", err)
 	}
 
 	return rate, nil
 }
+
+func (ps *PostgresStorage) ListExchangeRates(ctx context.Context) ([]storages.ExchangeRate, error) {
+	rows, err := ps.db.QueryContext(ctx, "SELECT from_currency, to_currency, rate, updated_at FROM exchange_rates")
+	if err != nil {
+		return nil, fmt.Errorf("failed to query exchange rates: %w", err)
+	}
+	defer rows.Close()
+
+	var rates []storages.ExchangeRate
+	for rows.Next() {
+		var rate storages.ExchangeRate
+		if err := rows.Scan(&rate.FromCurrency, &rate.ToCurrency, &rate.Rate, &rate.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		rates = append(rates, rate)
+	}
+
+	return rates, rows.Err()
+}
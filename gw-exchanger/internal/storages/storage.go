@@ -1,6 +1,16 @@
 package storages
 
+import (
+	"context"
+	"errors"
+)
+
+// ErrRateNotFound is returned when there is no exchange rate for the currency pair.
+var ErrRateNotFound = errors.New("exchange rate not found")
+
 type Storage interface {
-	GetExchangeRates() (map[string]float64, error)
-	GetExchangeRate(fromCurrency, toCurrency string) (float32, error)
+	GetExchangeRates(ctx context.Context) (map[string]float64, error)
+	GetExchangeRate(ctx context.Context, fromCurrency, toCurrency string) (ExchangeRate, error)
+	ListExchangeRates(ctx context.Context) ([]ExchangeRate, error)
+	Ping(ctx context.Context) error
 }
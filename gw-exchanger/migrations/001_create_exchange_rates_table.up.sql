@@ -1,4 +1,4 @@
-CREATE TABLE exchange_rates (
+CREATE TABLE IF NOT EXISTS exchange_rates (
     id SERIAL PRIMARY KEY,
     from_currency VARCHAR(3) NOT NULL,
     to_currency VARCHAR(3) NOT NULL,
@@ -6,10 +6,11 @@ CREATE TABLE exchange_rates (
 );
 
 INSERT INTO exchange_rates (from_currency, to_currency, rate)
-VALUES
+SELECT * FROM (VALUES
 ('USD', 'EUR', 0.85),
 ('EUR', 'USD', 1.18),
 ('USD', 'RUB', 70.00),
 ('RUB', 'USD', 0.014),
 ('EUR', 'RUB', 80.00),
-('RUB', 'EUR', 0.0125);
\ No newline at end of file
+('RUB', 'EUR', 0.0125)) AS v (from_currency, to_currency, rate)
+WHERE NOT EXISTS (SELECT 1 FROM exchange_rates);
@@ -0,0 +1 @@
+ALTER TABLE exchange_rates DROP COLUMN IF EXISTS updated_at;
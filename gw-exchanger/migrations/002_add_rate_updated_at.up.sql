@@ -0,0 +1,2 @@
+-- Time of the last rate update, exported as the rate age metric.
+ALTER TABLE exchange_rates ADD COLUMN IF NOT EXISTS updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
@@ -0,0 +1,8 @@
+// Package migrations holds the versioned SQL migrations of the exchanger database. Each version has a
+// NNN_name.up.sql file applying it and a NNN_name.down.sql file reverting it, see internal/migrate.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS
@@ -1,19 +1,93 @@
 package utils
 
 import (
-	"log"
-	"os"
-)
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"strings"
 
-var (
-	InfoLogger  = log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime|log.Lshortfile)
-	ErrorLogger = log.New(os.Stderr, "ERROR: ", log.Ldate|log.Ltime|log.Lshortfile)
+	"go.opentelemetry.io/otel/trace"
 )
 
-func LogInfo(message string) {
-	InfoLogger.Println(message)
+// secretKeys are attribute keys whose values are never logged.
+var secretKeys = map[string]bool{
+	"password":      true,
+	"secret":        true,
+	"token":         true,
+	"authorization": true,
+}
+
+// NewLogger returns a leveled logger writing JSON or text records to w. Fields stored in the context with
+// WithFields are added to every record, secrets are redacted and passwords are removed from DSNs.
+func NewLogger(level string, format string, w io.Writer) (*slog.Logger, error) {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl, ReplaceAttr: redact}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+type fieldsKey struct{}
+
+// WithFields returns a copy of ctx carrying additional fields that are added to every record logged with it.
+func WithFields(ctx context.Context, attrs ...slog.Attr) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	merged := append(append([]slog.Attr(nil), fields...), attrs...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// contextHandler adds the fields stored in the context and the current trace and span IDs to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fields, ok := ctx.Value(fieldsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(fields...)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	switch {
+	case secretKeys[key]:
+		return slog.String(a.Key, "[REDACTED]")
+	case key == "dsn" || key == "database_url":
+		return slog.String(a.Key, RedactDSN(a.Value.String()))
+	}
+	return a
 }
 
-func LogError(err error) {
-	ErrorLogger.Println(err)
+// RedactDSN removes the password from a postgres:// URL. Values that are not URLs are dropped entirely.
+func RedactDSN(dsn string) string {
+	u, err := url.Parse(dsn)
+	if err != nil || u.Scheme == "" {
+		return "[REDACTED]"
+	}
+	return u.Redacted()
 }
@@ -0,0 +1,42 @@
+// Package apierror writes the error responses of the wallet API. Every error is answered with the same JSON
+// body, so clients can branch on a stable code instead of parsing messages.
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"wallet/internal/logging"
+)
+
+// Codes of errors reported by several packages. Domain errors have their own codes, see the handler package.
+const (
+	CodeInvalidRequest   = "invalid_request"
+	CodeValidationFailed = "validation_failed"
+	CodeBodyTooLarge     = "body_too_large"
+	CodeUnauthorized     = "unauthorized"
+	CodeForbidden        = "forbidden"
+	CodeRateLimited      = "rate_limited"
+	CodeInternal         = "internal_error"
+)
+
+// Response is the body of an error response. Details lists the individual problems when there are several,
+// e.g. every invalid field of a request. RequestID is the ID the request is logged with.
+type Response struct {
+	Code      string   `json:"code"`
+	Message   string   `json:"message"`
+	Details   []string `json:"details,omitempty"`
+	RequestID string   `json:"request_id,omitempty"`
+}
+
+// Write answers the request with status and an error body.
+func Write(w http.ResponseWriter, r *http.Request, status int, code string, message string, details ...string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(Response{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: logging.RequestID(r.Context()),
+	})
+}
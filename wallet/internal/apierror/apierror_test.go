@@ -0,0 +1,39 @@
+package apierror
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wallet/internal/logging"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWrite(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet/withdraw", nil)
+	req = req.WithContext(logging.WithRequestID(req.Context(), "req-1"))
+	rr := httptest.NewRecorder()
+
+	Write(rr, req, http.StatusBadRequest, CodeValidationFailed, "Invalid request", "body field amount: number must be more than 0")
+
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+	var res Response
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+	assert.Equal(t, Response{
+		Code:      CodeValidationFailed,
+		Message:   "Invalid request",
+		Details:   []string{"body field amount: number must be more than 0"},
+		RequestID: "req-1",
+	}, res)
+}
+
+func TestWrite_OmitsEmptyFields(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	Write(rr, httptest.NewRequest(http.MethodGet, "/", nil), http.StatusInternalServerError, CodeInternal, "Internal server error")
+
+	assert.JSONEq(t, `{"code": "internal_error", "message": "Internal server error"}`, rr.Body.String())
+}
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// PasswordConfig holds the argon2id parameters used for new password hashes.
+type PasswordConfig struct {
+	Time      uint32
+	MemoryKiB uint32
+	Threads   uint8
+	SaltLen   uint32
+	KeyLen    uint32
+}
+
+// DefaultPasswordConfig follows the OWASP recommendation for argon2id.
+var DefaultPasswordConfig = PasswordConfig{
+	Time:      2,
+	MemoryKiB: 19 * 1024,
+	Threads:   1,
+	SaltLen:   16,
+	KeyLen:    32,
+}
+
+// LoadPasswordConfig loads the argon2id parameters from the settings returned by getenv,
+// falling back to DefaultPasswordConfig for the ones that are not set.
+func LoadPasswordConfig(getenv func(string) string) (PasswordConfig, error) {
+	cfg := DefaultPasswordConfig
+	for name, target := range map[string]*uint32{
+		"PASSWORD_ARGON2_TIME":       &cfg.Time,
+		"PASSWORD_ARGON2_MEMORY_KIB": &cfg.MemoryKiB,
+	} {
+		value := getenv(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || n == 0 {
+			return PasswordConfig{}, fmt.Errorf("invalid %s: %q", name, value)
+		}
+		*target = uint32(n)
+	}
+	if value := getenv("PASSWORD_ARGON2_THREADS"); value != "" {
+		n, err := strconv.ParseUint(value, 10, 8)
+		if err != nil || n == 0 {
+			return PasswordConfig{}, fmt.Errorf("invalid PASSWORD_ARGON2_THREADS: %q", value)
+		}
+		cfg.Threads = uint8(n)
+	}
+	return cfg, nil
+}
+
+// PasswordHasher hashes passwords with argon2id and verifies both argon2id and legacy SHA-256 hashes.
+type PasswordHasher struct {
+	cfg PasswordConfig
+}
+
+// NewPasswordHasher returns a PasswordHasher that hashes new passwords with the given parameters.
+func NewPasswordHasher(cfg PasswordConfig) *PasswordHasher {
+	return &PasswordHasher{cfg: cfg}
+}
+
+// Hash returns the password hash encoded in the PHC string format,
+// e.g. $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>.
+func (h *PasswordHasher) Hash(password string) (string, error) {
+	salt := make([]byte, h.cfg.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, h.cfg.Time, h.cfg.MemoryKiB, h.cfg.Threads, h.cfg.KeyLen)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.cfg.MemoryKiB, h.cfg.Time, h.cfg.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+// Verify reports whether the password matches the stored hash. needsRehash is true when the hash is
+// a legacy unsalted SHA-256 hash or was created with parameters other than the configured ones,
+// so the caller can store a fresh hash after a successful login.
+func (h *PasswordHasher) Verify(password string, encoded string) (ok bool, needsRehash bool, err error) {
+	if !strings.HasPrefix(encoded, "$argon2id$") {
+		return verifyLegacySHA256(password, encoded), true, nil
+	}
+
+	var version int
+	var cfg PasswordConfig
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, errors.New("malformed argon2id hash")
+	}
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, errors.New("unsupported argon2id version")
+	}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &cfg.MemoryKiB, &cfg.Time, &cfg.Threads); err != nil {
+		return false, false, errors.New("malformed argon2id parameters")
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, errors.New("malformed argon2id salt")
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, errors.New("malformed argon2id key")
+	}
+
+	candidate := argon2.IDKey([]byte(password), salt, cfg.Time, cfg.MemoryKiB, cfg.Threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(candidate, key) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash = cfg.Time != h.cfg.Time || cfg.MemoryKiB != h.cfg.MemoryKiB || cfg.Threads != h.cfg.Threads ||
+		uint32(len(salt)) != h.cfg.SaltLen || uint32(len(key)) != h.cfg.KeyLen
+	return true, needsRehash, nil
+}
+
+// verifyLegacySHA256 checks a password against the unsalted hex encoded SHA-256 hashes stored before argon2id.
+func verifyLegacySHA256(password string, encoded string) bool {
+	hash := sha256.Sum256([]byte(password))
+	return subtle.ConstantTimeCompare([]byte(hex.EncodeToString(hash[:])), []byte(encoded)) == 1
+}
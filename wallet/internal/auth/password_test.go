@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testPasswordConfig = PasswordConfig{Time: 1, MemoryKiB: 1024, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+func TestPasswordHasher_HashAndVerify(t *testing.T) {
+	h := NewPasswordHasher(testPasswordConfig)
+
+	encoded, err := h.Hash("s3cret")
+	require.NoError(t, err)
+
+	ok, needsRehash, err := h.Verify("s3cret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, needsRehash)
+
+	ok, _, err = h.Verify("wrong", encoded)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestPasswordHasher_LegacySHA256NeedsRehash(t *testing.T) {
+	h := NewPasswordHasher(testPasswordConfig)
+	sum := sha256.Sum256([]byte("s3cret"))
+
+	ok, needsRehash, err := h.Verify("s3cret", hex.EncodeToString(sum[:]))
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+}
+
+func TestPasswordHasher_ChangedParametersNeedRehash(t *testing.T) {
+	old := NewPasswordHasher(testPasswordConfig)
+	encoded, err := old.Hash("s3cret")
+	require.NoError(t, err)
+
+	stronger := testPasswordConfig
+	stronger.Time = 2
+	ok, needsRehash, err := NewPasswordHasher(stronger).Verify("s3cret", encoded)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, needsRehash)
+}
@@ -0,0 +1,53 @@
+package auth
+
+// Permissions granted to roles and checked on routes.
+const (
+	PermWalletRead   = "wallet:read"
+	PermWalletWrite  = "wallet:write"
+	PermWalletAdjust = "wallet:adjust"
+	PermRatesRead    = "rates:read"
+	PermUsersAdmin   = "users:admin"
+)
+
+// Roles known to the wallet.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
+
+// rolePermissions maps every role to the permissions it grants.
+var rolePermissions = map[string][]string{
+	RoleUser:  {PermWalletRead, PermWalletWrite, PermRatesRead},
+	RoleAdmin: {PermWalletRead, PermWalletWrite, PermWalletAdjust, PermRatesRead, PermUsersAdmin},
+}
+
+// IsRole reports whether role is a known role.
+func IsRole(role string) bool {
+	_, ok := rolePermissions[role]
+	return ok
+}
+
+// PermissionsFor returns the union of the permissions granted by the roles. Unknown roles grant nothing.
+func PermissionsFor(roles []string) []string {
+	seen := make(map[string]bool)
+	var perms []string
+	for _, role := range roles {
+		for _, perm := range rolePermissions[role] {
+			if !seen[perm] {
+				seen[perm] = true
+				perms = append(perms, perm)
+			}
+		}
+	}
+	return perms
+}
+
+// Can reports whether the principal was granted the permission.
+func (p *Principal) Can(permission string) bool {
+	for _, scope := range p.Scopes {
+		if scope == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,28 @@
+package auth
+
+import "context"
+
+// Principal is the authenticated caller of a request. Scopes are the permissions the caller was granted.
+// Callers authenticated with an API key have an APIKeyID and no SessionID.
+type Principal struct {
+	UserID    int32
+	Username  string
+	SessionID string
+	APIKeyID  string
+	Roles     []string
+	Scopes    []string
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored by the authentication middleware,
+// or nil when the request did not pass through it.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
@@ -0,0 +1,376 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms.
+const (
+	AlgorithmHS256 = "HS256"
+	AlgorithmRS256 = "RS256"
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// Default iss and aud claims, used when JWT_ISSUER or JWT_AUDIENCE are not set. Both claims are always
+// checked, so tokens of other issuers or for other audiences are never accepted.
+const (
+	DefaultIssuer   = "wallet"
+	DefaultAudience = "wallet-api"
+)
+
+// Key is a single signing or verification key identified by the kid header of the tokens it signs.
+type Key struct {
+	ID string
+	// Secret is the shared secret of an HS256 key.
+	Secret []byte
+	// PEM is the PEM encoded private or public key of an RS256 or EdDSA key.
+	// Keys with only a public part can verify tokens but not sign them, which is how retired keys are kept during rotation.
+	PEM []byte
+}
+
+// Config holds the token signing and validation settings.
+type Config struct {
+	Algorithm    string
+	SigningKeyID string
+	Keys         []Key
+	Issuer       string
+	Audience     string
+	AccessTTL    time.Duration
+	RefreshTTL   time.Duration
+}
+
+// LoadConfig loads the token configuration from the settings returned by getenv, e.g. os.Getenv.
+// JWT_KEYS is a comma separated list of kid=value pairs, where value is the shared secret for HS256
+// and the path to a PEM file for RS256 and EdDSA.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	cfg := Config{
+		Algorithm:    getenv("JWT_ALGORITHM"),
+		SigningKeyID: getenv("JWT_SIGNING_KEY_ID"),
+		Issuer:       getenv("JWT_ISSUER"),
+		Audience:     getenv("JWT_AUDIENCE"),
+		AccessTTL:    15 * time.Minute,
+		RefreshTTL:   30 * 24 * time.Hour,
+	}
+	if cfg.Algorithm == "" {
+		cfg.Algorithm = AlgorithmHS256
+	}
+	if cfg.Issuer == "" {
+		cfg.Issuer = DefaultIssuer
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = DefaultAudience
+	}
+	if ttl := getenv("JWT_ACCESS_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid JWT_ACCESS_TTL: %w", err)
+		}
+		cfg.AccessTTL = d
+	}
+	if ttl := getenv("JWT_REFRESH_TTL"); ttl != "" {
+		d, err := time.ParseDuration(ttl)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid JWT_REFRESH_TTL: %w", err)
+		}
+		cfg.RefreshTTL = d
+	}
+
+	for _, pair := range strings.Split(getenv("JWT_KEYS"), ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		kid, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok || kid == "" || value == "" {
+			return Config{}, fmt.Errorf("invalid JWT_KEYS entry %q, expected kid=value", pair)
+		}
+		key := Key{ID: kid}
+		if cfg.Algorithm == AlgorithmHS256 {
+			key.Secret = []byte(value)
+		} else {
+			pem, err := os.ReadFile(value)
+			if err != nil {
+				return Config{}, fmt.Errorf("failed to read key %q: %w", kid, err)
+			}
+			key.PEM = pem
+		}
+		cfg.Keys = append(cfg.Keys, key)
+	}
+	return cfg, nil
+}
+
+// Claims are the claims carried by an access token.
+type Claims struct {
+	UserID    int32    `json:"uid"`
+	Username  string   `json:"username"`
+	SessionID string   `json:"sid"`
+	Roles     []string `json:"roles"`
+	// Purpose is empty for access tokens and names the single step a special-purpose token is valid for.
+	Purpose string `json:"typ,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// verificationKey is a parsed key usable to verify tokens, and to sign them when signer is set.
+type verificationKey struct {
+	verify interface{}
+	signer interface{}
+}
+
+// Manager issues and verifies access tokens.
+type Manager struct {
+	cfg    Config
+	method jwt.SigningMethod
+	keys   map[string]verificationKey
+	parser *jwt.Parser
+}
+
+// NewManager parses the configured keys and returns a Manager.
+// It fails when the algorithm is unknown, the issuer or audience is missing, a key cannot be parsed or the
+// signing key cannot sign.
+func NewManager(cfg Config) (*Manager, error) {
+	m := &Manager{cfg: cfg, keys: make(map[string]verificationKey)}
+
+	switch cfg.Algorithm {
+	case AlgorithmHS256:
+		m.method = jwt.SigningMethodHS256
+	case AlgorithmRS256:
+		m.method = jwt.SigningMethodRS256
+	case AlgorithmEdDSA:
+		m.method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", cfg.Algorithm)
+	}
+	if cfg.AccessTTL <= 0 || cfg.RefreshTTL <= 0 {
+		return nil, errors.New("token TTLs must be positive")
+	}
+	if cfg.Issuer == "" || cfg.Audience == "" {
+		return nil, errors.New("token issuer and audience are required")
+	}
+
+	for _, key := range cfg.Keys {
+		if _, ok := m.keys[key.ID]; ok {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		parsed, err := parseKey(cfg.Algorithm, key)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", key.ID, err)
+		}
+		m.keys[key.ID] = parsed
+	}
+
+	signing, ok := m.keys[cfg.SigningKeyID]
+	if !ok {
+		return nil, fmt.Errorf("signing key %q is not configured", cfg.SigningKeyID)
+	}
+	if signing.signer == nil {
+		return nil, fmt.Errorf("signing key %q has no private part", cfg.SigningKeyID)
+	}
+
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{m.method.Alg()}),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(30 * time.Second),
+		jwt.WithIssuer(cfg.Issuer),
+		jwt.WithAudience(cfg.Audience),
+	}
+	m.parser = jwt.NewParser(opts...)
+
+	return m, nil
+}
+
+// parseKey converts a configured key into its verification and signing parts.
+func parseKey(algorithm string, key Key) (verificationKey, error) {
+	switch algorithm {
+	case AlgorithmHS256:
+		if len(key.Secret) < 32 {
+			return verificationKey{}, errors.New("HS256 secret must be at least 32 bytes")
+		}
+		return verificationKey{verify: key.Secret, signer: key.Secret}, nil
+	case AlgorithmRS256:
+		if private, err := jwt.ParseRSAPrivateKeyFromPEM(key.PEM); err == nil {
+			return verificationKey{verify: &private.PublicKey, signer: private}, nil
+		}
+		public, err := jwt.ParseRSAPublicKeyFromPEM(key.PEM)
+		if err != nil {
+			return verificationKey{}, fmt.Errorf("invalid RSA key: %w", err)
+		}
+		return verificationKey{verify: public}, nil
+	case AlgorithmEdDSA:
+		if private, err := jwt.ParseEdPrivateKeyFromPEM(key.PEM); err == nil {
+			return verificationKey{verify: private.(ed25519.PrivateKey).Public(), signer: private}, nil
+		}
+		public, err := jwt.ParseEdPublicKeyFromPEM(key.PEM)
+		if err != nil {
+			return verificationKey{}, fmt.Errorf("invalid Ed25519 key: %w", err)
+		}
+		return verificationKey{verify: public}, nil
+	}
+	return verificationKey{}, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+}
+
+// PurposeTwoFactor marks a challenge token that can only be exchanged for a session by a second factor.
+const PurposeTwoFactor = "2fa"
+
+// challengeTTL is how long the user has to enter the second factor after the password.
+const challengeTTL = 5 * time.Minute
+
+// Issue signs a new access token for the user's session with the current signing key.
+func (m *Manager) Issue(uid int32, username string, sessionID string, roles []string) (string, time.Time, error) {
+	return m.sign(Claims{
+		UserID:    uid,
+		Username:  username,
+		SessionID: sessionID,
+		Roles:     roles,
+	}, m.cfg.AccessTTL)
+}
+
+// IssueChallenge signs a short-lived two-factor challenge token for a user who passed the password check.
+// It is not accepted as an access token.
+func (m *Manager) IssueChallenge(uid int32, username string) (string, time.Time, error) {
+	return m.sign(Claims{UserID: uid, Username: username, Purpose: PurposeTwoFactor}, challengeTTL)
+}
+
+// sign fills in the registered claims and signs the token with the current signing key.
+func (m *Manager) sign(claims Claims, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    m.cfg.Issuer,
+		Subject:   fmt.Sprint(claims.UserID),
+		Audience:  jwt.ClaimStrings{m.cfg.Audience},
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
+	}
+
+	token := jwt.NewWithClaims(m.method, claims)
+	token.Header["kid"] = m.cfg.SigningKeyID
+
+	signed, err := token.SignedString(m.keys[m.cfg.SigningKeyID].signer)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+// RefreshTTL returns how long a refresh token stays valid.
+func (m *Manager) RefreshTTL() time.Duration {
+	return m.cfg.RefreshTTL
+}
+
+// NewRefreshToken generates a random opaque refresh token and the hash under which it is stored.
+func NewRefreshToken() (token string, hash string, err error) {
+	return NewOpaqueToken()
+}
+
+// HashRefreshToken returns the hex encoded SHA-256 hash of a refresh token.
+func HashRefreshToken(token string) string {
+	return HashOpaqueToken(token)
+}
+
+// NewOpaqueToken generates a random URL-safe token, e.g. for links sent by email, and the hash under which it is stored.
+func NewOpaqueToken() (token string, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, HashOpaqueToken(token), nil
+}
+
+// HashOpaqueToken returns the hex encoded SHA-256 hash of an opaque token.
+func HashOpaqueToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Verify parses an access token, checks its signature against the key named by its kid header and validates
+// the exp, iat, iss and aud claims.
+func (m *Manager) Verify(tokenString string) (*Claims, error) {
+	return m.verify(tokenString, "")
+}
+
+// VerifyChallenge parses and validates a two-factor challenge token.
+func (m *Manager) VerifyChallenge(tokenString string) (*Claims, error) {
+	return m.verify(tokenString, PurposeTwoFactor)
+}
+
+// verify parses and validates a token and checks that it was issued for the purpose.
+func (m *Manager) verify(tokenString string, purpose string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := m.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		return key.verify, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims.Purpose != purpose {
+		return nil, fmt.Errorf("token issued for %q cannot be used here", claims.Purpose)
+	}
+	return claims, nil
+}
+
+// JWK is a public key in JSON Web Key format.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public verification keys. Shared HS256 secrets are never published, so the set is
+// empty for HS256.
+func (m *Manager) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+	for kid, key := range m.keys {
+		if jwk, ok := publicJWK(kid, m.method.Alg(), key.verify); ok {
+			set.Keys = append(set.Keys, jwk)
+		}
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].Kid < set.Keys[j].Kid })
+	return set
+}
+
+// publicJWK converts a public key to its JWK representation.
+func publicJWK(kid string, alg string, key crypto.PublicKey) (JWK, bool) {
+	enc := base64.RawURLEncoding
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return JWK{Kty: "RSA", Kid: kid, Use: "sig", Alg: alg,
+			N: enc.EncodeToString(k.N.Bytes()),
+			E: enc.EncodeToString(big.NewInt(int64(k.E)).Bytes()),
+		}, true
+	case ed25519.PublicKey:
+		return JWK{Kty: "OKP", Kid: kid, Use: "sig", Alg: alg, Crv: "Ed25519", X: enc.EncodeToString(k)}, true
+	}
+	return JWK{}, false
+}
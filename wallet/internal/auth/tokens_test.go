@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func hsConfig(signingKeyID string, keys ...Key) Config {
+	return Config{
+		Algorithm:    AlgorithmHS256,
+		SigningKeyID: signingKeyID,
+		Keys:         keys,
+		Issuer:       "wallet",
+		Audience:     "wallet-api",
+		AccessTTL:    time.Hour,
+		RefreshTTL:   24 * time.Hour,
+	}
+}
+
+func TestManager_IssueAndVerify(t *testing.T) {
+	m, err := NewManager(hsConfig("k1", Key{ID: "k1", Secret: []byte(testSecret)}))
+	require.NoError(t, err)
+
+	token, _, err := m.Issue(42, "alice", "s1", []string{RoleUser})
+	require.NoError(t, err)
+
+	claims, err := m.Verify(token)
+	require.NoError(t, err)
+	assert.Equal(t, int32(42), claims.UserID)
+	assert.Equal(t, "alice", claims.Username)
+	assert.Equal(t, "s1", claims.SessionID)
+}
+
+func TestManager_RotatedKeyStillVerifies(t *testing.T) {
+	oldKey := Key{ID: "k1", Secret: []byte(testSecret)}
+	newKey := Key{ID: "k2", Secret: []byte(testSecret + "-rotated")}
+
+	old, err := NewManager(hsConfig("k1", oldKey))
+	require.NoError(t, err)
+	token, _, err := old.Issue(1, "alice", "s1", []string{RoleUser})
+	require.NoError(t, err)
+
+	rotated, err := NewManager(hsConfig("k2", oldKey, newKey))
+	require.NoError(t, err)
+	_, err = rotated.Verify(token)
+	assert.NoError(t, err)
+
+	retired, err := NewManager(hsConfig("k2", newKey))
+	require.NoError(t, err)
+	_, err = retired.Verify(token)
+	assert.Error(t, err)
+}
+
+func TestManager_RejectsWrongAudience(t *testing.T) {
+	key := Key{ID: "k1", Secret: []byte(testSecret)}
+	other := hsConfig("k1", key)
+	other.Audience = "other-api"
+
+	issuer, err := NewManager(other)
+	require.NoError(t, err)
+	token, _, err := issuer.Issue(1, "alice", "s1", []string{RoleUser})
+	require.NoError(t, err)
+
+	m, err := NewManager(hsConfig("k1", key))
+	require.NoError(t, err)
+	_, err = m.Verify(token)
+	assert.Error(t, err)
+}
+
+func TestManager_RejectsWrongIssuer(t *testing.T) {
+	key := Key{ID: "k1", Secret: []byte(testSecret)}
+	other := hsConfig("k1", key)
+	other.Issuer = "other"
+
+	issuer, err := NewManager(other)
+	require.NoError(t, err)
+	token, _, err := issuer.Issue(1, "alice", "s1", []string{RoleUser})
+	require.NoError(t, err)
+
+	m, err := NewManager(hsConfig("k1", key))
+	require.NoError(t, err)
+	_, err = m.Verify(token)
+	assert.Error(t, err)
+}
+
+func TestNewManager_RequiresIssuerAndAudience(t *testing.T) {
+	cfg := hsConfig("k1", Key{ID: "k1", Secret: []byte(testSecret)})
+	cfg.Audience = ""
+
+	_, err := NewManager(cfg)
+	assert.ErrorContains(t, err, "issuer and audience are required")
+}
+
+func TestLoadConfig_DefaultsIssuerAndAudience(t *testing.T) {
+	cfg, err := LoadConfig(func(key string) string {
+		if key == "JWT_KEYS" {
+			return "k1=" + testSecret
+		}
+		return ""
+	})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultIssuer, cfg.Issuer)
+	assert.Equal(t, DefaultAudience, cfg.Audience)
+}
+
+func TestManager_EdDSAPublishesJWKS(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+	block := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+
+	m, err := NewManager(Config{
+		Algorithm:    AlgorithmEdDSA,
+		SigningKeyID: "ed1",
+		Keys:         []Key{{ID: "ed1", PEM: block}},
+		Issuer:       DefaultIssuer,
+		Audience:     DefaultAudience,
+		AccessTTL:    time.Hour,
+		RefreshTTL:   24 * time.Hour,
+	})
+	require.NoError(t, err)
+
+	token, _, err := m.Issue(7, "bob", "s1", []string{RoleUser})
+	require.NoError(t, err)
+	_, err = m.Verify(token)
+	assert.NoError(t, err)
+
+	jwks := m.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	assert.Equal(t, "OKP", jwks.Keys[0].Kty)
+	assert.Equal(t, "ed1", jwks.Keys[0].Kid)
+}
@@ -0,0 +1,49 @@
+package auth
+
+import (
+	"encoding/base32"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfc6238Secret is the SHA-1 test key from RFC 6238 appendix B.
+var rfc6238Secret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestValidateTOTP_RFC6238Vectors(t *testing.T) {
+	for unix, code := range map[int64]string{59: "287082", 1111111109: "081804", 1234567890: "005924"} {
+		step, ok := ValidateTOTP(rfc6238Secret, code, time.Unix(unix, 0))
+		assert.True(t, ok, "code %s at %d", code, unix)
+		assert.Equal(t, unix/30, step)
+	}
+}
+
+func TestValidateTOTP_RejectsOutsideWindow(t *testing.T) {
+	_, ok := ValidateTOTP(rfc6238Secret, "287082", time.Unix(59+3*30, 0))
+	assert.False(t, ok)
+}
+
+func TestRecoveryCodes_HashIgnoresFormatting(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes(3)
+	require.NoError(t, err)
+	require.Len(t, codes, 3)
+
+	assert.Equal(t, hashes[0], HashRecoveryCode(" "+codes[0]+" "))
+	assert.NotEqual(t, hashes[0], hashes[1])
+}
+
+func TestChallengeTokenIsNotAnAccessToken(t *testing.T) {
+	m, err := NewManager(hsConfig("k1", Key{ID: "k1", Secret: []byte(testSecret)}))
+	require.NoError(t, err)
+
+	challenge, _, err := m.IssueChallenge(1, "alice")
+	require.NoError(t, err)
+
+	_, err = m.Verify(challenge)
+	assert.Error(t, err)
+	claims, err := m.VerifyChallenge(challenge)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), claims.UserID)
+}
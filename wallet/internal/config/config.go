@@ -0,0 +1,428 @@
+// Package config loads the configuration of the wallet service from a config file, the environment and
+// command line flags.
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/limits"
+	"wallet/internal/logging"
+	"wallet/internal/mail"
+	"wallet/internal/ratelimit"
+	"wallet/internal/repository"
+	"wallet/internal/service"
+	"wallet/internal/tracing"
+
+	"github.com/joho/godotenv"
+)
+
+// Sources of a setting, in increasing precedence.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// HTTPConfig holds the HTTP server settings.
+type HTTPConfig struct {
+	Addr              string
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxBodyBytes      int64
+	// ShutdownTimeout bounds how long in-flight requests may take to finish after SIGTERM.
+	ShutdownTimeout time.Duration
+	// HealthCheckTimeout bounds each dependency check of the readiness probe.
+	HealthCheckTimeout time.Duration
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For and X-Real-IP headers name the client.
+	TrustedProxies []netip.Prefix
+}
+
+// ExchangerConfig holds the settings of the connection to the exchanger.
+type ExchangerConfig struct {
+	Addr    string
+	Timeout time.Duration
+	// TLS is nil when the connection is not encrypted.
+	TLS *tls.Config
+}
+
+// RateLimitConfig holds the request limits of the route groups.
+type RateLimitConfig struct {
+	// Auth applies per IP address to the public login, registration and password routes.
+	Auth ratelimit.Limit
+	// Write applies per user to deposits, withdrawals and exchanges.
+	Write ratelimit.Limit
+	// Default applies per user to the other authenticated routes.
+	Default ratelimit.Limit
+	// IP applies per IP address to all authenticated routes before the credentials are checked, so that
+	// invalid tokens and guessed API keys are limited as well.
+	IP ratelimit.Limit
+}
+
+// Config is the complete configuration of the wallet service.
+type Config struct {
+	HTTP      HTTPConfig
+	Database  repository.Config
+	Exchanger ExchangerConfig
+	Service   service.Config
+	Tokens    auth.Config
+	Passwords auth.PasswordConfig
+	Mail      mail.Config
+	// MailDispatchInterval is how often queued emails are delivered.
+	MailDispatchInterval time.Duration
+	RateLimits           RateLimitConfig
+	Logging              logging.Config
+	Tracing              tracing.Config
+
+	// PrintConfig is set by the -print-config flag, which asks to print the configuration and exit.
+	PrintConfig bool
+	// Command is the subcommand given after the flags with its arguments, e.g. migrate up. It is
+	// empty when the server should run.
+	Command []string
+
+	values map[string]value
+}
+
+// value is the effective value of a setting and where it came from.
+type value struct {
+	value  string
+	source string
+}
+
+// Load resolves every setting from, in increasing precedence, its default, the config file, the
+// environment as returned by lookupEnv and the command line flags in args, and validates the result.
+// The config file is optional unless named explicitly with -config. All invalid settings are reported
+// together.
+func Load(args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	flags := flag.NewFlagSet("wallet", flag.ContinueOnError)
+	path := flags.String("config", "config.env", "path of the config file")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration with secrets masked and exit")
+	flagValues := make(map[string]*string, len(settings))
+	for _, s := range settings {
+		flagValues[s.Key] = flags.String(flagName(s.Key), s.Default, s.Usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 && flags.Arg(0) != "migrate" {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	file, err := godotenv.Read(*path)
+	if errors.Is(err, fs.ErrNotExist) && !set["config"] {
+		file = nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	values := make(map[string]value, len(settings))
+	for _, s := range settings {
+		v := value{value: s.Default, source: SourceDefault}
+		if fileValue, ok := file[s.Key]; ok {
+			v = value{value: fileValue, source: SourceFile}
+		}
+		if envValue, ok := lookupEnv(s.Key); ok {
+			v = value{value: envValue, source: SourceEnv}
+		}
+		if set[flagName(s.Key)] {
+			v = value{value: *flagValues[s.Key], source: SourceFlag}
+		}
+		values[s.Key] = v
+	}
+	for key := range file {
+		if !known(key) {
+			return nil, fmt.Errorf("unknown setting %s in config file %s", key, *path)
+		}
+	}
+
+	cfg := &Config{PrintConfig: *printConfig, Command: flags.Args(), values: values}
+	if err := cfg.parse(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parse converts the effective values into the typed configuration and validates it.
+func (c *Config) parse() error {
+	p := parser{values: c.values}
+
+	c.HTTP = HTTPConfig{
+		Addr:               p.required("HTTP_ADDR"),
+		ReadHeaderTimeout:  p.duration("HTTP_READ_HEADER_TIMEOUT"),
+		ReadTimeout:        p.duration("HTTP_READ_TIMEOUT"),
+		WriteTimeout:       p.duration("HTTP_WRITE_TIMEOUT"),
+		IdleTimeout:        p.duration("HTTP_IDLE_TIMEOUT"),
+		MaxBodyBytes:       int64(p.integer("HTTP_MAX_BODY_BYTES", 1, 1<<30)),
+		ShutdownTimeout:    p.duration("SHUTDOWN_TIMEOUT"),
+		HealthCheckTimeout: p.duration("HEALTH_CHECK_TIMEOUT"),
+		TrustedProxies:     p.prefixes("HTTP_TRUSTED_PROXIES"),
+	}
+
+	c.Database = repository.Config{
+		Host:            p.required("DB_HOST"),
+		Port:            p.port("DB_PORT"),
+		Username:        p.required("DB_USER"),
+		Password:        p.get("DB_PASSWORD"),
+		DBName:          p.required("DB_NAME"),
+		SSLMode:         p.get("DB_SSLMODE"),
+		MaxOpenConns:    p.integer("DB_MAX_OPEN_CONNS", 1, 10000),
+		MaxIdleConns:    p.integer("DB_MAX_IDLE_CONNS", 0, 10000),
+		ConnMaxLifetime: p.duration("DB_CONN_MAX_LIFETIME"),
+	}
+	if c.Database.MaxIdleConns > c.Database.MaxOpenConns && c.values["DB_MAX_IDLE_CONNS"].source == SourceDefault {
+		c.Database.MaxIdleConns = c.Database.MaxOpenConns
+	} else if c.Database.MaxIdleConns > c.Database.MaxOpenConns {
+		p.fail("DB_MAX_IDLE_CONNS", fmt.Errorf("must not exceed DB_MAX_OPEN_CONNS (%d)", c.Database.MaxOpenConns))
+	}
+
+	c.Exchanger = ExchangerConfig{
+		Addr:    p.required("EXCHANGER_ADDR"),
+		Timeout: p.duration("EXCHANGER_TIMEOUT"),
+		TLS:     p.exchangerTLS(),
+	}
+
+	c.Service = service.Config{
+		ExchangeFeeBps: int32(p.integer("EXCHANGE_FEE_BPS", 0, 9999)),
+		TOTPIssuer:     p.get("TOTP_ISSUER"),
+		PublicURL:      p.url("PUBLIC_URL"),
+		LoginThrottle:  service.DefaultLoginThrottle,
+		Limits: limits.Policy{
+			limits.OperationWithdraw: p.caps("LIMITS_WITHDRAW"),
+			limits.OperationExchange: p.caps("LIMITS_EXCHANGE"),
+			limits.OperationTransfer: p.caps("LIMITS_TRANSFER"),
+		},
+	}
+
+	var err error
+	if c.Tokens, err = auth.LoadConfig(p.get); err != nil {
+		p.add(err)
+	} else if _, err := auth.NewManager(c.Tokens); err != nil {
+		p.add(err)
+	}
+	if c.Passwords, err = auth.LoadPasswordConfig(p.get); err != nil {
+		p.add(err)
+	}
+
+	c.Mail = mail.LoadConfig(p.get)
+	if _, err := mail.NewSender(c.Mail); err != nil {
+		p.add(err)
+	}
+	c.MailDispatchInterval = p.duration("MAIL_DISPATCH_INTERVAL")
+
+	c.RateLimits = RateLimitConfig{
+		Auth:    p.rateLimit("RATE_LIMIT_AUTH"),
+		Write:   p.rateLimit("RATE_LIMIT_WRITE"),
+		Default: p.rateLimit("RATE_LIMIT_DEFAULT"),
+		IP:      p.rateLimit("RATE_LIMIT_IP"),
+	}
+
+	c.Logging = logging.LoadConfig(p.get)
+	if _, err := logging.New(c.Logging, io.Discard); err != nil {
+		p.add(err)
+	}
+
+	if c.Tracing, err = tracing.LoadConfig(p.get); err != nil {
+		p.add(err)
+	}
+	switch strings.ToLower(c.Tracing.Exporter) {
+	case tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP:
+	default:
+		p.fail("TRACING_EXPORTER", fmt.Errorf("must be %s, %s or %s", tracing.ExporterNone, tracing.ExporterStdout, tracing.ExporterOTLP))
+	}
+
+	if len(p.errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(p.errs...))
+	}
+	return nil
+}
+
+// Print writes the effective value and source of every setting, with secrets masked.
+func (c *Config) Print(w io.Writer) {
+	for _, s := range settings {
+		v := c.values[s.Key]
+		shown := v.value
+		if s.Secret && shown != "" {
+			shown = mask(s.Key, shown)
+		}
+		fmt.Fprintf(w, "%s=%s # %s\n", s.Key, shown, v.source)
+	}
+}
+
+// mask hides a secret value. The key IDs of JWT_KEYS stay visible, they help checking a key rotation.
+func mask(key string, secret string) string {
+	if key != "JWT_KEYS" {
+		return "****"
+	}
+	pairs := strings.Split(secret, ",")
+	for i, pair := range pairs {
+		kid, _, _ := strings.Cut(strings.TrimSpace(pair), "=")
+		pairs[i] = kid + "=****"
+	}
+	return strings.Join(pairs, ",")
+}
+
+// flagName returns the command line flag of a setting, e.g. -db-max-open-conns for DB_MAX_OPEN_CONNS.
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+func known(key string) bool {
+	for _, s := range settings {
+		if s.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// parser converts setting values, collecting every error instead of stopping at the first.
+type parser struct {
+	values map[string]value
+	errs   []error
+}
+
+func (p *parser) get(key string) string {
+	return p.values[key].value
+}
+
+func (p *parser) fail(key string, err error) {
+	p.add(fmt.Errorf("%s: %w", key, err))
+}
+
+// add records an error that already names the setting.
+func (p *parser) add(err error) {
+	p.errs = append(p.errs, err)
+}
+
+func (p *parser) required(key string) string {
+	v := p.get(key)
+	if v == "" {
+		p.fail(key, errors.New("is required"))
+	}
+	return v
+}
+
+func (p *parser) duration(key string) time.Duration {
+	d, err := time.ParseDuration(p.get(key))
+	if err != nil {
+		p.fail(key, fmt.Errorf("invalid duration %q", p.get(key)))
+	} else if d <= 0 {
+		p.fail(key, errors.New("must be positive"))
+	}
+	return d
+}
+
+func (p *parser) rateLimit(key string) ratelimit.Limit {
+	limit, err := ratelimit.ParseLimit(p.get(key))
+	if err != nil {
+		p.fail(key, err)
+	}
+	return limit
+}
+
+func (p *parser) caps(key string) map[string]limits.Cap {
+	caps, err := limits.ParseCaps(p.get(key))
+	if err != nil {
+		p.fail(key, err)
+	}
+	return caps
+}
+
+// prefixes parses a comma separated list of CIDRs, a single address stands for itself.
+func (p *parser) prefixes(key string) []netip.Prefix {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(p.get(key), ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if !strings.Contains(v, "/") {
+			var addr netip.Addr
+			if addr, err = netip.ParseAddr(v); err == nil {
+				prefix = netip.PrefixFrom(addr, addr.BitLen())
+			}
+		}
+		if err != nil {
+			p.fail(key, fmt.Errorf("invalid CIDR %q", v))
+			continue
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes
+}
+
+func (p *parser) integer(key string, min, max int) int {
+	n, err := strconv.Atoi(p.get(key))
+	if err != nil {
+		p.fail(key, fmt.Errorf("invalid number %q", p.get(key)))
+	} else if n < min || n > max {
+		p.fail(key, fmt.Errorf("must be between %d and %d", min, max))
+	}
+	return n
+}
+
+func (p *parser) port(key string) string {
+	v := p.required(key)
+	if n, err := strconv.Atoi(v); v != "" && (err != nil || n < 1 || n > 65535) {
+		p.fail(key, fmt.Errorf("invalid port %q", v))
+	}
+	return v
+}
+
+// exchangerTLS loads the TLS settings of the exchanger connection, nil when EXCHANGER_TLS_CA_FILE is unset.
+func (p *parser) exchangerTLS() *tls.Config {
+	caFile, certFile, keyFile := p.get("EXCHANGER_TLS_CA_FILE"), p.get("EXCHANGER_TLS_CERT_FILE"), p.get("EXCHANGER_TLS_KEY_FILE")
+	if caFile == "" {
+		if certFile != "" || keyFile != "" {
+			p.fail("EXCHANGER_TLS_CA_FILE", errors.New("is required when a client certificate is set"))
+		}
+		return nil
+	}
+
+	cfg := &tls.Config{ServerName: p.get("EXCHANGER_TLS_SERVER_NAME"), MinVersion: tls.VersionTLS12}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		p.fail("EXCHANGER_TLS_CA_FILE", err)
+		return nil
+	}
+	cfg.RootCAs = x509.NewCertPool()
+	if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+		p.fail("EXCHANGER_TLS_CA_FILE", fmt.Errorf("no certificates found in %s", caFile))
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		p.fail("EXCHANGER_TLS_CERT_FILE", errors.New("must be set together with EXCHANGER_TLS_KEY_FILE"))
+	} else if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			p.fail("EXCHANGER_TLS_CERT_FILE", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg
+}
+
+func (p *parser) url(key string) string {
+	v := p.required(key)
+	if u, err := url.Parse(v); v != "" && (err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "") {
+		p.fail(key, fmt.Errorf("invalid URL %q, expected http(s)://host", v))
+	}
+	return v
+}
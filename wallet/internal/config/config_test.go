@@ -0,0 +1,133 @@
+package config
+
+import (
+	"bytes"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testFile = `DB_HOST=db_file
+DB_USER=wallet_user
+DB_PASSWORD=wallet_password
+DB_NAME=wallet_db
+DB_MAX_OPEN_CONNS=20
+JWT_SIGNING_KEY_ID=dev-1
+JWT_KEYS=dev-1=local-development-secret-change-me
+`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.env")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func env(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := writeConfig(t, testFile)
+
+	cfg, err := Load([]string{"-config", path, "-db-max-open-conns", "40"}, env(map[string]string{
+		"DB_HOST":           "db_env",
+		"DB_MAX_OPEN_CONNS": "30",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "db_env", cfg.Database.Host)
+	assert.Equal(t, 40, cfg.Database.MaxOpenConns)
+	assert.Equal(t, "wallet_db", cfg.Database.DBName)
+	assert.Equal(t, 25, cfg.Database.MaxIdleConns)
+	assert.Equal(t, time.Second, cfg.Exchanger.Timeout)
+	assert.Equal(t, "server:50051", cfg.Exchanger.Addr)
+	assert.Empty(t, cfg.HTTP.TrustedProxies)
+}
+
+func TestLoad_TrustedProxies(t *testing.T) {
+	cfg, err := Load([]string{"-config", writeConfig(t, testFile)}, env(map[string]string{
+		"HTTP_TRUSTED_PROXIES": "10.1.2.3/8, 192.168.0.7,fd00::/8",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.0.7/32"),
+		netip.MustParsePrefix("fd00::/8"),
+	}, cfg.HTTP.TrustedProxies)
+}
+
+func TestLoad_FileIsOptional(t *testing.T) {
+	// There is no config.env in the package directory
+	cfg, err := Load(nil, env(map[string]string{
+		"DB_USER":            "wallet_user",
+		"DB_NAME":            "wallet_db",
+		"JWT_SIGNING_KEY_ID": "dev-1",
+		"JWT_KEYS":           "dev-1=local-development-secret-change-me",
+	}))
+	require.NoError(t, err)
+	assert.Equal(t, "localhost", cfg.Database.Host)
+
+	// A file named explicitly must exist
+	_, err = Load([]string{"-config", "missing.env"}, env(nil))
+	assert.ErrorContains(t, err, "failed to read config file")
+}
+
+func TestLoad_ReportsAllInvalidSettings(t *testing.T) {
+	path := writeConfig(t, testFile)
+
+	_, err := Load([]string{"-config", path}, env(map[string]string{
+		"DB_PORT":                 "postgres",
+		"DB_MAX_IDLE_CONNS":       "30",
+		"HTTP_READ_TIMEOUT":       "soon",
+		"EXCHANGE_FEE_BPS":        "10000",
+		"PUBLIC_URL":              "localhost",
+		"EXCHANGER_TLS_CERT_FILE": "client.pem",
+		"RATE_LIMIT_WRITE":        "many",
+		"LIMITS_WITHDRAW":         "USD=100",
+		"HTTP_TRUSTED_PROXIES":    "10.0.0.0/8,proxy",
+	}))
+	require.Error(t, err)
+	for _, want := range []string{
+		`DB_PORT: invalid port "postgres"`,
+		"DB_MAX_IDLE_CONNS: must not exceed DB_MAX_OPEN_CONNS (20)",
+		`HTTP_READ_TIMEOUT: invalid duration "soon"`,
+		"EXCHANGE_FEE_BPS: must be between 0 and 9999",
+		`PUBLIC_URL: invalid URL "localhost"`,
+		"EXCHANGER_TLS_CA_FILE: is required when a client certificate is set",
+		`RATE_LIMIT_WRITE: invalid rate limit "many"`,
+		`LIMITS_WITHDRAW: invalid limit "USD=100"`,
+		`HTTP_TRUSTED_PROXIES: invalid CIDR "proxy"`,
+	} {
+		assert.ErrorContains(t, err, want)
+	}
+
+	_, err = Load([]string{"-config", writeConfig(t, testFile+"DB_HOTS=db\n")}, env(nil))
+	assert.ErrorContains(t, err, "unknown setting DB_HOTS")
+
+	_, err = Load([]string{"-config", path}, env(map[string]string{"EXCHANGER_TLS_CA_FILE": path}))
+	assert.ErrorContains(t, err, "EXCHANGER_TLS_CA_FILE: no certificates found")
+}
+
+func TestPrint_MasksSecrets(t *testing.T) {
+	path := writeConfig(t, testFile)
+	cfg, err := Load([]string{"-config", path, "-log-level", "debug"}, env(map[string]string{"DB_NAME": "wallet_env"}))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	cfg.Print(&buf)
+	out := buf.String()
+	assert.Contains(t, out, "DB_PASSWORD=**** # file\n")
+	assert.Contains(t, out, "JWT_KEYS=dev-1=**** # file\n")
+	assert.Contains(t, out, "DB_NAME=wallet_env # env\n")
+	assert.Contains(t, out, "LOG_LEVEL=debug # flag\n")
+	assert.Contains(t, out, "HTTP_ADDR=:8080 # default\n")
+	assert.NotContains(t, out, "wallet_password")
+	assert.NotContains(t, out, "local-development-secret")
+}
@@ -0,0 +1,83 @@
+package config
+
+// setting is a single configuration key. Every setting can be given in the config file, as an environment
+// variable and as a command line flag, e.g. DB_MAX_OPEN_CONNS as -db-max-open-conns.
+type setting struct {
+	Key     string
+	Default string
+	Usage   string
+	// Secret settings are masked when the configuration is printed.
+	Secret bool
+}
+
+// settings lists all configuration keys in the order they are printed.
+var settings = []setting{
+	{Key: "HTTP_ADDR", Default: ":8080", Usage: "address the HTTP server listens on"},
+	{Key: "HTTP_READ_HEADER_TIMEOUT", Default: "5s", Usage: "time allowed to read the request headers"},
+	{Key: "HTTP_READ_TIMEOUT", Default: "15s", Usage: "time allowed to read the whole request"},
+	{Key: "HTTP_WRITE_TIMEOUT", Default: "30s", Usage: "time allowed to write the response"},
+	{Key: "HTTP_IDLE_TIMEOUT", Default: "120s", Usage: "time an idle keep-alive connection is kept open"},
+	{Key: "HTTP_MAX_BODY_BYTES", Default: "1048576", Usage: "largest request body accepted"},
+	{Key: "HTTP_TRUSTED_PROXIES", Usage: "comma separated CIDRs of reverse proxies whose X-Forwarded-For and X-Real-IP headers are trusted"},
+	{Key: "SHUTDOWN_TIMEOUT", Default: "25s", Usage: "time in-flight requests may take to finish on shutdown"},
+	{Key: "HEALTH_CHECK_TIMEOUT", Default: "2s", Usage: "time each dependency check of the readiness probe may take"},
+
+	{Key: "DB_HOST", Default: "localhost", Usage: "database host"},
+	{Key: "DB_PORT", Default: "5432", Usage: "database port"},
+	{Key: "DB_USER", Usage: "database user"},
+	{Key: "DB_PASSWORD", Usage: "database password", Secret: true},
+	{Key: "DB_NAME", Usage: "database name"},
+	{Key: "DB_SSLMODE", Default: "disable", Usage: "database sslmode"},
+	{Key: "DB_MAX_OPEN_CONNS", Default: "50", Usage: "maximum number of open database connections"},
+	{Key: "DB_MAX_IDLE_CONNS", Default: "25", Usage: "maximum number of idle database connections"},
+	{Key: "DB_CONN_MAX_LIFETIME", Default: "5m", Usage: "maximum time a database connection is reused"},
+
+	{Key: "EXCHANGER_ADDR", Default: "server:50051", Usage: "gRPC address of the exchanger"},
+	{Key: "EXCHANGER_TIMEOUT", Default: "1s", Usage: "time each exchanger call may take"},
+	{Key: "EXCHANGER_TLS_CA_FILE", Usage: "CA verifying the exchanger certificate, enables TLS when set"},
+	{Key: "EXCHANGER_TLS_CERT_FILE", Usage: "client certificate presented to the exchanger"},
+	{Key: "EXCHANGER_TLS_KEY_FILE", Usage: "key of the client certificate"},
+	{Key: "EXCHANGER_TLS_SERVER_NAME", Usage: "name expected in the exchanger certificate, defaults to the host of EXCHANGER_ADDR"},
+
+	{Key: "EXCHANGE_FEE_BPS", Default: "0", Usage: "exchange fee in basis points, 0 to 9999"},
+	{Key: "TOTP_ISSUER", Default: "Wallet", Usage: "issuer shown in authenticator apps"},
+	{Key: "PUBLIC_URL", Default: "http://localhost:8080", Usage: "externally reachable base URL, used in email links"},
+
+	{Key: "JWT_ALGORITHM", Default: "HS256", Usage: "token signing algorithm: HS256, RS256 or EdDSA"},
+	{Key: "JWT_SIGNING_KEY_ID", Usage: "kid of the key signing new tokens"},
+	{Key: "JWT_KEYS", Usage: "comma separated kid=secret (HS256) or kid=pem-path pairs", Secret: true},
+	{Key: "JWT_ISSUER", Default: "wallet", Usage: "iss claim of the tokens, always checked"},
+	{Key: "JWT_AUDIENCE", Default: "wallet-api", Usage: "aud claim of the tokens, always checked"},
+	{Key: "JWT_ACCESS_TTL", Default: "15m", Usage: "lifetime of access tokens"},
+	{Key: "JWT_REFRESH_TTL", Default: "720h", Usage: "lifetime of refresh tokens"},
+
+	{Key: "PASSWORD_ARGON2_TIME", Default: "2", Usage: "argon2id iterations"},
+	{Key: "PASSWORD_ARGON2_MEMORY_KIB", Default: "19456", Usage: "argon2id memory in KiB"},
+	{Key: "PASSWORD_ARGON2_THREADS", Default: "1", Usage: "argon2id parallelism"},
+
+	{Key: "MAIL_DRIVER", Default: "log", Usage: "mail sender: smtp, file or log"},
+	{Key: "MAIL_FROM", Usage: "sender address of emails"},
+	{Key: "SMTP_HOST", Usage: "SMTP server host"},
+	{Key: "SMTP_PORT", Default: "587", Usage: "SMTP server port"},
+	{Key: "SMTP_USERNAME", Usage: "SMTP user"},
+	{Key: "SMTP_PASSWORD", Usage: "SMTP password", Secret: true},
+	{Key: "MAIL_FILE_PATH", Usage: "file the file mail driver appends to"},
+	{Key: "MAIL_DISPATCH_INTERVAL", Default: "5s", Usage: "how often queued emails are delivered"},
+
+	{Key: "RATE_LIMIT_AUTH", Default: "10/1m", Usage: "requests per client to login, registration and password routes, as requests/window or off"},
+	{Key: "RATE_LIMIT_WRITE", Default: "60/1m", Usage: "requests per user to deposit, withdraw and exchange, as requests/window or off"},
+	{Key: "RATE_LIMIT_DEFAULT", Default: "300/1m", Usage: "requests per user to the other authenticated routes, as requests/window or off"},
+	{Key: "RATE_LIMIT_IP", Default: "600/1m", Usage: "requests per client IP to all authenticated routes, checked before authentication, as requests/window or off"},
+
+	{Key: "LIMITS_WITHDRAW", Default: "RUB=500000/5000000,USD=5000/50000,EUR=5000/50000", Usage: "default withdrawal caps as CURRENCY=DAILY/MONTHLY in currency units, off for no cap"},
+	{Key: "LIMITS_EXCHANGE", Default: "RUB=1000000/10000000,USD=10000/100000,EUR=10000/100000", Usage: "default caps of the sold currency of exchanges, as CURRENCY=DAILY/MONTHLY"},
+	{Key: "LIMITS_TRANSFER", Default: "RUB=500000/5000000,USD=5000/50000,EUR=5000/50000", Usage: "default transfer caps, as CURRENCY=DAILY/MONTHLY"},
+
+	{Key: "LOG_LEVEL", Default: "info", Usage: "log level: debug, info, warn or error"},
+	{Key: "LOG_FORMAT", Default: "json", Usage: "log format: json or text"},
+
+	{Key: "TRACING_EXPORTER", Default: "none", Usage: "span exporter: none, stdout or otlp"},
+	{Key: "OTEL_EXPORTER_OTLP_ENDPOINT", Usage: "URL of the OTLP/gRPC collector"},
+	{Key: "OTEL_SERVICE_NAME", Default: "wallet", Usage: "service name reported in traces"},
+	{Key: "TRACING_SAMPLE_RATIO", Default: "1", Usage: "fraction of traces recorded, 0 to 1"},
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/repository"
+
+	"github.com/gorilla/mux"
+)
+
+// CreateAPIKeyRequest is a struct to represent the request payload for creating an API key.
+type CreateAPIKeyRequest struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// APIKeyResponse is a struct to represent an API key in responses. Key is only set when the key is created.
+type APIKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	Key        string     `json:"key,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// RevokeAPIKeyResponse is a struct to represent the response payload for revoking an API key.
+type RevokeAPIKeyResponse struct {
+	Message string `json:"message"`
+}
+
+// CreateAPIKey is an HTTP handler to create an API key owned by the authenticated user.
+func (h *WalletHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	key, secret, err := h.service.CreateAPIKey(r.Context(), principal.UserID, req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res := apiKeyToResponse(key)
+	res.Key = secret
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// CreateUserAPIKey is an admin HTTP handler to create a read-only API key owned by another user, e.g. the
+// service account of an organization.
+func (h *WalletHandler) CreateUserAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	var req CreateAPIKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	key, secret, err := h.service.CreateUserAPIKey(r.Context(), principal.UserID, mux.Vars(r)["username"], req.Name, req.Scopes, req.ExpiresAt)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res := apiKeyToResponse(key)
+	res.Key = secret
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(res)
+}
+
+// ListAPIKeys is an HTTP handler to list the API keys of the authenticated user.
+func (h *WalletHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	keys, err := h.service.ListAPIKeys(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res := make([]APIKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		res = append(res, apiKeyToResponse(key))
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// RevokeAPIKey is an HTTP handler to revoke an API key of the authenticated user.
+func (h *WalletHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	if err := h.service.RevokeAPIKey(r.Context(), principal.UserID, mux.Vars(r)["id"]); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(RevokeAPIKeyResponse{Message: "API key revoked"})
+}
+
+func apiKeyToResponse(key repository.APIKey) APIKeyResponse {
+	return APIKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Prefix:     auth.APIKeyPrefix + key.Prefix,
+		Scopes:     key.Scopes,
+		CreatedAt:  key.CreatedAt,
+		ExpiresAt:  key.ExpiresAt,
+		LastUsedAt: key.LastUsedAt,
+		RevokedAt:  key.RevokedAt,
+	}
+}
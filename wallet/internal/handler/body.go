@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"net/http"
+	"wallet/internal/apierror"
+)
+
+// LimitBody returns a middleware that rejects request bodies larger than maxBytes. Requests announcing a
+// larger body are refused with 413 before the handler runs, others fail to decode once the limit is read.
+func LimitBody(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				apierror.Write(w, r, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge, "Request body too large")
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitBody(t *testing.T) {
+	decode := LimitBody(16)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	decode.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"a":"b"}`)))
+	assert.Equal(t, http.StatusNoContent, rec.Code)
+
+	rec = httptest.NewRecorder()
+	decode.ServeHTTP(rec, httptest.NewRequest("POST", "/", strings.NewReader(`{"username":"alice"}`)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	// Chunked bodies have no length up front and fail once the limit is read
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"username":"alice"}`))
+	req.ContentLength = -1
+	rec = httptest.NewRecorder()
+	decode.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
@@ -0,0 +1,39 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	for name, tc := range map[string]struct {
+		remoteAddr string
+		headers    map[string]string
+		want       string
+	}{
+		"direct client":                     {"203.0.113.5:1000", nil, "203.0.113.5"},
+		"untrusted sender cannot spoof":     {"203.0.113.5:1000", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Real-IP": "1.2.3.4"}, "203.0.113.5"},
+		"trusted proxy":                     {"10.0.0.1:1000", map[string]string{"X-Forwarded-For": "198.51.100.7"}, "198.51.100.7"},
+		"client prepends a forged address":  {"10.0.0.1:1000", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7, 10.0.0.2"}, "198.51.100.7"},
+		"real ip header":                    {"10.0.0.1:1000", map[string]string{"X-Real-IP": "198.51.100.7"}, "198.51.100.7"},
+		"malformed entry stops at the hop":  {"10.0.0.1:1000", map[string]string{"X-Forwarded-For": "198.51.100.7, garbage"}, "10.0.0.1"},
+		"trusted proxy without the headers": {"10.0.0.1:1000", nil, "10.0.0.1"},
+	} {
+		var got string
+		h := ClientIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = clientInfo(r).IP }))
+
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tc.remoteAddr
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, tc.want, got, name)
+	}
+}
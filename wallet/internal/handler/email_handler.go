@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"wallet/internal/auth"
+)
+
+// EmailResponse is a struct to represent the response payload of the email verification and password reset endpoints.
+type EmailResponse struct {
+	Message string `json:"message"`
+}
+
+// ForgotPasswordRequest is a struct to represent the request payload for requesting a password reset.
+type ForgotPasswordRequest struct {
+	Email string `json:"email"`
+}
+
+// ResetPasswordRequest is a struct to represent the request payload for setting a new password with a reset token.
+type ResetPasswordRequest struct {
+	Token string `json:"token"`
+	Pw    string `json:"pw"`
+}
+
+// VerifyEmail is an HTTP handler to confirm the email address with the token from the verification email.
+func (h *WalletHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.VerifyEmail(r.Context(), r.URL.Query().Get("token")); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(EmailResponse{Message: "Email address verified"})
+}
+
+// ResendVerificationEmail is an HTTP handler to send a new verification email to the authenticated user.
+func (h *WalletHandler) ResendVerificationEmail(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	if err := h.service.SendVerificationEmail(r.Context(), principal.UserID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(EmailResponse{Message: "Verification email sent"})
+}
+
+// ForgotPassword is an HTTP handler to request a password reset email. It answers the same way whether
+// or not an account exists for the address.
+func (h *WalletHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req ForgotPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := h.service.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(EmailResponse{Message: "If an account exists for this address, a password reset email has been sent"})
+}
+
+// ResetPassword is an HTTP handler to set a new password with the token from the password reset email.
+func (h *WalletHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req ResetPasswordRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := h.service.ResetPassword(r.Context(), req.Token, req.Pw); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(EmailResponse{Message: "Password changed, all sessions have been logged out"})
+}
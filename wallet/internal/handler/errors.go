@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet/internal/apierror"
+	"wallet/internal/limits"
+	"wallet/internal/repository"
+	"wallet/internal/service"
+)
+
+// domainError is how a domain error is reported to clients. The error text is the message unless message
+// is set, which hides details of errors that wrap internal causes.
+type domainError struct {
+	err     error
+	status  int
+	code    string
+	message string
+}
+
+// domainErrors maps the errors of the service and repository to HTTP statuses and error codes. The first
+// entry the error matches wins.
+var domainErrors = []domainError{
+	{err: repository.ErrUserTokenInvalid, status: http.StatusBadRequest, code: "invalid_token"},
+	{err: repository.ErrUserTokenExpired, status: http.StatusBadRequest, code: "token_expired"},
+
+	{err: repository.ErrInvalidCredentials, status: http.StatusUnauthorized, code: "invalid_credentials"},
+	{err: repository.ErrRefreshTokenInvalid, status: http.StatusUnauthorized, code: "invalid_refresh_token"},
+	{err: repository.ErrRefreshTokenExpired, status: http.StatusUnauthorized, code: "refresh_token_expired"},
+	{err: repository.ErrRefreshTokenReused, status: http.StatusUnauthorized, code: "refresh_token_reused"},
+	{err: repository.ErrSessionRevoked, status: http.StatusUnauthorized, code: "session_revoked"},
+	{err: service.ErrInvalidTwoFactorCode, status: http.StatusUnauthorized, code: "invalid_two_factor_code"},
+	{err: service.ErrInvalidChallenge, status: http.StatusUnauthorized, code: "invalid_challenge"},
+
+	{err: service.ErrEmailNotVerified, status: http.StatusForbidden, code: "email_not_verified"},
+
+	{err: repository.ErrUserNotFound, status: http.StatusNotFound, code: "user_not_found"},
+	{err: repository.ErrWalletNotFound, status: http.StatusNotFound, code: "wallet_not_found"},
+	{err: repository.ErrSessionNotFound, status: http.StatusNotFound, code: "session_not_found"},
+	{err: repository.ErrAPIKeyNotFound, status: http.StatusNotFound, code: "api_key_not_found"},
+
+	{err: repository.ErrUsernameTaken, status: http.StatusConflict, code: "username_taken"},
+	{err: repository.ErrEmailTaken, status: http.StatusConflict, code: "email_taken"},
+	{err: service.ErrEmailAlreadyVerified, status: http.StatusConflict, code: "email_already_verified"},
+	{err: repository.ErrTwoFactorAlreadyEnabled, status: http.StatusConflict, code: "two_factor_already_enabled"},
+	{err: repository.ErrTwoFactorNotEnrolled, status: http.StatusConflict, code: "two_factor_not_enrolled"},
+	{err: service.ErrSlippageExceeded, status: http.StatusConflict, code: "slippage_exceeded"},
+
+	{err: repository.ErrInsufficientFunds, status: http.StatusUnprocessableEntity, code: "insufficient_funds"},
+	{err: repository.ErrCurrencyNotFound, status: http.StatusUnprocessableEntity, code: "unknown_currency"},
+	{err: repository.ErrRateNotFound, status: http.StatusUnprocessableEntity, code: "rate_not_found",
+		message: repository.ErrRateNotFound.Error()},
+	{err: service.ErrInvalidAmount, status: http.StatusUnprocessableEntity, code: "invalid_amount"},
+	{err: service.ErrAmountTooSmall, status: http.StatusUnprocessableEntity, code: "amount_too_small"},
+	{err: service.ErrAmountTooLarge, status: http.StatusUnprocessableEntity, code: "amount_too_large"},
+	{err: service.ErrSameCurrency, status: http.StatusUnprocessableEntity, code: "same_currency"},
+	{err: service.ErrSelfTransfer, status: http.StatusUnprocessableEntity, code: "self_transfer"},
+	{err: service.ErrInvalidTolerance, status: http.StatusUnprocessableEntity, code: "invalid_tolerance"},
+	{err: limits.ErrLimitExceeded, status: http.StatusUnprocessableEntity, code: "limit_exceeded"},
+	{err: service.ErrInvalidLimit, status: http.StatusUnprocessableEntity, code: "invalid_limit"},
+	{err: service.ErrInvalidAdjustment, status: http.StatusUnprocessableEntity, code: "invalid_adjustment"},
+	{err: service.ErrInvalidRoles, status: http.StatusUnprocessableEntity, code: "invalid_roles"},
+	{err: service.ErrPasswordRequired, status: http.StatusUnprocessableEntity, code: "password_required"},
+	{err: service.ErrAPIKeyScope, status: http.StatusUnprocessableEntity, code: "invalid_scope"},
+	{err: service.ErrAPIKeyRequest, status: http.StatusUnprocessableEntity, code: "invalid_api_key_request"},
+
+	{err: repository.ErrExchangerUnavailable, status: http.StatusServiceUnavailable, code: "exchanger_unavailable",
+		message: repository.ErrExchangerUnavailable.Error()},
+}
+
+// writeError answers the request with the status and code of the domain error err matches. Throttled logins
+// get 429 with a Retry-After header. Other errors are logged and reported as 500 without their text, which
+// may reveal internals.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	var throttled *service.LoginThrottledError
+	if errors.As(err, &throttled) {
+		w.Header().Set("Retry-After", retryAfter(throttled.RetryAfter))
+		apierror.Write(w, r, http.StatusTooManyRequests, "login_throttled", err.Error())
+		return
+	}
+
+	for _, d := range domainErrors {
+		if errors.Is(err, d.err) {
+			message := d.message
+			if message == "" {
+				message = err.Error()
+			}
+			apierror.Write(w, r, d.status, d.code, message)
+			return
+		}
+	}
+
+	slog.ErrorContext(r.Context(), "Request failed", slog.Any("error", err))
+	apierror.Write(w, r, http.StatusInternalServerError, apierror.CodeInternal, "Internal server error")
+}
+
+// writeInvalidBody answers a request whose body could not be decoded, with 413 if it was too large.
+func writeInvalidBody(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apierror.Write(w, r, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge, "Request body too large")
+		return
+	}
+	apierror.Write(w, r, http.StatusBadRequest, apierror.CodeInvalidRequest, "Invalid request payload")
+}
+
+// retryAfter formats d as the value of a Retry-After header, in whole seconds rounded up.
+func retryAfter(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"wallet/internal/apierror"
+	"wallet/internal/limits"
+	"wallet/internal/logging"
+	"wallet/internal/repository"
+	"wallet/internal/service"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteError(t *testing.T) {
+	for _, tc := range []struct {
+		err     error
+		status  int
+		code    string
+		message string
+	}{
+		{repository.ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds", "insufficient funds"},
+		{service.ErrInsufficientFunds, http.StatusUnprocessableEntity, "insufficient_funds", "insufficient funds"},
+		{repository.ErrCurrencyNotFound, http.StatusUnprocessableEntity, "unknown_currency", "currency not found"},
+		{service.ErrAmountTooLarge, http.StatusUnprocessableEntity, "amount_too_large", "amount is too large to exchange"},
+		{repository.ErrUsernameTaken, http.StatusConflict, "username_taken", "username already exists"},
+		{service.ErrWalletNotFound, http.StatusNotFound, "wallet_not_found", "wallet not found"},
+		{repository.ErrInvalidCredentials, http.StatusUnauthorized, "invalid_credentials", "invalid username or password"},
+		{service.ErrEmailNotVerified, http.StatusForbidden, "email_not_verified", "email address is not verified"},
+		{fmt.Errorf("%w: unknown role %q", service.ErrInvalidRoles, "root"), http.StatusUnprocessableEntity, "invalid_roles", `invalid roles: unknown role "root"`},
+		{fmt.Errorf("%w: exchange rate not found: USD to XYZ", repository.ErrRateNotFound), http.StatusUnprocessableEntity, "rate_not_found", "no exchange rate for the currency pair"},
+		{fmt.Errorf("%w: connection refused", repository.ErrExchangerUnavailable), http.StatusServiceUnavailable, "exchanger_unavailable", "exchange rates are temporarily unavailable"},
+		{&limits.ExceededError{Operation: limits.OperationWithdraw, Currency: "USD", Period: limits.PeriodDaily, Limit: 10000000, Remaining: 0,
+			ResetsAt: time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC)}, http.StatusUnprocessableEntity, "limit_exceeded",
+			"outflow limit exceeded: daily withdraw limit is 1000 USD, 0 USD remaining until 2024-05-11T00:00:00Z"},
+		{errors.New("pq: connection reset by peer"), http.StatusInternalServerError, apierror.CodeInternal, "Internal server error"},
+	} {
+		t.Run(tc.code, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/wallet/withdraw", nil)
+			req = req.WithContext(logging.WithRequestID(req.Context(), "req-1"))
+			rr := httptest.NewRecorder()
+
+			writeError(rr, req, tc.err)
+
+			assert.Equal(t, tc.status, rr.Code)
+			var res apierror.Response
+			require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+			assert.Equal(t, apierror.Response{Code: tc.code, Message: tc.message, RequestID: "req-1"}, res)
+		})
+	}
+}
+
+func TestWriteError_LoginThrottled(t *testing.T) {
+	rr := httptest.NewRecorder()
+
+	writeError(rr, httptest.NewRequest(http.MethodPost, "/api/v1/login", nil), &service.LoginThrottledError{RetryAfter: 1500 * time.Millisecond})
+
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get("Retry-After"))
+	assert.Contains(t, rr.Body.String(), `"code":"login_throttled"`)
+}
+
+func TestWriteInvalidBody(t *testing.T) {
+	rr := httptest.NewRecorder()
+	writeInvalidBody(rr, httptest.NewRequest(http.MethodPost, "/", nil), errors.New("unexpected EOF"))
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"code":"invalid_request"`)
+
+	rr = httptest.NewRecorder()
+	writeInvalidBody(rr, httptest.NewRequest(http.MethodPost, "/", nil), &http.MaxBytesError{Limit: 16})
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rr.Code)
+}
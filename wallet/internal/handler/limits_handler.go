@@ -0,0 +1,131 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/limits"
+	"wallet/internal/service"
+
+	"github.com/gorilla/mux"
+)
+
+// PeriodAllowanceResponse is a struct to represent what is left of the cap of a day or month. Limit and
+// Remaining are null when the period is not capped.
+type PeriodAllowanceResponse struct {
+	Limit     *float64  `json:"limit"`
+	Used      float64   `json:"used"`
+	Remaining *float64  `json:"remaining"`
+	ResetsAt  time.Time `json:"resets_at"`
+}
+
+// AllowanceResponse is a struct to represent what is left of the caps of an operation in a currency.
+type AllowanceResponse struct {
+	Operation string                  `json:"operation"`
+	Currency  string                  `json:"currency"`
+	Daily     PeriodAllowanceResponse `json:"daily"`
+	Monthly   PeriodAllowanceResponse `json:"monthly"`
+}
+
+// SetUserLimitRequest is a struct to represent the request payload for overriding the caps of a user. A
+// null or missing period is not capped.
+type SetUserLimitRequest struct {
+	Daily   *float64 `json:"daily"`
+	Monthly *float64 `json:"monthly"`
+}
+
+// UserLimitResponse is a struct to represent the response payload for changing the caps of a user.
+type UserLimitResponse struct {
+	Message string `json:"message"`
+}
+
+// GetLimits is an HTTP handler to get what the authenticated user may still withdraw, exchange and transfer
+// today and this month.
+func (h *WalletHandler) GetLimits(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	allowances, err := h.service.Allowances(r.Context(), principal.UserID, principal.Username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	res := make([]AllowanceResponse, 0, len(allowances))
+	for _, a := range allowances {
+		res = append(res, AllowanceResponse{
+			Operation: a.Operation,
+			Currency:  a.Currency,
+			Daily:     periodAllowanceToResponse(a.Daily),
+			Monthly:   periodAllowanceToResponse(a.Monthly),
+		})
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// SetUserLimit is an admin HTTP handler to replace the default caps of an operation in a currency for a user.
+func (h *WalletHandler) SetUserLimit(w http.ResponseWriter, r *http.Request) {
+	var req SetUserLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	c, err := capFromRequest(req)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := h.service.SetUserLimit(r.Context(), mux.Vars(r)["username"], limitKey(r), c); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(UserLimitResponse{Message: "Limit updated successfully"})
+}
+
+// ResetUserLimit is an admin HTTP handler to restore the default caps of an operation in a currency for a user.
+func (h *WalletHandler) ResetUserLimit(w http.ResponseWriter, r *http.Request) {
+	if err := h.service.ResetUserLimit(r.Context(), mux.Vars(r)["username"], limitKey(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(UserLimitResponse{Message: "Limit reset to the default"})
+}
+
+// limitKey is a helper function to read the operation and currency of a limit from the request path.
+func limitKey(r *http.Request) limits.Key {
+	vars := mux.Vars(r)
+	return limits.Key{Operation: vars["operation"], Currency: vars["currency"]}
+}
+
+// capFromRequest is a helper function to convert the caps in currency units to stored units.
+func capFromRequest(req SetUserLimitRequest) (limits.Cap, error) {
+	c := limits.NoCap
+	for _, p := range []struct {
+		period string
+		value  *float64
+		cap    *int64
+	}{{limits.PeriodDaily, req.Daily, &c.Daily}, {limits.PeriodMonthly, req.Monthly, &c.Monthly}} {
+		if p.value == nil {
+			continue
+		}
+		v, ok := limits.FromUnits(*p.value)
+		if !ok {
+			return c, fmt.Errorf("%w: %s cap %v is out of range", service.ErrInvalidLimit, p.period, *p.value)
+		}
+		*p.cap = v
+	}
+	return c, nil
+}
+
+// periodAllowanceToResponse is a helper function to convert the allowance of a period to the response payload.
+func periodAllowanceToResponse(a limits.PeriodAllowance) PeriodAllowanceResponse {
+	res := PeriodAllowanceResponse{Used: float64(a.Used) / float64(floatConversion), ResetsAt: a.ResetsAt}
+	if a.Limit != limits.Unlimited {
+		limit := float64(a.Limit) / float64(floatConversion)
+		remaining := float64(a.Remaining) / float64(floatConversion)
+		res.Limit, res.Remaining = &limit, &remaining
+	}
+	return res
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/service"
+)
+
+// LoginEventResponse is a struct to represent a login attempt in the login history.
+type LoginEventResponse struct {
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginHistory is an HTTP handler to list the most recent login attempts on the account of the user.
+func (h *WalletHandler) LoginHistory(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	events, err := h.service.LoginHistory(r.Context(), principal.UserID, limit)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	res := make([]LoginEventResponse, 0, len(events))
+	for _, event := range events {
+		res = append(res, LoginEventResponse{
+			IP:        event.IP,
+			UserAgent: event.UserAgent,
+			Success:   event.Success,
+			Reason:    event.Reason,
+			CreatedAt: event.CreatedAt,
+		})
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// clientInfo returns the address and user agent of the client that sent the request. The address is the one
+// resolved by ClientIP, or the address of the connection when the middleware did not run.
+func clientInfo(r *http.Request) service.ClientInfo {
+	ip, ok := r.Context().Value(clientIPKey{}).(string)
+	if !ok {
+		ip = remoteIP(r)
+	}
+	return service.ClientInfo{IP: ip, UserAgent: r.UserAgent()}
+}
@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"net/http"
+	"time"
+	"wallet/internal/metrics"
+)
+
+// ObserveRequests is a middleware that records the count and latency of requests per route template, so
+// paths with IDs do not create a series per ID.
+func ObserveRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		metrics.ObserveHTTPRequest(r.Method, routeTemplate(r), rec.status, time.Since(start))
+	})
+}
@@ -0,0 +1,34 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveRequests_LabelsRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.Use(ObserveRequests)
+	router.HandleFunc("/api/v1/sessions/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}).Methods("DELETE")
+
+	for _, id := range []string{"a", "b"} {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest("DELETE", "/api/v1/sessions/"+id, nil))
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+	}
+
+	expected := `
+# HELP wallet_http_requests_total HTTP requests handled, by method, route and status code.
+# TYPE wallet_http_requests_total counter
+wallet_http_requests_total{method="DELETE",route="/api/v1/sessions/{id}",status="204"} 2
+`
+	assert.NoError(t, testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "wallet_http_requests_total"))
+}
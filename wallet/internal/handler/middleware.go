@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"wallet/internal/apierror"
+	"wallet/internal/auth"
+	"wallet/internal/logging"
+)
+
+// Authenticate is a middleware that rejects requests without a valid bearer token or API key with 401 and
+// stores the authenticated principal in the request context for the handlers behind it. API keys are accepted
+// in the X-API-Key header or as the bearer token.
+func (h *WalletHandler) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.Header.Get("X-API-Key")
+		if token == "" {
+			header := r.Header.Get("Authorization")
+			if header == "" {
+				apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Authorization header is required")
+				return
+			}
+
+			scheme, bearer, ok := strings.Cut(header, " ")
+			if !ok || scheme != "Bearer" || bearer == "" {
+				apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Authorization header format must be Bearer {token}")
+				return
+			}
+			token = bearer
+		}
+
+		if auth.IsAPIKey(token) {
+			principal, err := h.service.VerifyAPIKey(r.Context(), token)
+			if err != nil {
+				apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Invalid API key")
+				return
+			}
+			ctx := logging.With(r.Context(), slog.Int("user_id", int(principal.UserID)), slog.String("api_key_id", principal.APIKeyID))
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(ctx, principal)))
+			return
+		}
+
+		claims, err := h.service.VerifyToken(r.Context(), token)
+		if err != nil || claims.UserID == 0 || claims.Username == "" {
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Invalid token")
+			return
+		}
+
+		principal := &auth.Principal{
+			UserID:    claims.UserID,
+			Username:  claims.Username,
+			SessionID: claims.SessionID,
+			Roles:     claims.Roles,
+			Scopes:    auth.PermissionsFor(claims.Roles),
+		}
+		ctx := logging.With(r.Context(), slog.Int("user_id", int(principal.UserID)), slog.String("session_id", principal.SessionID))
+		next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(ctx, principal)))
+	})
+}
+
+// RequireSession is a middleware that rejects requests not authenticated with a user session with 403.
+// It guards account management, which API keys must not be able to change. It must be used behind Authenticate.
+func (h *WalletHandler) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal := auth.PrincipalFromContext(r.Context())
+		if principal == nil {
+			apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Authorization header is required")
+			return
+		}
+		if principal.SessionID == "" {
+			apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "This endpoint requires a user login, API keys are not accepted")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission returns a middleware that rejects requests whose principal lacks the permission with 403.
+// It must be used behind Authenticate.
+func (h *WalletHandler) RequirePermission(permission string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal := auth.PrincipalFromContext(r.Context())
+			if principal == nil {
+				apierror.Write(w, r, http.StatusUnauthorized, apierror.CodeUnauthorized, "Authorization header is required")
+				return
+			}
+			if !principal.Can(permission) {
+				apierror.Write(w, r, http.StatusForbidden, apierror.CodeForbidden, "Permission "+permission+" is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"wallet/internal/auth"
+	"wallet/internal/service"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeService overrides the service methods used by the tests; calling any other method panics.
+type fakeService struct {
+	service.WalletServiceInterface
+}
+
+func (f *fakeService) VerifyToken(ctx context.Context, token string) (*auth.Claims, error) {
+	if token != "good" {
+		return nil, errors.New("invalid token")
+	}
+	return &auth.Claims{UserID: 7, Username: "alice", SessionID: "s1"}, nil
+}
+
+func (f *fakeService) VerifyAPIKey(ctx context.Context, key string) (*auth.Principal, error) {
+	if key != "wk_good_key" {
+		return nil, errors.New("invalid api key")
+	}
+	return &auth.Principal{UserID: 7, Username: "alice", APIKeyID: "k1", Scopes: []string{auth.PermWalletRead}}, nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	h := NewWalletHandler(&fakeService{})
+	var got *auth.Principal
+	protected := h.Authenticate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = auth.PrincipalFromContext(r.Context())
+	}))
+
+	for name, header := range map[string]string{
+		"missing header": "",
+		"wrong scheme":   "Basic good",
+		"invalid token":  "Bearer bad",
+		"invalid key":    "Bearer wk_bad_key",
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/balance", nil)
+			req.Header.Set("Authorization", header)
+			rr := httptest.NewRecorder()
+
+			protected.ServeHTTP(rr, req)
+
+			assert.Equal(t, http.StatusUnauthorized, rr.Code)
+		})
+	}
+
+	t.Run("valid token", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/balance", nil)
+		req.Header.Set("Authorization", "Bearer good")
+		rr := httptest.NewRecorder()
+
+		protected.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, &auth.Principal{UserID: 7, Username: "alice", SessionID: "s1"}, got)
+	})
+
+	t.Run("valid api key", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/balance", nil)
+		req.Header.Set("X-API-Key", "wk_good_key")
+		rr := httptest.NewRecorder()
+
+		protected.ServeHTTP(rr, req)
+
+		assert.Equal(t, http.StatusOK, rr.Code)
+		assert.Equal(t, "k1", got.APIKeyID)
+	})
+}
+
+func TestRequireSession(t *testing.T) {
+	h := NewWalletHandler(&fakeService{})
+	account := h.RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for name, tc := range map[string]struct {
+		principal *auth.Principal
+		code      int
+	}{
+		"session": {&auth.Principal{UserID: 1, SessionID: "s1"}, http.StatusOK},
+		"api key": {&auth.Principal{UserID: 1, APIKeyID: "k1"}, http.StatusForbidden},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/api-keys", nil)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), tc.principal))
+			rr := httptest.NewRecorder()
+
+			account.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.code, rr.Code)
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	h := NewWalletHandler(&fakeService{})
+	admin := h.RequirePermission(auth.PermUsersAdmin)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for role, code := range map[string]int{auth.RoleUser: http.StatusForbidden, auth.RoleAdmin: http.StatusOK} {
+		t.Run(role, func(t *testing.T) {
+			principal := &auth.Principal{UserID: 1, Username: "alice", Scopes: auth.PermissionsFor([]string{role})}
+			req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/users/bob/roles", nil)
+			req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+			rr := httptest.NewRecorder()
+
+			admin.ServeHTTP(rr, req)
+
+			assert.Equal(t, code, rr.Code)
+		})
+	}
+}
@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"wallet/internal/apierror"
+	"wallet/internal/auth"
+	"wallet/internal/metrics"
+	"wallet/internal/ratelimit"
+)
+
+// RateLimit returns a middleware that allows each client limit requests to the routes of group and
+// refuses further ones with 429 and a Retry-After header. Authenticated clients are counted per user,
+// which requires the middleware to run behind Authenticate, others per IP address. When the store fails
+// requests are let through, the limiter must not take the API down.
+func RateLimit(store ratelimit.Store, group string, limit ratelimit.Limit) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !limit.Enabled() {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := group + ":ip:" + clientInfo(r).IP
+			if principal := auth.PrincipalFromContext(r.Context()); principal != nil {
+				key = group + ":user:" + strconv.Itoa(int(principal.UserID))
+			}
+
+			result, err := store.Allow(r.Context(), key, limit)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Rate limiter failed, letting the request through", slog.String("group", group), slog.Any("error", err))
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Requests))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			if !result.Allowed {
+				metrics.RecordRateLimited(group)
+				w.Header().Set("Retry-After", retryAfter(result.RetryAfter))
+				apierror.Write(w, r, http.StatusTooManyRequests, apierror.CodeRateLimited, "Too many requests, retry later")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
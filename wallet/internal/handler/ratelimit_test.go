@@ -0,0 +1,81 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/ratelimit"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStore struct{}
+
+func (failingStore) Allow(ctx context.Context, key string, limit ratelimit.Limit) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("store unavailable")
+}
+
+func TestRateLimit(t *testing.T) {
+	limited := RateLimit(ratelimit.NewMemoryStore(), "auth", ratelimit.Limit{Requests: 2, Window: time.Minute})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	call := func(remoteAddr string, principal *auth.Principal) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/login", nil)
+		req.RemoteAddr = remoteAddr
+		if principal != nil {
+			req = req.WithContext(auth.WithPrincipal(req.Context(), principal))
+		}
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		return rr
+	}
+
+	assert.Equal(t, http.StatusOK, call("10.0.0.1:1000", nil).Code)
+	rr := call("10.0.0.1:2000", nil)
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "0", rr.Header().Get("X-RateLimit-Remaining"))
+
+	rr = call("10.0.0.1:3000", nil)
+	assert.Equal(t, http.StatusTooManyRequests, rr.Code)
+	assert.Equal(t, "30", rr.Header().Get("Retry-After"))
+
+	// Another address, and an authenticated user behind the same address, have their own allowance
+	assert.Equal(t, http.StatusOK, call("10.0.0.2:1000", nil).Code)
+	assert.Equal(t, http.StatusOK, call("10.0.0.1:4000", &auth.Principal{UserID: 7}).Code)
+}
+
+func TestRateLimit_StoreFailureLetsRequestsThrough(t *testing.T) {
+	called := false
+	limited := RateLimit(failingStore{}, "write", ratelimit.Limit{Requests: 1, Window: time.Minute})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	rr := httptest.NewRecorder()
+	limited.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/v1/exchange", nil))
+
+	assert.True(t, called)
+	assert.Equal(t, http.StatusOK, rr.Code)
+}
+
+func TestRateLimit_BeforeAuthentication(t *testing.T) {
+	// In front of Authenticate no principal is known yet, so rejected credentials are counted per IP
+	rejectAll := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusUnauthorized) })
+	}
+	limited := RateLimit(ratelimit.NewMemoryStore(), "ip", ratelimit.Limit{Requests: 2, Window: time.Minute})(
+		rejectAll(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	call := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/balance", nil)
+		req.RemoteAddr = "10.0.0.1:1000"
+		req.Header.Set("Authorization", "Bearer guessed")
+		rr := httptest.NewRecorder()
+		limited.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, call())
+	assert.Equal(t, http.StatusUnauthorized, call())
+	assert.Equal(t, http.StatusTooManyRequests, call())
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"wallet/internal/auth"
+
+	"github.com/gorilla/mux"
+)
+
+// SessionResponse is a struct to represent an active session of the user.
+type SessionResponse struct {
+	ID         string    `json:"id"`
+	DeviceName string    `json:"device_name"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	Current    bool      `json:"current"`
+}
+
+// RevokeSessionsResponse is a struct to represent the response payload for revoking sessions.
+type RevokeSessionsResponse struct {
+	Message string `json:"message"`
+	Revoked int64  `json:"revoked"`
+}
+
+// ListSessions is an HTTP handler to list the active sessions of the user, marking the one of the request.
+func (h *WalletHandler) ListSessions(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	sessions, err := h.service.ListSessions(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	res := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		res = append(res, SessionResponse{
+			ID:         session.ID,
+			DeviceName: session.DeviceName,
+			IP:         session.IP,
+			UserAgent:  session.UserAgent,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == principal.SessionID,
+		})
+	}
+	json.NewEncoder(w).Encode(res)
+}
+
+// RevokeSession is an HTTP handler to revoke one session of the user.
+func (h *WalletHandler) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	err := h.service.RevokeSession(r.Context(), principal.UserID, mux.Vars(r)["id"])
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(RevokeSessionsResponse{Message: "Session revoked", Revoked: 1})
+}
+
+// RevokeOtherSessions is an HTTP handler to revoke all sessions of the user except the one of the request.
+func (h *WalletHandler) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	revoked, err := h.service.RevokeOtherSessions(r.Context(), principal.UserID, principal.SessionID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(RevokeSessionsResponse{Message: "Other sessions revoked", Revoked: revoked})
+}
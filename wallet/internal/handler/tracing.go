@@ -0,0 +1,13 @@
+package handler
+
+import "net/http"
+
+// TraceRequest reports whether a span is started for the request. Prometheus scrapes and health probes
+// are not traced.
+func TraceRequest(r *http.Request) bool {
+	switch r.URL.Path {
+	case "/metrics", "/healthz", "/readyz":
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"wallet/internal/auth"
+)
+
+// TOTPEnrollResponse is a struct to represent the response payload for starting TOTP enrollment.
+type TOTPEnrollResponse struct {
+	Secret     string `json:"secret"`
+	OtpauthURI string `json:"otpauth_uri"`
+}
+
+// TOTPConfirmRequest is a struct to represent the request payload for confirming TOTP enrollment.
+type TOTPConfirmRequest struct {
+	Code string `json:"code"`
+}
+
+// TOTPConfirmResponse is a struct to represent the response payload for confirming TOTP enrollment.
+type TOTPConfirmResponse struct {
+	Message       string   `json:"message"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// TOTPDisableRequest is a struct to represent the request payload for disabling two-factor authentication.
+type TOTPDisableRequest struct {
+	Pw   string `json:"pw"`
+	Code string `json:"code"`
+}
+
+// TOTPDisableResponse is a struct to represent the response payload for disabling two-factor authentication.
+type TOTPDisableResponse struct {
+	Message string `json:"message"`
+}
+
+// LoginTwoFactorRequest is a struct to represent the request payload for the second login step.
+type LoginTwoFactorRequest struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+	DeviceName     string `json:"device_name,omitempty"`
+}
+
+// EnrollTOTP is an HTTP handler to generate a new TOTP secret for the user.
+func (h *WalletHandler) EnrollTOTP(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	enrollment, err := h.service.EnrollTOTP(r.Context(), principal.UserID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(TOTPEnrollResponse{Secret: enrollment.Secret, OtpauthURI: enrollment.URI})
+}
+
+// ConfirmTOTP is an HTTP handler to enable two-factor authentication with a code from the authenticator app.
+func (h *WalletHandler) ConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	var req TOTPConfirmRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	codes, err := h.service.ConfirmTOTP(r.Context(), principal.UserID, req.Code)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(TOTPConfirmResponse{Message: "Two-factor authentication enabled", RecoveryCodes: codes})
+}
+
+// DisableTOTP is an HTTP handler to disable two-factor authentication after re-authentication.
+func (h *WalletHandler) DisableTOTP(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	var req TOTPDisableRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := h.service.DisableTOTP(r.Context(), principal.UserID, req.Pw, req.Code, clientInfo(r)); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(TOTPDisableResponse{Message: "Two-factor authentication disabled"})
+}
+
+// LoginTwoFactor is an HTTP handler to complete a login with the challenge token and a second factor.
+func (h *WalletHandler) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req LoginTwoFactorRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	client := clientInfo(r)
+	client.DeviceName = req.DeviceName
+	token, err := h.service.CompleteTwoFactorLogin(r.Context(), req.ChallengeToken, req.Code, client)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(token)
+}
@@ -2,13 +2,12 @@ package handler
 
 import (
 	"encoding/json"
-	"errors"
-	"log"
 	"net/http"
-	"strings"
+	"time"
+	"wallet/internal/auth"
 	"wallet/internal/service"
 
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/gorilla/mux"
 )
 
 // As the application is handling money values in the wallet, it is important to keep the precision of the values.
@@ -30,6 +29,13 @@ type WalletChangeRequest struct {
 	Amount   float32 `json:"amount"`
 }
 
+// TransferRequest is a struct to represent the request payload for a transfer to another user.
+type TransferRequest struct {
+	To       string  `json:"to"`
+	Currency string  `json:"currency"`
+	Amount   float32 `json:"amount"`
+}
+
 // WalletChangeResponse is a struct to represent the response payload for deposit and withdraw operations.
 type WalletChangeResponse struct {
 	Messsage    string             `json:"message" `
@@ -43,10 +49,58 @@ type ExchangeRateRequest struct {
 }
 
 // ExchangeRequest is a struct to represent the request payload for exchanging money between two currencies.
+// ExpectedRate, MinToAmount and Tolerance are optional and protect the user against the rate moving
+// between the moment they saw it and the moment the exchange is executed.
 type ExchangeRequest struct {
 	FromCurrency string  `json:"from_currency"`
 	ToCurrency   string  `json:"to_currency"`
 	Amount       float32 `json:"amount"`
+	ExpectedRate float32 `json:"expected_rate,omitempty"`
+	MinToAmount  float32 `json:"min_to_amount,omitempty"`
+	Tolerance    float64 `json:"tolerance,omitempty"`
+}
+
+// ExchangePreviewRequest is a struct to represent the request payload for previewing an exchange.
+type ExchangePreviewRequest struct {
+	FromCurrency string  `json:"from_currency"`
+	ToCurrency   string  `json:"to_currency"`
+	Amount       float32 `json:"amount"`
+}
+
+// FeeResponse is a struct to represent a single fee of an exchange in the target currency.
+type FeeResponse struct {
+	Name   string  `json:"name"`
+	Amount float32 `json:"amount"`
+}
+
+// ExchangePreviewResponse is a struct to represent the response payload for previewing an exchange.
+type ExchangePreviewResponse struct {
+	FromCurrency    string        `json:"from_currency"`
+	ToCurrency      string        `json:"to_currency"`
+	SourceAmount    float32       `json:"source_amount"`
+	Rate            float32       `json:"rate"`
+	RateTimestamp   *time.Time    `json:"rate_timestamp,omitempty"`
+	GrossAmount     float32       `json:"gross_amount"`
+	Fees            []FeeResponse `json:"fees"`
+	TotalFees       float32       `json:"total_fees"`
+	NetAmount       float32       `json:"net_amount"`
+	SufficientFunds bool          `json:"sufficient_funds"`
+}
+
+// ValuationItemResponse is a struct to represent a single balance converted into the valuation currency.
+type ValuationItemResponse struct {
+	Currency      string     `json:"currency"`
+	Balance       float32    `json:"balance"`
+	Rate          float32    `json:"rate"`
+	RateTimestamp *time.Time `json:"rate_timestamp,omitempty"`
+	Value         float64    `json:"value"`
+}
+
+// ValuationResponse is a struct to represent the response payload for the balance valuated in one currency.
+type ValuationResponse struct {
+	Currency string                  `json:"currency"`
+	Balances []ValuationItemResponse `json:"balances"`
+	Total    float64                 `json:"total"`
 }
 
 // RegisterUserRequest is a struct to represent the request payload for registering a new user.
@@ -61,44 +115,63 @@ type RegisterUserResponse struct {
 	Message string `json:"message"`
 }
 
+// RefreshTokenRequest is a struct to represent the request payload for refreshing an access token.
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// LogoutResponse is a struct to represent the response payload for logging out.
+type LogoutResponse struct {
+	Message string `json:"message"`
+}
+
+// SetUserRolesRequest is a struct to represent the request payload for replacing the roles of a user.
+type SetUserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// AdjustBalanceRequest is a struct to represent the request payload for correcting the balance of a user.
+// A negative amount takes money out of the wallet.
+type AdjustBalanceRequest struct {
+	Currency string  `json:"currency"`
+	Amount   float32 `json:"amount"`
+	Reason   string  `json:"reason"`
+}
+
+// SetUserRolesResponse is a struct to represent the response payload for replacing the roles of a user.
+type SetUserRolesResponse struct {
+	Message string `json:"message"`
+}
+
 // LoginRequest is a struct to represent the request payload for logging in a user.
 type LoginRequest struct {
-	Username string `json:"username"`
-	Pw       string `json:"pw"`
+	Username   string `json:"username"`
+	Pw         string `json:"pw"`
+	DeviceName string `json:"device_name,omitempty"`
 }
 
 // WalletDeposit is an HTTP handler to deposit money into the wallet.
 func (h *WalletHandler) WalletDeposit(w http.ResponseWriter, r *http.Request) {
-	// Extract the Authorization header from the request.
-	auth := r.Header.Get(
-		"Authorization",
-	)
-
-	// Verify the token and extract the user ID and username from the claims.
-	uid, username, err := verifyTokenWithClaims(auth)
-	if uid == 0 {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
-	}
+	// Get the authenticated user from the request context.
+	principal := auth.PrincipalFromContext(r.Context())
 
 	var req WalletChangeRequest
 	var res WalletChangeResponse
 	// Decode the request payload into the req variable.
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 
 	// Deposit the amount into the wallet.
-	err = h.service.Deposit(r.Context(), uid, floatToIntConversion(req.Amount), req.Currency)
-
+	err := h.service.Deposit(r.Context(), principal.UserID, floatToIntConversion(req.Amount), req.Currency)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	// Get the updated balance after the deposit operation.
-	balances, err := h.service.GetBalance(r.Context(), username)
+	balances, err := h.service.GetBalance(r.Context(), principal.Username)
 	balancesFloat32 := make(map[string]float32)
 	balancesFloat32 = intMapToFloatMapConversion(balances)
 
@@ -109,30 +182,25 @@ func (h *WalletHandler) WalletDeposit(w http.ResponseWriter, r *http.Request) {
 
 // WalletWithdraw is an HTTP handler to withdraw money from the wallet.
 func (h *WalletHandler) WalletWithdraw(w http.ResponseWriter, r *http.Request) {
-	// Extract the Authorization header from the request.
-	auth := r.Header.Get(
-		"Authorization",
-	)
+	// Get the authenticated user from the request context.
+	principal := auth.PrincipalFromContext(r.Context())
 
-	// Verify the token and extract the user ID and username from the claims.
-	uid, username, err := verifyTokenWithClaims(auth)
 	var req WalletChangeRequest
 	var res WalletChangeResponse
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 
 	// Withdraw the amount from the wallet.
-	err = h.service.Withdraw(r.Context(), uid, floatToIntConversion(req.Amount), req.Currency)
-
+	err := h.service.Withdraw(r.Context(), principal.UserID, floatToIntConversion(req.Amount), req.Currency)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	// Get the updated balance after the withdraw operation.
-	balances, err := h.service.GetBalance(r.Context(), username)
+	balances, err := h.service.GetBalance(r.Context(), principal.Username)
 	res.Messsage = "Withdraw successful"
 
 	balancesFloat32 := make(map[string]float32)
@@ -142,25 +210,51 @@ func (h *WalletHandler) WalletWithdraw(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(res)
 }
 
+// WalletTransfer is an HTTP handler to transfer money to the wallet of another user.
+func (h *WalletHandler) WalletTransfer(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
+
+	var req TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := h.service.Transfer(r.Context(), principal.UserID, req.To, floatToIntConversion(req.Amount), req.Currency); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	balances, err := h.service.GetBalance(r.Context(), principal.Username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(WalletChangeResponse{Messsage: "Transfer successful", New_balance: intMapToFloatMapConversion(balances)})
+}
+
 // GetBalance is an HTTP handler to get the balance of the wallet.
 func (h *WalletHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
-	// Extract the Authorization header from the request.
-	auth := r.Header.Get(
-		"Authorization",
-	)
-	// Verify the token and extract the user ID and username from the claims.
-	_, username, err := verifyTokenWithClaims(auth)
-	if err != nil {
-		http.Error(w, "Invalid wallet ID", http.StatusBadRequest)
+	// Get the authenticated user from the request context.
+	principal := auth.PrincipalFromContext(r.Context())
+
+	// Value the whole portfolio in one currency when the "in" query parameter is given.
+	if currency := r.URL.Query().Get("in"); currency != "" {
+		valuation, err := h.service.ValueBalances(r.Context(), principal.Username, currency)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+		json.NewEncoder(w).Encode(valuationToResponse(valuation))
 		return
 	}
 
 	// Get the balance of the wallet.
-	balances, err := h.service.GetBalance(r.Context(), username)
+	balances, err := h.service.GetBalance(r.Context(), principal.Username)
 	balancesFloat32 := make(map[string]float32)
 	balancesFloat32 = intMapToFloatMapConversion(balances)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -169,18 +263,9 @@ func (h *WalletHandler) GetBalance(w http.ResponseWriter, r *http.Request) {
 
 // GetExchangeRates is an HTTP handler to get the exchange rates between different currencies.
 func (h *WalletHandler) GetExchangeRates(w http.ResponseWriter, r *http.Request) {
-	auth := r.Header.Get(
-		"Authorization",
-	)
-	err := verifyToken(auth)
-	log.Println(err)
-	if err != nil {
-		http.Error(w, "Authorization invalid", http.StatusUnauthorized)
-		return
-	}
 	rates, err := h.service.GetExchangeRates(r.Context())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -190,23 +275,15 @@ func (h *WalletHandler) GetExchangeRates(w http.ResponseWriter, r *http.Request)
 // GetExchangeRate is an HTTP handler to get the exchange rate between two currencies.
 func (h *WalletHandler) GetExchangeRate(w http.ResponseWriter, r *http.Request) {
 	var req ExchangeRateRequest
-	auth := r.Header.Get(
-		"Authorization",
-	)
-	err := verifyToken(auth)
-	if err != nil {
-		http.Error(w, "Authorization invalid", http.StatusUnauthorized)
-		return
-	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
 	rates, err := h.service.GetExchangeRate(r.Context(), req.FromCurrency, req.ToCurrency)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -216,41 +293,54 @@ func (h *WalletHandler) GetExchangeRate(w http.ResponseWriter, r *http.Request)
 // Exchange is an HTTP handler to exchange money between two currencies.
 func (h *WalletHandler) Exchange(w http.ResponseWriter, r *http.Request) {
 	var req ExchangeRequest
-	auth := r.Header.Get(
-		"Authorization",
-	)
-	uid, username, err := verifyTokenWithClaims(auth)
+	// Get the authenticated user from the request context.
+	principal := auth.PrincipalFromContext(r.Context())
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
+	}
+	defer r.Body.Close()
+
+	// Exchange the amount, refusing if the live rate moved beyond the accepted slippage.
+	slippage := service.Slippage{
+		ExpectedRate: req.ExpectedRate,
+		Tolerance:    req.Tolerance,
+		MinToAmount:  floatToIntConversion(req.MinToAmount),
+	}
+	_, err := h.service.Exchange(r.Context(), principal.UserID, req.FromCurrency, req.ToCurrency, floatToIntConversion(req.Amount), slippage)
 	if err != nil {
-		http.Error(w, "Authorization invalid", http.StatusUnauthorized)
+		writeError(w, r, err)
 		return
 	}
+
+	// Get the balance of the wallet after the exchange.
+	balance, err := h.service.GetBalance(r.Context(), principal.Username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(intMapToFloatMapConversion(balance))
+}
+
+// PreviewExchange is an HTTP handler to calculate the result of an exchange without executing it.
+func (h *WalletHandler) PreviewExchange(w http.ResponseWriter, r *http.Request) {
+	var req ExchangePreviewRequest
+	// Get the authenticated user from the request context.
+	principal := auth.PrincipalFromContext(r.Context())
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	// Get the exchange rate between the two currencies.
-	rate, err := h.service.GetExchangeRate(r.Context(), req.FromCurrency, req.ToCurrency)
-	// Get the balance of the wallet.
-	balance, err := h.service.GetBalance(r.Context(), username)
-
-	// Convert the balance from int to float.
-	balanceFloat32 := map[string]float32{}
-	balanceFloat32 = intMapToFloatMapConversion(balance)
-	// subtract the amount from the fromCurrency balance and add the amount to the toCurrency balance.
-	balanceFloat32[req.FromCurrency] -= req.Amount
-	balanceFloat32[req.ToCurrency] += req.Amount * rate
-	// Update the balance in the wallet.
-	err = h.service.Withdraw(r.Context(), uid, floatToIntConversion(req.Amount), req.FromCurrency)
-	err = h.service.Deposit(r.Context(), uid, floatToIntConversion(req.Amount*rate), req.ToCurrency)
-
+	quote, err := h.service.PreviewExchange(r.Context(), principal.Username, req.FromCurrency, req.ToCurrency, floatToIntConversion(req.Amount))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	json.NewEncoder(w).Encode(balanceFloat32)
+	json.NewEncoder(w).Encode(quoteToPreviewResponse(quote))
 }
 
 // RegisterUser is an HTTP handler to register a new user.
@@ -258,14 +348,14 @@ func (h *WalletHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 	var req RegisterUserRequest
 	var res RegisterUserResponse
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
 	err := h.service.RegisterUser(r.Context(), req.Username, req.Email, req.Pw)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	res.Message = "User registered successfully"
@@ -276,77 +366,142 @@ func (h *WalletHandler) RegisterUser(w http.ResponseWriter, r *http.Request) {
 func (h *WalletHandler) Login(w http.ResponseWriter, r *http.Request) {
 	var req LoginRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
+		writeInvalidBody(w, r, err)
 		return
 	}
 	defer r.Body.Close()
 
-	token, err := h.service.Login(r.Context(), req.Username, req.Pw)
+	client := clientInfo(r)
+	client.DeviceName = req.DeviceName
+	token, err := h.service.Login(r.Context(), req.Username, req.Pw, client)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 	json.NewEncoder(w).Encode(token)
 }
 
-// verifyToken is a helper function to verify the token in the Authorization header.
-func verifyToken(auth string) error {
-	if auth == "" {
-		return errors.New("Authorization header is required")
+// RefreshToken is an HTTP handler to exchange a refresh token for a new access and refresh token pair.
+func (h *WalletHandler) RefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req RefreshTokenRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.RefreshToken == "" {
+		writeInvalidBody(w, r, err)
+		return
 	}
+	defer r.Body.Close()
 
-	parts := strings.Split(auth, " ")
-
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return errors.New("Authorization header format must be Bearer {token}")
+	token, err := h.service.RefreshToken(r.Context(), req.RefreshToken, clientInfo(r))
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	json.NewEncoder(w).Encode(token)
+}
 
-	tokenString := parts[1]
+// Logout is an HTTP handler to revoke the session of the presented access token.
+func (h *WalletHandler) Logout(w http.ResponseWriter, r *http.Request) {
+	// Get the authenticated user from the request context.
+	principal := auth.PrincipalFromContext(r.Context())
 
-	token, _ := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte("your_secret_key"), nil
-	})
-	if token.Valid {
-		return nil
-	} else {
-		return errors.New("Invalid token")
+	if err := h.service.Logout(r.Context(), principal.UserID, principal.SessionID); err != nil {
+		writeError(w, r, err)
+		return
 	}
+	json.NewEncoder(w).Encode(LogoutResponse{Message: "Logged out successfully"})
 }
 
-// verifyTokenWithClaims is a helper function to verify the token in the Authorization header and extract the claims.
-func verifyTokenWithClaims(auth string) (int32, string, error) {
-	if auth == "" {
-		return 0, "", errors.New("Authorization header is required")
+// SetUserRoles is an admin HTTP handler to replace the roles of a user.
+func (h *WalletHandler) SetUserRoles(w http.ResponseWriter, r *http.Request) {
+	var req SetUserRolesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
 	}
+	defer r.Body.Close()
 
-	parts := strings.Split(auth, " ")
+	if err := h.service.SetUserRoles(r.Context(), mux.Vars(r)["username"], req.Roles); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(SetUserRolesResponse{Message: "Roles updated successfully"})
+}
+
+// AdjustBalance is an admin HTTP handler to correct the balance of a user, e.g. after a failed payout.
+func (h *WalletHandler) AdjustBalance(w http.ResponseWriter, r *http.Request) {
+	principal := auth.PrincipalFromContext(r.Context())
 
-	if len(parts) != 2 || parts[0] != "Bearer" {
-		return 0, "", errors.New("Authorization header format must be Bearer {token}")
+	var req AdjustBalanceRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeInvalidBody(w, r, err)
+		return
 	}
+	defer r.Body.Close()
 
-	tokenString := parts[1]
+	username := mux.Vars(r)["username"]
+	err := h.service.AdjustBalance(r.Context(), principal.UserID, username, floatToIntConversion(req.Amount), req.Currency, req.Reason)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	balances, err := h.service.GetBalance(r.Context(), username)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	json.NewEncoder(w).Encode(WalletChangeResponse{Messsage: "Balance adjusted", New_balance: intMapToFloatMapConversion(balances)})
+}
 
-	token, _ := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte("your_secret_key"), nil
-	})
-	if !token.Valid {
-		return 0, "", errors.New("Invalid token")
+// JWKS is an HTTP handler to publish the public keys that verify access tokens.
+func (h *WalletHandler) JWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.service.JWKS())
+}
+
+// quoteToPreviewResponse is a helper function to convert a service quote to the preview response payload.
+func quoteToPreviewResponse(q service.Quote) ExchangePreviewResponse {
+	fees := make([]FeeResponse, 0, len(q.Fees))
+	for _, fee := range q.Fees {
+		fees = append(fees, FeeResponse{Name: fee.Name, Amount: intToFloatConversion(fee.Amount)})
+	}
+	return ExchangePreviewResponse{
+		FromCurrency:    q.FromCurrency,
+		ToCurrency:      q.ToCurrency,
+		SourceAmount:    intToFloatConversion(q.FromAmount),
+		Rate:            q.Rate,
+		RateTimestamp:   rateTimestamp(q.RateTimestamp),
+		GrossAmount:     intToFloatConversion(q.GrossToAmount),
+		Fees:            fees,
+		TotalFees:       intToFloatConversion(q.TotalFees),
+		NetAmount:       intToFloatConversion(q.NetToAmount),
+		SufficientFunds: q.SufficientFunds,
 	}
+}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		uid, ok := claims["uid"].(float64)
-		if !ok {
-			return 0, "", errors.New("uid not found in token")
-		}
-		username, ok := claims["username"].(string)
-		if !ok {
-			return 0, "", errors.New("username not found in token")
-		}
-		return int32(uid), username, nil
+// valuationToResponse is a helper function to convert a service valuation to the response payload.
+func valuationToResponse(v service.Valuation) ValuationResponse {
+	items := make([]ValuationItemResponse, 0, len(v.Items))
+	for _, item := range v.Items {
+		items = append(items, ValuationItemResponse{
+			Currency:      item.Currency,
+			Balance:       intToFloatConversion(item.Balance),
+			Rate:          item.Rate,
+			RateTimestamp: rateTimestamp(item.RateTimestamp),
+			Value:         float64(item.Value) / float64(floatConversion),
+		})
+	}
+	return ValuationResponse{
+		Currency: v.Currency,
+		Balances: items,
+		Total:    float64(v.Total) / float64(floatConversion),
 	}
+}
 
-	return 0, "", errors.New("Invalid token")
+// rateTimestamp is a helper function to omit the update time of a rate from responses when it is not known.
+func rateTimestamp(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
 }
 
 // intToFloatConversion is a helper function to convert an integer value to a float value.
@@ -7,146 +7,152 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"wallet/internal/auth"
 	"wallet/internal/handler"
 	"wallet/internal/service"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 )
 
+// MockWalletService mocks the service methods used by the tests; calling any other method panics.
 type MockWalletService struct {
+	service.WalletServiceInterface
 	mock.Mock
 }
 
-func (m *MockWalletService) Deposit(ctx context.Context, walletID uuid.UUID, amount int64) error {
-	args := m.Called(ctx, walletID, amount)
+func (m *MockWalletService) Deposit(ctx context.Context, uid int32, amount int32, currency string) error {
+	args := m.Called(ctx, uid, amount, currency)
 	return args.Error(0)
 }
 
-func (m *MockWalletService) Withdraw(ctx context.Context, walletID uuid.UUID, amount int64) error {
-	args := m.Called(ctx, walletID, amount)
+func (m *MockWalletService) Withdraw(ctx context.Context, uid int32, amount int32, currency string) error {
+	args := m.Called(ctx, uid, amount, currency)
 	return args.Error(0)
 }
 
-func (m *MockWalletService) GetBalance(ctx context.Context, walletID uuid.UUID) (int64, error) {
-	args := m.Called(ctx, walletID)
-	return args.Get(0).(int64), args.Error(1)
+func (m *MockWalletService) Transfer(ctx context.Context, uid int32, to string, amount int32, currency string) error {
+	args := m.Called(ctx, uid, to, amount, currency)
+	return args.Error(0)
+}
+
+func (m *MockWalletService) GetBalance(ctx context.Context, username string) (map[string]int32, error) {
+	args := m.Called(ctx, username)
+	return args.Get(0).(map[string]int32), args.Error(1)
+}
+
+func (m *MockWalletService) AdjustBalance(ctx context.Context, adminID int32, username string, amount int32, currency string, reason string) error {
+	args := m.Called(ctx, adminID, username, amount, currency, reason)
+	return args.Error(0)
 }
 
-func TestHandleWalletOperation(t *testing.T) {
+// newRequest returns a request with the JSON body, authenticated as alice.
+func newRequest(t *testing.T, method string, path string, body any) *http.Request {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		require.NoError(t, err)
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	return req.WithContext(auth.WithPrincipal(req.Context(), &auth.Principal{UserID: 1, Username: "alice"}))
+}
+
+func TestWalletDeposit(t *testing.T) {
 	mockService := new(MockWalletService)
-	handler := handler.NewWalletHandler(mockService)
-
-	t.Run("successful deposit", func(t *testing.T) {
-		walletID := uuid.New()
-		reqBody := map[string]interface{}{
-			"walletId":      walletID.String(),
-			"operationType": "DEPOSIT",
-			"amount":        100,
-		}
-
-		mockService.On("Deposit", mock.Anything, walletID, int64(100)).Return(nil)
-
-		reqBodyJSON, _ := json.Marshal(reqBody)
-		req := httptest.NewRequest(http.MethodPost, "/wallet/operation", bytes.NewReader(reqBodyJSON))
-		req.Header.Set("Content-Type", "application/json")
-		rr := httptest.NewRecorder()
-
-		handler.HandleWalletOperation(rr, req)
-
-		require.Equal(t, http.StatusOK, rr.Code)
-		mockService.AssertExpectations(t)
-	})
-
-	t.Run("invalid operation type", func(t *testing.T) {
-		reqBody := map[string]interface{}{
-			"walletId":      uuid.New().String(),
-			"operationType": "INVALID",
-			"amount":        100,
-		}
-
-		reqBodyJSON, _ := json.Marshal(reqBody)
-		req := httptest.NewRequest(http.MethodPost, "/wallet/operation", bytes.NewReader(reqBodyJSON))
-		req.Header.Set("Content-Type", "application/json")
-		rr := httptest.NewRecorder()
-
-		handler.HandleWalletOperation(rr, req)
-
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-	})
-
-	t.Run("withdraw fails with insufficient balance", func(t *testing.T) {
-		walletID := uuid.New()
-		reqBody := map[string]interface{}{
-			"walletId":      walletID.String(),
-			"operationType": "WITHDRAW",
-			"amount":        100,
-		}
-
-		mockService.On("Withdraw", mock.Anything, walletID, int64(100)).Return(service.ErrInsufficientFunds)
-
-		reqBodyJSON, _ := json.Marshal(reqBody)
-		req := httptest.NewRequest(http.MethodPost, "/wallet/operation", bytes.NewReader(reqBodyJSON))
-		req.Header.Set("Content-Type", "application/json")
-		rr := httptest.NewRecorder()
-
-		handler.HandleWalletOperation(rr, req)
-
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		mockService.AssertExpectations(t)
-	})
+	hnd := handler.NewWalletHandler(mockService)
+
+	mockService.On("Deposit", mock.Anything, int32(1), int32(1000000), "USD").Return(nil)
+	mockService.On("GetBalance", mock.Anything, "alice").Return(map[string]int32{"USD": 1000000}, nil)
+	rr := httptest.NewRecorder()
+
+	hnd.WalletDeposit(rr, newRequest(t, http.MethodPost, "/api/v1/wallet/deposit", handler.WalletChangeRequest{Currency: "USD", Amount: 100}))
+
+	require.Equal(t, http.StatusOK, rr.Code)
+	var res handler.WalletChangeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+	assert.Equal(t, map[string]float32{"USD": 100}, res.New_balance)
+	mockService.AssertExpectations(t)
 }
 
-func TestGetBalance(t *testing.T) {
+func TestWalletWithdraw_InsufficientFunds(t *testing.T) {
+	mockService := new(MockWalletService)
+	hnd := handler.NewWalletHandler(mockService)
+
+	mockService.On("Withdraw", mock.Anything, int32(1), int32(500000), "USD").Return(service.ErrInsufficientFunds)
+	rr := httptest.NewRecorder()
+
+	hnd.WalletWithdraw(rr, newRequest(t, http.MethodPost, "/api/v1/wallet/withdraw", handler.WalletChangeRequest{Currency: "USD", Amount: 50}))
+
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"insufficient_funds"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestWalletTransfer(t *testing.T) {
 	mockService := new(MockWalletService)
-	handler := handler.NewWalletHandler(mockService)
+	hnd := handler.NewWalletHandler(mockService)
 
-	t.Run("successful balance retrieval", func(t *testing.T) {
-		walletID := uuid.New()
-		mockService.On("GetBalance", mock.Anything, walletID).Return(int64(500), nil)
+	mockService.On("Transfer", mock.Anything, int32(1), "bob", int32(100000), "USD").Return(nil)
+	mockService.On("GetBalance", mock.Anything, "alice").Return(map[string]int32{"USD": 150000}, nil)
+	rr := httptest.NewRecorder()
 
-		req := httptest.NewRequest(http.MethodGet, "/wallet/"+walletID.String(), nil)
-		rr := httptest.NewRecorder()
+	hnd.WalletTransfer(rr, newRequest(t, http.MethodPost, "/api/v1/wallet/transfer", handler.TransferRequest{To: "bob", Currency: "USD", Amount: 10}))
 
-		router := mux.NewRouter()
-		router.HandleFunc("/wallet/{id}", handler.GetBalance)
-		router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var res handler.WalletChangeResponse
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+	assert.Equal(t, map[string]float32{"USD": 15}, res.New_balance)
+	mockService.AssertExpectations(t)
+}
 
-		require.Equal(t, http.StatusOK, rr.Code)
+func TestWalletTransfer_ToSelf(t *testing.T) {
+	mockService := new(MockWalletService)
+	hnd := handler.NewWalletHandler(mockService)
 
-		var response map[string]int64
-		err := json.NewDecoder(rr.Body).Decode(&response)
-		require.NoError(t, err)
-		require.Equal(t, int64(500), response["balance"])
+	mockService.On("Transfer", mock.Anything, int32(1), "alice", int32(100000), "USD").Return(service.ErrSelfTransfer)
+	rr := httptest.NewRecorder()
 
-		mockService.AssertExpectations(t)
-	})
+	hnd.WalletTransfer(rr, newRequest(t, http.MethodPost, "/api/v1/wallet/transfer", handler.TransferRequest{To: "alice", Currency: "USD", Amount: 10}))
 
-	t.Run("invalid wallet ID", func(t *testing.T) {
-		req := httptest.NewRequest(http.MethodGet, "/wallet/invalid-id", nil)
-		rr := httptest.NewRecorder()
+	assert.Equal(t, http.StatusUnprocessableEntity, rr.Code)
+	assert.Contains(t, rr.Body.String(), `"self_transfer"`)
+	mockService.AssertExpectations(t)
+}
+
+func TestGetBalance(t *testing.T) {
+	mockService := new(MockWalletService)
+	hnd := handler.NewWalletHandler(mockService)
 
-		router := mux.NewRouter()
-		router.HandleFunc("/wallet/{id}", handler.GetBalance)
-		router.ServeHTTP(rr, req)
+	mockService.On("GetBalance", mock.Anything, "alice").Return(map[string]int32{"USD": 25000, "EUR": 0}, nil)
+	rr := httptest.NewRecorder()
 
-		require.Equal(t, http.StatusBadRequest, rr.Code)
-	})
+	hnd.GetBalance(rr, newRequest(t, http.MethodGet, "/api/v1/balance", nil))
 
-	t.Run("balance retrieval fails", func(t *testing.T) {
-		walletID := uuid.New()
-		mockService.On("GetBalance", mock.Anything, walletID).Return(int64(0), service.ErrWalletNotFound)
+	require.Equal(t, http.StatusOK, rr.Code)
+	var res map[string]float32
+	require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+	assert.Equal(t, map[string]float32{"USD": 2.5, "EUR": 0}, res)
+	mockService.AssertExpectations(t)
+}
+
+func TestAdjustBalance(t *testing.T) {
+	mockService := new(MockWalletService)
+	hnd := handler.NewWalletHandler(mockService)
+	admin := &auth.Principal{UserID: 9, Username: "root"}
 
-		req := httptest.NewRequest(http.MethodGet, "/wallet/"+walletID.String(), nil)
-		rr := httptest.NewRecorder()
+	mockService.On("AdjustBalance", mock.Anything, int32(9), "alice", int32(-250000), "USD", "failed payout").Return(nil)
+	mockService.On("GetBalance", mock.Anything, "alice").Return(map[string]int32{"USD": 0}, nil)
+	req := newRequest(t, http.MethodPost, "/api/v1/admin/users/alice/balance/adjust", handler.AdjustBalanceRequest{Currency: "USD", Amount: -25, Reason: "failed payout"})
+	req = mux.SetURLVars(req.WithContext(auth.WithPrincipal(req.Context(), admin)), map[string]string{"username": "alice"})
+	rr := httptest.NewRecorder()
 
-		router := mux.NewRouter()
-		router.HandleFunc("/wallet/{id}", handler.GetBalance)
-		router.ServeHTTP(rr, req)
+	hnd.AdjustBalance(rr, req)
 
-		require.Equal(t, http.StatusInternalServerError, rr.Code)
-		mockService.AssertExpectations(t)
-	})
+	require.Equal(t, http.StatusOK, rr.Code)
+	mockService.AssertExpectations(t)
 }
@@ -0,0 +1,233 @@
+// Package limits caps how much money may leave the wallet of a user per calendar day and month. Caps are set
+// per operation and currency, with defaults from the configuration that can be overridden per user.
+package limits
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Operations whose outflows are capped.
+const (
+	OperationWithdraw = "withdraw"
+	OperationExchange = "exchange"
+	OperationTransfer = "transfer"
+)
+
+// Operations lists the capped operations in the order they are shown.
+var Operations = []string{OperationWithdraw, OperationExchange, OperationTransfer}
+
+// IsOperation reports whether operation is a capped operation.
+func IsOperation(operation string) bool {
+	for _, o := range Operations {
+		if o == operation {
+			return true
+		}
+	}
+	return false
+}
+
+// Periods the caps apply to.
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// Unlimited is the cap of a period without a limit.
+const Unlimited int64 = -1
+
+// maxCap is the largest cap accepted, in currency units.
+const maxCap = 1e12
+
+// unit is the number of stored units in one unit of a currency, see the handler package.
+const unit = 10000
+
+// Cap is the most that may leave the wallet per calendar day and per calendar month in UTC, in stored units.
+type Cap struct {
+	Daily   int64
+	Monthly int64
+}
+
+// NoCap does not limit anything.
+var NoCap = Cap{Daily: Unlimited, Monthly: Unlimited}
+
+// Valid reports whether both periods are either Unlimited or not negative.
+func (c Cap) Valid() bool {
+	return c.Daily >= Unlimited && c.Monthly >= Unlimited
+}
+
+// Key identifies the caps of an operation in a currency.
+type Key struct {
+	Operation string
+	Currency  string
+}
+
+// Policy holds the default caps per operation and currency. Currencies without a default are not capped.
+type Policy map[string]map[string]Cap
+
+// Cap returns the default cap of the operation in the currency.
+func (p Policy) Cap(operation string, currency string) Cap {
+	if c, ok := p[operation][currency]; ok {
+		return c
+	}
+	return NoCap
+}
+
+// ParseCaps parses the default caps of an operation, written as comma separated CURRENCY=DAILY/MONTHLY
+// pairs in currency units, e.g. USD=1000/20000,EUR=1000/20000. off disables the cap of a period. An
+// empty string caps nothing.
+func ParseCaps(s string) (map[string]Cap, error) {
+	caps := make(map[string]Cap)
+	if strings.TrimSpace(s) == "" {
+		return caps, nil
+	}
+	for _, pair := range strings.Split(s, ",") {
+		currency, periods, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		daily, monthly, ok2 := strings.Cut(periods, "/")
+		if !ok || !ok2 || currency == "" {
+			return nil, fmt.Errorf("invalid limit %q, expected CURRENCY=DAILY/MONTHLY such as USD=1000/20000", pair)
+		}
+		var c Cap
+		var err error
+		if c.Daily, err = parseAmount(daily); err != nil {
+			return nil, fmt.Errorf("invalid daily limit of %s: %w", currency, err)
+		}
+		if c.Monthly, err = parseAmount(monthly); err != nil {
+			return nil, fmt.Errorf("invalid monthly limit of %s: %w", currency, err)
+		}
+		caps[strings.ToUpper(currency)] = c
+	}
+	return caps, nil
+}
+
+// parseAmount parses an amount in currency units into stored units, off into Unlimited.
+func parseAmount(s string) (int64, error) {
+	if s == "off" {
+		return Unlimited, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not an amount between 0 and %.0f, or off", s, maxCap)
+	}
+	amount, ok := FromUnits(v)
+	if !ok {
+		return 0, fmt.Errorf("%q is not an amount between 0 and %.0f, or off", s, maxCap)
+	}
+	return amount, nil
+}
+
+// FromUnits converts a cap in currency units into stored units. It reports false for a negative cap or one
+// above the largest accepted.
+func FromUnits(v float64) (int64, bool) {
+	if !(v >= 0 && v <= maxCap) {
+		return 0, false
+	}
+	return int64(v*unit + 0.5), true
+}
+
+// Windows returns the start of the calendar day and month containing now, in UTC.
+func Windows(now time.Time) (dayStart time.Time, monthStart time.Time) {
+	now = now.UTC()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC),
+		time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// Usage is what already left the wallet in the current day and month, in stored units.
+type Usage struct {
+	Daily   int64
+	Monthly int64
+}
+
+// PeriodAllowance is what is left of the cap of one period. Limit and Remaining are Unlimited when the
+// period is not capped.
+type PeriodAllowance struct {
+	Limit     int64
+	Used      int64
+	Remaining int64
+	ResetsAt  time.Time
+}
+
+// Allowance is what is left of the caps of an operation in a currency.
+type Allowance struct {
+	Operation string
+	Currency  string
+	Daily     PeriodAllowance
+	Monthly   PeriodAllowance
+}
+
+// NewAllowance calculates what is left of c after used at the time now.
+func NewAllowance(key Key, c Cap, used Usage, now time.Time) Allowance {
+	dayStart, monthStart := Windows(now)
+	return Allowance{
+		Operation: key.Operation,
+		Currency:  key.Currency,
+		Daily:     periodAllowance(c.Daily, used.Daily, dayStart.AddDate(0, 0, 1)),
+		Monthly:   periodAllowance(c.Monthly, used.Monthly, monthStart.AddDate(0, 1, 0)),
+	}
+}
+
+func periodAllowance(limit int64, used int64, resetsAt time.Time) PeriodAllowance {
+	a := PeriodAllowance{Limit: limit, Used: used, Remaining: Unlimited, ResetsAt: resetsAt}
+	if limit != Unlimited {
+		a.Remaining = max(limit-used, 0)
+	}
+	return a
+}
+
+// Check returns an *ExceededError when amount does not fit into the allowance, naming the daily cap
+// before the monthly one.
+func (a Allowance) Check(amount int64) error {
+	for _, p := range []struct {
+		period    string
+		allowance PeriodAllowance
+	}{{PeriodDaily, a.Daily}, {PeriodMonthly, a.Monthly}} {
+		if p.allowance.Limit != Unlimited && amount > p.allowance.Remaining {
+			return &ExceededError{
+				Operation: a.Operation,
+				Currency:  a.Currency,
+				Period:    p.period,
+				Limit:     p.allowance.Limit,
+				Remaining: p.allowance.Remaining,
+				ResetsAt:  p.allowance.ResetsAt,
+			}
+		}
+	}
+	return nil
+}
+
+// ErrLimitExceeded is returned, wrapped in an ExceededError, when an outflow would exceed a cap.
+var ErrLimitExceeded = errors.New("outflow limit exceeded")
+
+// ExceededError tells which cap an outflow would exceed and what is left of it.
+type ExceededError struct {
+	Operation string
+	Currency  string
+	Period    string
+	Limit     int64
+	Remaining int64
+	ResetsAt  time.Time
+}
+
+func (e *ExceededError) Error() string {
+	return fmt.Sprintf("%s: %s %s limit is %s %s, %s %s remaining until %s", ErrLimitExceeded, e.Period, e.Operation,
+		FormatAmount(e.Limit), e.Currency, FormatAmount(e.Remaining), e.Currency, e.ResetsAt.Format(time.RFC3339))
+}
+
+func (e *ExceededError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// FormatAmount formats an amount in stored units as a decimal number of currency units.
+func FormatAmount(v int64) string {
+	return strconv.FormatFloat(float64(v)/unit, 'f', -1, 64)
+}
+
+// Outflow is money leaving the wallet through an operation. It is checked against the caps of the
+// operation, Defaults unless the user has an override, in the transaction that changes the balance.
+type Outflow struct {
+	Operation string
+	Defaults  Cap
+}
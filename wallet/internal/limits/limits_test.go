@@ -0,0 +1,78 @@
+package limits
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCaps(t *testing.T) {
+	caps, err := ParseCaps("USD=1000/20000, rub=50000.5/off")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]Cap{
+		"USD": {Daily: 10000000, Monthly: 200000000},
+		"RUB": {Daily: 500005000, Monthly: Unlimited},
+	}, caps)
+
+	caps, err = ParseCaps("")
+	require.NoError(t, err)
+	assert.Empty(t, caps)
+
+	for _, invalid := range []string{"USD", "USD=1000", "=1/2", "USD=-1/10", "USD=1/x"} {
+		_, err := ParseCaps(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestPolicy_Cap(t *testing.T) {
+	policy := Policy{OperationWithdraw: {"USD": {Daily: 10, Monthly: 100}}}
+
+	assert.Equal(t, Cap{Daily: 10, Monthly: 100}, policy.Cap(OperationWithdraw, "USD"))
+	assert.Equal(t, NoCap, policy.Cap(OperationWithdraw, "EUR"))
+	assert.Equal(t, NoCap, policy.Cap(OperationExchange, "USD"))
+	assert.Equal(t, NoCap, Policy(nil).Cap(OperationWithdraw, "USD"))
+}
+
+func TestWindows(t *testing.T) {
+	day, month := Windows(time.Date(2024, 2, 29, 23, 30, 0, 0, time.FixedZone("UTC-2", -2*3600)))
+
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), day)
+	assert.Equal(t, time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), month)
+}
+
+func TestAllowance_Check(t *testing.T) {
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	key := Key{Operation: OperationWithdraw, Currency: "USD"}
+	allowance := NewAllowance(key, Cap{Daily: 1000, Monthly: 5000}, Usage{Daily: 700, Monthly: 4500}, now)
+
+	assert.Equal(t, PeriodAllowance{Limit: 1000, Used: 700, Remaining: 300, ResetsAt: time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC)}, allowance.Daily)
+	assert.Equal(t, PeriodAllowance{Limit: 5000, Used: 4500, Remaining: 500, ResetsAt: time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)}, allowance.Monthly)
+	assert.NoError(t, allowance.Check(300))
+
+	err := allowance.Check(301)
+	var exceeded *ExceededError
+	require.True(t, errors.As(err, &exceeded))
+	assert.True(t, errors.Is(err, ErrLimitExceeded))
+	assert.Equal(t, PeriodDaily, exceeded.Period)
+	assert.Equal(t, int64(300), exceeded.Remaining)
+
+	allowance = NewAllowance(key, Cap{Daily: Unlimited, Monthly: 5000}, Usage{Daily: 700, Monthly: 4500}, now)
+	assert.Equal(t, Unlimited, allowance.Daily.Remaining)
+	require.True(t, errors.As(allowance.Check(600), &exceeded))
+	assert.Equal(t, PeriodMonthly, exceeded.Period)
+
+	// Usage above a lowered cap leaves nothing, not a negative allowance
+	allowance = NewAllowance(key, Cap{Daily: 500, Monthly: Unlimited}, Usage{Daily: 700}, now)
+	assert.Equal(t, int64(0), allowance.Daily.Remaining)
+	assert.NoError(t, NewAllowance(key, NoCap, Usage{Daily: 1 << 40}, now).Check(1<<30))
+}
+
+func TestExceededError(t *testing.T) {
+	err := &ExceededError{Operation: OperationWithdraw, Currency: "USD", Period: PeriodDaily, Limit: 10000000, Remaining: 25000,
+		ResetsAt: time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC)}
+
+	assert.Equal(t, "outflow limit exceeded: daily withdraw limit is 1000 USD, 2.5 USD remaining until 2024-05-11T00:00:00Z", err.Error())
+}
@@ -0,0 +1,104 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Config holds the logging settings.
+type Config struct {
+	// Level is one of debug, info, warn and error.
+	Level string
+	// Format is json or text.
+	Format string
+}
+
+// LoadConfig loads the logging configuration from LOG_LEVEL and LOG_FORMAT as returned by getenv,
+// defaulting to info and json.
+func LoadConfig(getenv func(string) string) Config {
+	cfg := Config{Level: getenv("LOG_LEVEL"), Format: getenv("LOG_FORMAT")}
+	if cfg.Level == "" {
+		cfg.Level = "info"
+	}
+	if cfg.Format == "" {
+		cfg.Format = "json"
+	}
+	return cfg
+}
+
+// New returns a logger writing to w that adds the request-scoped fields stored in the context by With
+// and redacts secrets and personal data.
+func New(cfg Config, w io.Writer) (*slog.Logger, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q", cfg.Level)
+	}
+
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redact}
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("invalid log format %q", cfg.Format)
+	}
+	return slog.New(contextHandler{handler}), nil
+}
+
+type fieldsKey struct{}
+
+type requestIDKey struct{}
+
+// With returns a copy of ctx carrying additional fields that are added to every record logged with it.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]slog.Attr)
+	merged := append(append([]slog.Attr(nil), fields...), attrs...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// WithRequestID returns a copy of ctx carrying the request ID, which is also added to every record logged with it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return With(context.WithValue(ctx, requestIDKey{}, id), slog.String("request_id", id))
+}
+
+// RequestID returns the request ID stored by WithRequestID, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// Audit logs an administrative action at info level marked with audit=true, so the records can be routed
+// to the audit trail. Users are identified by their IDs, usernames and emails are masked like in every record.
+func Audit(ctx context.Context, action string, attrs ...slog.Attr) {
+	slog.LogAttrs(ctx, slog.LevelInfo, action, append([]slog.Attr{slog.Bool("audit", true)}, attrs...)...)
+}
+
+// contextHandler adds the fields stored in the context and the current trace and span IDs to each record.
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if fields, ok := ctx.Value(fieldsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(fields...)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		r.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
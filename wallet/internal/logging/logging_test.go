@@ -0,0 +1,74 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestLogger_RedactsSecretsAndPersonalData(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(Config{Level: "info", Format: "json"}, &buf)
+	require.NoError(t, err)
+
+	logger.Info("login",
+		slog.String("password", "hunter2"),
+		slog.String("email", "alice@example.com"),
+		slog.String("username", "alice"),
+		slog.String("dsn", "postgres://admin:securepassword@db:5432/wallet"),
+	)
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "[REDACTED]", record["password"])
+	assert.Equal(t, "a***@example.com", record["email"])
+	assert.Equal(t, "a***", record["username"])
+	assert.Equal(t, "postgres://admin:xxxxx@db:5432/wallet", record["dsn"])
+	assert.NotContains(t, buf.String(), "securepassword")
+}
+
+func TestLogger_AddsContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(Config{Level: "info", Format: "json"}, &buf)
+	require.NoError(t, err)
+
+	ctx := WithRequestID(context.Background(), "req-1")
+	ctx = With(ctx, slog.Int("user_id", 7))
+	logger.InfoContext(ctx, "deposit")
+	logger.DebugContext(ctx, "filtered by level")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "req-1", record["request_id"])
+	assert.Equal(t, float64(7), record["user_id"])
+	assert.Equal(t, "req-1", RequestID(ctx))
+}
+
+func TestNew_RejectsInvalidConfig(t *testing.T) {
+	_, err := New(Config{Level: "loud", Format: "json"}, &bytes.Buffer{})
+	assert.Error(t, err)
+	_, err = New(Config{Level: "info", Format: "xml"}, &bytes.Buffer{})
+	assert.Error(t, err)
+}
+
+func TestLogger_AddsTraceIDs(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := New(Config{Level: "info", Format: "json"}, &buf)
+	require.NoError(t, err)
+
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID}))
+	logger.InfoContext(ctx, "exchange")
+
+	var record map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &record))
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", record["trace_id"])
+	assert.Equal(t, "00f067aa0ba902b7", record["span_id"])
+}
@@ -0,0 +1,63 @@
+package logging
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+)
+
+// secretKeys are attribute keys whose values are never logged.
+var secretKeys = map[string]bool{
+	"password":        true,
+	"pw":              true,
+	"secret":          true,
+	"token":           true,
+	"refresh_token":   true,
+	"challenge_token": true,
+	"api_key":         true,
+	"authorization":   true,
+	"code":            true,
+}
+
+// redact replaces secrets by a placeholder and masks personal data: emails and usernames keep only their first
+// character, DSNs and URLs lose their password.
+func redact(groups []string, a slog.Attr) slog.Attr {
+	key := strings.ToLower(a.Key)
+	switch {
+	case secretKeys[key]:
+		return slog.String(a.Key, "[REDACTED]")
+	case key == "email":
+		return slog.String(a.Key, MaskEmail(a.Value.String()))
+	case key == "username":
+		return slog.String(a.Key, mask(a.Value.String()))
+	case key == "dsn" || key == "url":
+		return slog.String(a.Key, RedactURL(a.Value.String()))
+	}
+	return a
+}
+
+// MaskEmail keeps the first character of the local part and the domain, e.g. a***@example.com.
+func MaskEmail(email string) string {
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return mask(email)
+	}
+	return mask(local) + "@" + domain
+}
+
+// RedactURL removes the password from a URL, e.g. a postgres:// DSN. Values that are not URLs are dropped.
+func RedactURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || u.Scheme == "" {
+		return "[REDACTED]"
+	}
+	return u.Redacted()
+}
+
+func mask(value string) string {
+	if value == "" {
+		return ""
+	}
+	r := []rune(value)
+	return string(r[:1]) + "***"
+}
@@ -0,0 +1,138 @@
+package mail
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"wallet/internal/logging"
+)
+
+// Message is a plain text email.
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config holds the mail delivery settings.
+type Config struct {
+	// Driver selects the sender: "smtp", "file" or "log".
+	Driver       string
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	// FilePath is the file the "file" driver appends messages to.
+	FilePath string
+}
+
+// LoadConfig loads the mail configuration from the settings returned by getenv, e.g. os.Getenv.
+func LoadConfig(getenv func(string) string) Config {
+	cfg := Config{
+		Driver:       getenv("MAIL_DRIVER"),
+		From:         getenv("MAIL_FROM"),
+		SMTPHost:     getenv("SMTP_HOST"),
+		SMTPPort:     getenv("SMTP_PORT"),
+		SMTPUsername: getenv("SMTP_USERNAME"),
+		SMTPPassword: getenv("SMTP_PASSWORD"),
+		FilePath:     getenv("MAIL_FILE_PATH"),
+	}
+	if cfg.Driver == "" {
+		cfg.Driver = "log"
+	}
+	if cfg.SMTPPort == "" {
+		cfg.SMTPPort = "587"
+	}
+	return cfg
+}
+
+// NewSender returns the sender selected by the configuration.
+func NewSender(cfg Config) (Sender, error) {
+	switch cfg.Driver {
+	case "smtp":
+		if cfg.SMTPHost == "" || cfg.From == "" {
+			return nil, fmt.Errorf("smtp mail driver requires SMTP_HOST and MAIL_FROM")
+		}
+		return &SMTPSender{cfg: cfg}, nil
+	case "file":
+		if cfg.FilePath == "" {
+			return nil, fmt.Errorf("file mail driver requires MAIL_FILE_PATH")
+		}
+		return &FileSender{path: cfg.FilePath}, nil
+	case "log":
+		return LogSender{}, nil
+	}
+	return nil, fmt.Errorf("unknown mail driver %q", cfg.Driver)
+}
+
+// SMTPSender delivers messages through an SMTP server, authenticating with PLAIN auth when a username is set.
+type SMTPSender struct {
+	cfg Config
+}
+
+// Send delivers the message through the SMTP server.
+func (s *SMTPSender) Send(ctx context.Context, msg Message) error {
+	var auth smtp.Auth
+	if s.cfg.SMTPUsername != "" {
+		auth = smtp.PlainAuth("", s.cfg.SMTPUsername, s.cfg.SMTPPassword, s.cfg.SMTPHost)
+	}
+	addr := net.JoinHostPort(s.cfg.SMTPHost, s.cfg.SMTPPort)
+	return smtp.SendMail(addr, auth, s.cfg.From, []string{msg.To}, format(s.cfg.From, msg))
+}
+
+// FileSender appends messages to a file, for local development.
+type FileSender struct {
+	path string
+	mu   sync.Mutex
+}
+
+// Send appends the message to the file.
+func (s *FileSender) Send(ctx context.Context, msg Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "%s\n\n", format("wallet@localhost", msg))
+	return err
+}
+
+// LogSender writes messages to the service log, for local development. The masked recipient and the subject
+// are logged at info level. The body may contain a token, so it is logged separately at debug level only.
+type LogSender struct{}
+
+// Send logs the message.
+func (LogSender) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Email", slog.String("to", logging.MaskEmail(msg.To)), slog.String("subject", msg.Subject))
+	slog.DebugContext(ctx, "Email body", slog.String("subject", msg.Subject), slog.String("body", msg.Body))
+	return nil
+}
+
+// format renders the message in RFC 5322 format.
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return []byte(b.String())
+}
@@ -0,0 +1,36 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSender_OmitsBody(t *testing.T) {
+	var out bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&out, nil)))
+
+	err := LogSender{}.Send(context.Background(), Message{To: "alice@example.com", Subject: "Reset your password", Body: "token=secret"})
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "a***@example.com")
+	assert.Contains(t, out.String(), "Reset your password")
+	assert.NotContains(t, out.String(), "secret")
+	assert.NotContains(t, out.String(), "alice")
+}
+
+func TestLogSender_LogsBodyAtDebugLevel(t *testing.T) {
+	var out bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	err := LogSender{}.Send(context.Background(), Message{To: "alice@example.com", Subject: "Verify your email", Body: "http://localhost:8080/verify?token=abc"})
+
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "http://localhost:8080/verify?token=abc")
+	assert.NotContains(t, out.String(), "alice@")
+}
@@ -0,0 +1,74 @@
+package mail
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// maxAttempts is the number of delivery attempts after which a message is left in the outbox undelivered.
+const maxAttempts = 5
+
+// QueuedMessage is a message waiting in the outbox.
+type QueuedMessage struct {
+	ID int64
+	Message
+}
+
+// Outbox stores messages until they are delivered. Messages are written to it in the same transaction as
+// the change that caused them, so no email is lost or sent for a change that was rolled back. PendingEmails
+// claims the messages it returns, so dispatchers running in several replicas do not send them twice.
+type Outbox interface {
+	PendingEmails(ctx context.Context, limit int, maxAttempts int) ([]QueuedMessage, error)
+	MarkEmailSent(ctx context.Context, id int64) error
+	MarkEmailFailed(ctx context.Context, id int64, reason string) error
+}
+
+// Dispatcher periodically delivers the messages waiting in the outbox.
+type Dispatcher struct {
+	outbox   Outbox
+	sender   Sender
+	interval time.Duration
+}
+
+// NewDispatcher returns a Dispatcher polling the outbox every interval.
+func NewDispatcher(outbox Outbox, sender Sender, interval time.Duration) *Dispatcher {
+	return &Dispatcher{outbox: outbox, sender: sender, interval: interval}
+}
+
+// Run delivers pending messages until the context is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+
+	for {
+		d.dispatch(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch sends one batch of pending messages.
+func (d *Dispatcher) dispatch(ctx context.Context) {
+	messages, err := d.outbox.PendingEmails(ctx, 50, maxAttempts)
+	if err != nil {
+		slog.ErrorContext(ctx, "Error loading outbox", slog.Any("error", err))
+		return
+	}
+
+	for _, msg := range messages {
+		if err := d.sender.Send(ctx, msg.Message); err != nil {
+			slog.WarnContext(ctx, "Error sending email", slog.Int64("email_id", msg.ID), slog.Any("error", err))
+			if err := d.outbox.MarkEmailFailed(ctx, msg.ID, err.Error()); err != nil {
+				slog.ErrorContext(ctx, "Error recording email failure", slog.Int64("email_id", msg.ID), slog.Any("error", err))
+			}
+			continue
+		}
+		if err := d.outbox.MarkEmailSent(ctx, msg.ID); err != nil {
+			slog.ErrorContext(ctx, "Error marking email as sent", slog.Int64("email_id", msg.ID), slog.Any("error", err))
+		}
+	}
+}
@@ -0,0 +1,107 @@
+// Package metrics defines the Prometheus metrics of the wallet service.
+package metrics
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+// amountUnits is the number of balance units in one currency unit.
+const amountUnits = 10000
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wallet",
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wallet",
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	grpcRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wallet",
+		Name:      "exchanger_grpc_requests_total",
+		Help:      "gRPC requests sent to the exchanger, by method and status code.",
+	}, []string{"method", "code"})
+
+	grpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "wallet",
+		Name:      "exchanger_grpc_request_duration_seconds",
+		Help:      "Latency of gRPC requests sent to the exchanger, by method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+
+	operations = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wallet",
+		Name:      "operations_total",
+		Help:      "Completed balance operations, by operation and currency.",
+	}, []string{"operation", "currency"})
+
+	volume = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wallet",
+		Name:      "operation_volume_total",
+		Help:      "Amount moved by completed balance operations in currency units, by operation and currency.",
+	}, []string{"operation", "currency"})
+
+	rateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "wallet",
+		Name:      "rate_limited_requests_total",
+		Help:      "Requests refused by the rate limiter, by route group.",
+	}, []string{"group"})
+)
+
+// Balance operations counted by RecordOperation.
+const (
+	OperationDeposit  = "deposit"
+	OperationWithdraw = "withdraw"
+	OperationExchange = "exchange"
+	OperationTransfer = "transfer"
+	// Corrections made by admins, adding money to or taking it out of a wallet.
+	OperationAdjustmentCredit = "adjustment_credit"
+	OperationAdjustmentDebit  = "adjustment_debit"
+)
+
+// ObserveHTTPRequest records a handled HTTP request.
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	httpRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	httpDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// RecordOperation counts a completed balance operation and the amount it moved. Exchanges are recorded in
+// the source currency.
+func RecordOperation(operation, currency string, amount int32) {
+	operations.WithLabelValues(operation, currency).Inc()
+	volume.WithLabelValues(operation, currency).Add(float64(amount) / amountUnits)
+}
+
+// RecordRateLimited counts a request refused by the rate limiter of the route group.
+func RecordRateLimited(group string) {
+	rateLimited.WithLabelValues(group).Inc()
+}
+
+// UnaryClientInterceptor counts gRPC requests sent to the exchanger and observes their latency.
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	grpcRequests.WithLabelValues(method, status.Code(err).String()).Inc()
+	grpcDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+	return err
+}
+
+// RegisterDB exports the connection pool statistics of db.
+func RegisterDB(db *sql.DB) {
+	prometheus.MustRegister(collectors.NewDBStatsCollector(db, "wallet"))
+}
@@ -0,0 +1,347 @@
+// Package migrate applies the versioned SQL migrations embedded in the binary and records the applied
+// versions in the schema_migrations table, so schema changes reach existing databases.
+//
+// The wallet and gw-exchanger services carry identical copies of this package. Each service is a separate
+// Go module built from its own directory alone (the Dockerfiles add only that directory), so a shared module
+// would not be available to the build. A fix made here has to be made in the other copy as well.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ErrSchemaBehind is returned by Check when migrations known to the binary are not applied.
+var ErrSchemaBehind = errors.New("database schema is behind the code")
+
+// ErrChecksumMismatch is returned when the up script of an applied migration differs from the one that
+// was applied. Applied migrations must not be edited; the change belongs in a new migration.
+var ErrChecksumMismatch = errors.New("applied migration was changed")
+
+// lockID is the key of the advisory lock held while migrating, so replicas started together do not
+// apply the same migration twice.
+const lockID = 4218523740
+
+// fileName matches migration files, e.g. 003_add_user_roles.up.sql.
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is a single schema version. Up applies it and Down reverts it.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum returns the hex encoded SHA-256 of the up script, recorded when the migration is applied.
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+// Status is a migration and whether it is applied.
+type Status struct {
+	Migration
+	// AppliedAt is zero when the migration is pending.
+	AppliedAt time.Time
+}
+
+// Load reads the migrations from the NNN_name.up.sql and NNN_name.down.sql files in fsys, sorted by version.
+// Every version needs both files.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s, expected NNN_name.up.sql or NNN_name.down.sql", entry.Name())
+		}
+		version, _ := strconv.ParseInt(match[1], 10, 64)
+		if version <= 0 {
+			return nil, fmt.Errorf("invalid migration version in %s", entry.Name())
+		}
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %w", entry.Name(), err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(content)
+		} else {
+			m.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %03d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations on a database.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// New creates a Migrator for the migrations in fsys, see Load.
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest returns the version of the newest migration, 0 when there are none.
+func (m *Migrator) Latest() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Status lists every migration known to the binary and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	applied, _, err := appliedVersions(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = Status{Migration: migration, AppliedAt: applied[migration.Version]}
+	}
+	return statuses, nil
+}
+
+// Check returns ErrSchemaBehind when a migration known to the binary is not applied and
+// ErrChecksumMismatch when one was changed after it was applied. Versions applied by a newer binary are
+// accepted, so the previous version keeps running during a rolling deploy.
+func (m *Migrator) Check(ctx context.Context) error {
+	applied, checksums, err := appliedVersions(ctx, m.db)
+	if err != nil {
+		return err
+	}
+	if err := m.verify(checksums); err != nil {
+		return err
+	}
+	pending := Pending(m.migrations, applied)
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %d pending migrations, first is %03d_%s", ErrSchemaBehind, len(pending), pending[0].Version, pending[0].Name)
+	}
+	return nil
+}
+
+// Up applies every pending migration in version order, each in its own transaction, and returns the
+// applied migrations. It refuses to run when an applied migration was changed, and records the checksum
+// of migrations applied before checksums were kept.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, checksums, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		if err := m.verify(checksums); err != nil {
+			return err
+		}
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; !ok || checksums[migration.Version] != "" {
+				continue
+			}
+			_, err := conn.ExecContext(ctx, "UPDATE public.schema_migrations SET checksum = $2 WHERE version = $1 AND checksum IS NULL",
+				migration.Version, migration.Checksum())
+			if err != nil {
+				return fmt.Errorf("failed to record checksum of migration %03d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		for _, migration := range Pending(m.migrations, applied) {
+			err := inTx(ctx, conn, migration.Up,
+				"INSERT INTO public.schema_migrations (version, name, checksum) VALUES ($1, $2, $3)",
+				migration.Version, migration.Name, migration.Checksum())
+			if err != nil {
+				return fmt.Errorf("failed to apply migration %03d_%s: %w", migration.Version, migration.Name, err)
+			}
+			slog.InfoContext(ctx, "Applied migration", slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Down reverts the last steps applied migrations in reverse version order and returns the reverted
+// migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	byVersion := make(map[int64]Migration, len(m.migrations))
+	for _, migration := range m.migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	var done []Migration
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, _, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+		versions := make([]int64, 0, len(applied))
+		for version := range applied {
+			versions = append(versions, version)
+		}
+		sort.Slice(versions, func(i, j int) bool { return versions[i] > versions[j] })
+
+		for _, version := range versions[:min(steps, len(versions))] {
+			migration, ok := byVersion[version]
+			if !ok {
+				return fmt.Errorf("applied migration %03d is unknown to this binary and cannot be reverted", version)
+			}
+			err := inTx(ctx, conn, migration.Down, "DELETE FROM public.schema_migrations WHERE version = $1", version)
+			if err != nil {
+				return fmt.Errorf("failed to revert migration %03d_%s: %w", migration.Version, migration.Name, err)
+			}
+			slog.InfoContext(ctx, "Reverted migration", slog.Int64("version", migration.Version), slog.String("name", migration.Name))
+			done = append(done, migration)
+		}
+		return nil
+	})
+	return done, err
+}
+
+// Pending returns the migrations whose version is not in applied, in version order.
+func Pending(migrations []Migration, applied map[int64]time.Time) []Migration {
+	var pending []Migration
+	for _, migration := range migrations {
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// verify returns ErrChecksumMismatch for the first known migration whose recorded checksum differs from
+// its up script. Migrations recorded without a checksum are accepted.
+func (m *Migrator) verify(checksums map[int64]string) error {
+	for _, migration := range m.migrations {
+		recorded := checksums[migration.Version]
+		if recorded != "" && recorded != migration.Checksum() {
+			return fmt.Errorf("%w: %03d_%s, add a new migration instead", ErrChecksumMismatch, migration.Version, migration.Name)
+		}
+	}
+	return nil
+}
+
+// withLock runs fn on a dedicated connection holding the migration lock, after creating the
+// schema_migrations table if needed.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS public.schema_migrations (
+  version BIGINT PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  checksum VARCHAR(64)
+)`)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %w", err)
+	}
+	_, err = conn.ExecContext(ctx, "ALTER TABLE public.schema_migrations ADD COLUMN IF NOT EXISTS checksum VARCHAR(64)")
+	if err != nil {
+		return fmt.Errorf("failed to add checksum column to schema_migrations table: %w", err)
+	}
+	return fn(conn)
+}
+
+// inTx runs the migration script and the statement recording it in a single transaction.
+func inTx(ctx context.Context, conn *sql.Conn, script string, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// appliedVersions returns the applied versions with when they were applied and their recorded checksums,
+// none when the schema_migrations table does not exist yet. The checksum is read through to_jsonb so tables
+// created before the column was added can still be checked; versions without a checksum are left out.
+func appliedVersions(ctx context.Context, q querier) (map[int64]time.Time, map[int64]string, error) {
+	var exists bool
+	if err := q.QueryRowContext(ctx, "SELECT to_regclass('public.schema_migrations') IS NOT NULL").Scan(&exists); err != nil {
+		return nil, nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	applied := make(map[int64]time.Time)
+	checksums := make(map[int64]string)
+	if !exists {
+		return applied, checksums, nil
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT version, applied_at, to_jsonb(m)->>'checksum' FROM public.schema_migrations m")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		var checksum sql.NullString
+		if err := rows.Scan(&version, &appliedAt, &checksum); err != nil {
+			return nil, nil, err
+		}
+		applied[version] = appliedAt
+		if checksum.Valid {
+			checksums[version] = checksum.String
+		}
+	}
+	return applied, checksums, rows.Err()
+}
@@ -0,0 +1,145 @@
+// Package openapi holds the OpenAPI 3 document of the wallet API, serves it and validates incoming
+// requests against it.
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"wallet/internal/apierror"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/gorillamux"
+)
+
+//go:embed openapi.yaml
+var spec []byte
+
+func init() {
+	openapi3.DefineStringFormat("email", openapi3.FormatOfStringForEmail)
+	openapi3.DefineStringFormat("uuid", openapi3.FormatOfStringForUUIDOfRFC4122)
+}
+
+// Load parses and validates the embedded document.
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load OpenAPI document: %w", err)
+	}
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI document: %w", err)
+	}
+	return doc, nil
+}
+
+// ServeSpec is an HTTP handler returning the document.
+func ServeSpec(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Write(spec)
+}
+
+// Validator checks requests against the document.
+type Validator struct {
+	router routers.Router
+}
+
+// NewValidator creates a Validator for the embedded document.
+func NewValidator() (*Validator, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	router, err := gorillamux.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to route OpenAPI document: %w", err)
+	}
+	return &Validator{router: router}, nil
+}
+
+// Middleware rejects requests that do not match the document with 400, listing every problem in the error
+// details, before the handlers run. Parameters, content types and bodies are checked; authentication is left
+// to the handler middlewares. Requests for paths the document does not describe are passed through.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, params, err := v.router.FindRoute(r)
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		input := &openapi3filter.RequestValidationInput{
+			Request:    r,
+			PathParams: params,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				MultiError:         true,
+			},
+		}
+		if err := openapi3filter.ValidateRequest(r.Context(), input); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				apierror.Write(w, r, http.StatusRequestEntityTooLarge, apierror.CodeBodyTooLarge, "Request body too large")
+				return
+			}
+			apierror.Write(w, r, http.StatusBadRequest, apierror.CodeValidationFailed, "Invalid request", Problems(err)...)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Problems describes each validation problem in err in a short line naming the offending parameter or
+// body field.
+func Problems(err error) []string {
+	var problems []string
+	var collect func(err error, where string)
+	collect = func(err error, where string) {
+		switch e := err.(type) {
+		case openapi3.MultiError:
+			for _, inner := range e {
+				collect(inner, where)
+			}
+		case *openapi3filter.RequestError:
+			switch {
+			case e.Parameter != nil:
+				where = fmt.Sprintf("%s parameter %s", e.Parameter.In, e.Parameter.Name)
+			case e.RequestBody != nil:
+				where = "body"
+			}
+			if e.Err != nil {
+				collect(e.Err, where)
+			} else {
+				problems = append(problems, describe(where, e.Reason))
+			}
+		case *openapi3.SchemaError:
+			if pointer := e.JSONPointer(); len(pointer) > 0 {
+				where += " field " + strings.Join(pointer, ".")
+			}
+			problems = append(problems, describe(where, e.Reason))
+		case *openapi3filter.ParseError:
+			problems = append(problems, describe(where, e.Error()))
+		default:
+			if inner := errors.Unwrap(err); inner != nil {
+				collect(inner, where)
+			} else {
+				problems = append(problems, describe(where, err.Error()))
+			}
+		}
+	}
+	collect(err, "")
+	return problems
+}
+
+// describe prefixes the problem with the place it was found at, if known.
+func describe(where string, problem string) string {
+	if where == "" {
+		return problem
+	}
+	return strings.TrimSpace(where) + ": " + problem
+}
@@ -0,0 +1,119 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"wallet/internal/apierror"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	require.NoError(t, err)
+	assert.NotNil(t, doc.Paths.Value("/api/v1/exchange"))
+}
+
+func TestValidator_Middleware(t *testing.T) {
+	validator, err := NewValidator()
+	require.NoError(t, err)
+	called := false
+	validated := validator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { called = true }))
+
+	for _, tc := range []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		status   int
+		problems []string
+	}{
+		{
+			name:   "valid deposit",
+			method: http.MethodPost, target: "/api/v1/wallet/deposit",
+			body:   `{"currency": "USD", "amount": 10.5}`,
+			status: http.StatusOK,
+		},
+		{
+			name:   "negative amount and unknown currency",
+			method: http.MethodPost, target: "/api/v1/wallet/withdraw",
+			body:     `{"currency": "BTC", "amount": -5}`,
+			status:   http.StatusBadRequest,
+			problems: []string{"body field currency: value is not one of the allowed values", "body field amount: number must be more than 0"},
+		},
+		{
+			name:   "missing field",
+			method: http.MethodPost, target: "/api/v1/exchange",
+			body:     `{"from_currency": "USD", "amount": 1}`,
+			status:   http.StatusBadRequest,
+			problems: []string{`property "to_currency" is missing`},
+		},
+		{
+			name:   "invalid email",
+			method: http.MethodPost, target: "/api/v1/register",
+			body:     `{"username": "alice", "email": "alice", "pw": "correct horse"}`,
+			status:   http.StatusBadRequest,
+			problems: []string{"body field email"},
+		},
+		{
+			name:   "query parameter out of range",
+			method: http.MethodGet, target: "/api/v1/login-history?limit=1000",
+			status:   http.StatusBadRequest,
+			problems: []string{"query parameter limit"},
+		},
+		{
+			name:   "invalid path parameter",
+			method: http.MethodDelete, target: "/api/v1/sessions/not-a-uuid",
+			status:   http.StatusBadRequest,
+			problems: []string{"path parameter id"},
+		},
+		{
+			name:   "path not in the document",
+			method: http.MethodGet, target: "/debug/vars",
+			status: http.StatusOK,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			called = false
+			req := httptest.NewRequest(tc.method, tc.target, strings.NewReader(tc.body))
+			if tc.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rr := httptest.NewRecorder()
+
+			validated.ServeHTTP(rr, req)
+
+			assert.Equal(t, tc.status, rr.Code)
+			assert.Equal(t, tc.status == http.StatusOK, called)
+			if tc.status != http.StatusOK {
+				var res apierror.Response
+				require.NoError(t, json.NewDecoder(rr.Body).Decode(&res))
+				assert.Equal(t, apierror.CodeValidationFailed, res.Code)
+				assert.NotEmpty(t, res.Details)
+				for _, problem := range tc.problems {
+					assert.Contains(t, strings.Join(res.Details, "\n"), problem)
+				}
+			}
+		})
+	}
+}
+
+func TestValidator_KeepsBodyForHandler(t *testing.T) {
+	validator, err := NewValidator()
+	require.NoError(t, err)
+	var body []byte
+	validated := validator.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body = make([]byte, r.ContentLength)
+		r.Body.Read(body)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/rate", strings.NewReader(`{"from_currency": "USD", "to_currency": "EUR"}`))
+	req.Header.Set("Content-Type", "application/json")
+	validated.ServeHTTP(httptest.NewRecorder(), req)
+
+	assert.JSONEq(t, `{"from_currency": "USD", "to_currency": "EUR"}`, string(body))
+}
@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, err := ParseLimit("10/1m")
+	require.NoError(t, err)
+	assert.Equal(t, Limit{Requests: 10, Window: time.Minute}, limit)
+
+	limit, err = ParseLimit("off")
+	require.NoError(t, err)
+	assert.False(t, limit.Enabled())
+
+	for _, invalid := range []string{"", "10", "ten/1m", "0/1m", "10/0s", "10/soon"} {
+		_, err := ParseLimit(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestMemoryStore_Allow(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 3, Window: time.Minute}
+	ctx := context.Background()
+
+	// The full allowance is available as a burst
+	for want := 2; want >= 0; want-- {
+		result, err := store.Allow(ctx, "alice", limit)
+		require.NoError(t, err)
+		assert.Equal(t, Result{Allowed: true, Remaining: want}, result)
+	}
+	result, _ := store.Allow(ctx, "alice", limit)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 20*time.Second, result.RetryAfter)
+
+	// Other keys have their own allowance
+	result, _ = store.Allow(ctx, "bob", limit)
+	assert.True(t, result.Allowed)
+
+	// One request refills every 20 seconds
+	now = now.Add(20 * time.Second)
+	result, _ = store.Allow(ctx, "alice", limit)
+	assert.True(t, result.Allowed)
+	result, _ = store.Allow(ctx, "alice", limit)
+	assert.False(t, result.Allowed)
+}
+
+func TestMemoryStore_ForgetsRefilledBuckets(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Requests: 3, Window: time.Minute}
+
+	store.Allow(context.Background(), "alice", limit)
+	store.Allow(context.Background(), "bob", limit)
+	now = now.Add(2 * time.Minute)
+	store.Allow(context.Background(), "carol", limit)
+
+	assert.Len(t, store.buckets, 1)
+	assert.Contains(t, store.buckets, "carol")
+}
+
+func TestMemoryStore_DisabledLimit(t *testing.T) {
+	store := NewMemoryStore()
+	for i := 0; i < 10; i++ {
+		result, err := store.Allow(context.Background(), "alice", Limit{})
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+	}
+	assert.Empty(t, store.buckets)
+}
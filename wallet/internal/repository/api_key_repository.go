@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is an API key without its secret. Username and OwnerRoles describe the owning user.
+type APIKey struct {
+	ID         string
+	UserID     int32
+	Username   string
+	OwnerRoles []string
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	ExpiresAt  *time.Time
+	LastUsedAt *time.Time
+	RevokedAt  *time.Time
+	CreatedAt  time.Time
+}
+
+// GetUserByUsername retrieves the user with the username.
+func (r *WalletRepository) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	var user User
+	err := r.db.QueryRowContext(ctx, "SELECT id,username,email,roles,email_verified FROM mydb.users WHERE username = $1", username).
+		Scan(&user.ID, &user.Username, &user.Email, pq.Array(&user.Roles), &user.EmailVerified)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	return user, err
+}
+
+// CreateAPIKey stores a new API key and returns it with its ID and creation time set.
+func (r *WalletRepository) CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error) {
+	key.ID = uuid.NewString()
+	err := r.db.QueryRowContext(ctx, "INSERT INTO mydb.api_keys (id, user_id, name, prefix, key_hash, scopes, expires_at) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING created_at",
+		key.ID, key.UserID, key.Name, key.Prefix, key.KeyHash, pq.Array(key.Scopes), key.ExpiresAt).Scan(&key.CreatedAt)
+	if err != nil {
+		return APIKey{}, err
+	}
+	return key, nil
+}
+
+// ListAPIKeys returns all API keys of the user, including revoked and expired ones, newest first.
+func (r *WalletRepository) ListAPIKeys(ctx context.Context, uid int32) ([]APIKey, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, user_id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at FROM mydb.api_keys WHERE user_id = $1 ORDER BY created_at DESC", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var key APIKey
+		if err := rows.Scan(&key.ID, &key.UserID, &key.Name, &key.Prefix, pq.Array(&key.Scopes), &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey revokes an API key of the user. Revoked keys are kept for the audit trail.
+func (r *WalletRepository) RevokeAPIKey(ctx context.Context, uid int32, id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return ErrAPIKeyNotFound
+	}
+	res, err := r.db.ExecContext(ctx, "UPDATE mydb.api_keys SET revoked_at = COALESCE(revoked_at, CURRENT_TIMESTAMP) WHERE id = $1 AND user_id = $2", id, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// FindAPIKey looks up an API key by its prefix together with the current roles of its owner.
+func (r *WalletRepository) FindAPIKey(ctx context.Context, prefix string) (APIKey, error) {
+	var key APIKey
+	err := r.db.QueryRowContext(ctx, "SELECT k.id, k.user_id, u.username, u.roles, k.name, k.prefix, k.key_hash, k.scopes, k.expires_at, k.last_used_at, k.revoked_at, k.created_at FROM mydb.api_keys AS k JOIN mydb.users AS u ON u.id = k.user_id WHERE k.prefix = $1", prefix).
+		Scan(&key.ID, &key.UserID, &key.Username, pq.Array(&key.OwnerRoles), &key.Name, &key.Prefix, &key.KeyHash, pq.Array(&key.Scopes), &key.ExpiresAt, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt)
+	if err == sql.ErrNoRows {
+		return APIKey{}, ErrAPIKeyNotFound
+	}
+	return key, err
+}
+
+// TouchAPIKey records the use of an API key. To avoid a write on every request the timestamp is only
+// updated once a minute.
+func (r *WalletRepository) TouchAPIKey(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE mydb.api_keys SET last_used_at = CURRENT_TIMESTAMP WHERE id = $1 AND (last_used_at IS NULL OR last_used_at < CURRENT_TIMESTAMP - INTERVAL '1 minute')", id)
+	return err
+}
@@ -0,0 +1,180 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+	"wallet/internal/mail"
+
+	"github.com/lib/pq"
+)
+
+// Purposes of the single-use tokens sent by email.
+const (
+	TokenPurposeVerifyEmail   = "verify_email"
+	TokenPurposePasswordReset = "password_reset"
+)
+
+var (
+	ErrUserTokenInvalid = errors.New("invalid or already used token")
+	ErrUserTokenExpired = errors.New("token expired")
+	ErrUserNotFound     = errors.New("user not found")
+)
+
+// GetUserByEmail retrieves the user registered with the email address.
+func (r *WalletRepository) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	var user User
+	err := r.db.QueryRowContext(ctx, "SELECT id,username,email,roles,email_verified FROM mydb.users WHERE email = $1", email).
+		Scan(&user.ID, &user.Username, &user.Email, pq.Array(&user.Roles), &user.EmailVerified)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	return user, err
+}
+
+// CreateUserToken stores a single-use token for the purpose and queues the email delivering it in the same
+// transaction. Unused tokens previously issued to the user for the same purpose are invalidated.
+func (r *WalletRepository) CreateUserToken(ctx context.Context, uid int32, purpose string, tokenHash string, expiresAt time.Time, email mail.Message) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE mydb.user_tokens SET used_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND purpose = $2 AND used_at IS NULL", uid, purpose)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO mydb.user_tokens (user_id, purpose, token_hash, expires_at) VALUES ($1, $2, $3, $4)", uid, purpose, tokenHash, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO mydb.email_outbox (recipient, subject, body) VALUES ($1, $2, $3)", email.To, email.Subject, email.Body)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// consumeUserTokenTx marks the token as used and returns the ID of the user it was issued to.
+func consumeUserTokenTx(ctx context.Context, tx *sql.Tx, purpose string, tokenHash string) (int32, error) {
+	var tokenID, uid int32
+	var expiresAt time.Time
+	var usedAt sql.NullTime
+	err := tx.QueryRowContext(ctx, "SELECT id, user_id, expires_at, used_at FROM mydb.user_tokens WHERE token_hash = $1 AND purpose = $2 FOR UPDATE", tokenHash, purpose).
+		Scan(&tokenID, &uid, &expiresAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return 0, ErrUserTokenInvalid
+	} else if err != nil {
+		return 0, err
+	}
+
+	if usedAt.Valid {
+		return 0, ErrUserTokenInvalid
+	}
+	if time.Now().After(expiresAt) {
+		return 0, ErrUserTokenExpired
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE mydb.user_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1", tokenID); err != nil {
+		return 0, err
+	}
+	return uid, nil
+}
+
+// VerifyEmail consumes an email verification token and marks the email address of its user as verified.
+func (r *WalletRepository) VerifyEmail(ctx context.Context, tokenHash string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	uid, err := consumeUserTokenTx(ctx, tx, TokenPurposeVerifyEmail, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE mydb.users SET email_verified = TRUE WHERE id = $1", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ResetPassword consumes a password reset token, sets the new password and revokes all sessions of the user,
+// so that whoever knew the old password is logged out.
+func (r *WalletRepository) ResetPassword(ctx context.Context, tokenHash string, password string) error {
+	hashedPassword, err := r.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	uid, err := consumeUserTokenTx(ctx, tx, TokenPurposePasswordReset, tokenHash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// The reset link proves ownership of the email address as well
+	if _, err := tx.ExecContext(ctx, "UPDATE mydb.users SET password = $1, email_verified = TRUE WHERE id = $2", hashedPassword, uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE mydb.sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND revoked_at IS NULL", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// emailLease is how long a claimed email is hidden from other dispatchers. An email whose dispatcher died before
+// recording the outcome is delivered again once the lease ran out.
+const emailLease = 5 * time.Minute
+
+// PendingEmails claims the oldest undelivered emails that have been attempted fewer than maxAttempts times and
+// are not claimed by another dispatcher. Rows locked by a concurrent claim are skipped.
+func (r *WalletRepository) PendingEmails(ctx context.Context, limit int, maxAttempts int) ([]mail.QueuedMessage, error) {
+	rows, err := r.db.QueryContext(ctx, `UPDATE mydb.email_outbox SET locked_until = CURRENT_TIMESTAMP + make_interval(secs => $1)
+		WHERE id IN (
+			SELECT id FROM mydb.email_outbox
+			WHERE sent_at IS NULL AND attempts < $2 AND (locked_until IS NULL OR locked_until < CURRENT_TIMESTAMP)
+			ORDER BY created_at LIMIT $3 FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, recipient, subject, body`, emailLease.Seconds(), maxAttempts, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var messages []mail.QueuedMessage
+	for rows.Next() {
+		var msg mail.QueuedMessage
+		if err := rows.Scan(&msg.ID, &msg.To, &msg.Subject, &msg.Body); err != nil {
+			return nil, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// MarkEmailSent records the successful delivery of an email and clears its body, which may contain a token.
+func (r *WalletRepository) MarkEmailSent(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE mydb.email_outbox SET sent_at = CURRENT_TIMESTAMP, attempts = attempts + 1, body = '', locked_until = NULL WHERE id = $1", id)
+	return err
+}
+
+// MarkEmailFailed records a failed delivery attempt of an email and releases it for the next attempt.
+func (r *WalletRepository) MarkEmailFailed(ctx context.Context, id int64, reason string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE mydb.email_outbox SET attempts = attempts + 1, last_error = $1, locked_until = NULL WHERE id = $2", reason, id)
+	return err
+}
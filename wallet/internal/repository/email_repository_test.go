@@ -0,0 +1,35 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"wallet/internal/mail"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPendingEmails_ClaimsUnlockedRows(t *testing.T) {
+	repo, mock := newTestRepository(t)
+
+	mock.ExpectQuery("UPDATE mydb.email_outbox SET locked_until = (.+) FOR UPDATE SKIP LOCKED").
+		WithArgs(emailLease.Seconds(), 5, 50).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "recipient", "subject", "body"}).AddRow(3, "alice@example.com", "Verify", "link"))
+
+	messages, err := repo.PendingEmails(context.Background(), 50, 5)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []mail.QueuedMessage{{ID: 3, Message: mail.Message{To: "alice@example.com", Subject: "Verify", Body: "link"}}}, messages)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestMarkEmailSent_ClearsBody(t *testing.T) {
+	repo, mock := newTestRepository(t)
+
+	mock.ExpectExec("UPDATE mydb.email_outbox SET sent_at = CURRENT_TIMESTAMP, attempts = attempts \\+ 1, body = '', locked_until = NULL").
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	assert.NoError(t, repo.MarkEmailSent(context.Background(), 3))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
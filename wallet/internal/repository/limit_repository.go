@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+	"wallet/internal/limits"
+)
+
+// checkOutflowTx checks amount leaving the wallet of the user against the caps of the outflow and records it,
+// within the transaction that changes the balance. The user row is locked first, so concurrent outflows of
+// the user are checked one after the other, also when they are handled by different replicas.
+func checkOutflowTx(ctx context.Context, tx *sql.Tx, uid int32, outflow *limits.Outflow, currency string, amount int32) error {
+	var locked int32
+	err := tx.QueryRowContext(ctx, "SELECT id FROM mydb.users WHERE id = $1 FOR UPDATE", uid).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+
+	key := limits.Key{Operation: outflow.Operation, Currency: currency}
+	c := outflow.Defaults
+	var daily, monthly sql.NullInt64
+	err = tx.QueryRowContext(ctx, "SELECT daily, monthly FROM mydb.outflow_limits WHERE user_id = $1 AND operation = $2 AND currency = $3",
+		uid, key.Operation, key.Currency).Scan(&daily, &monthly)
+	if err == nil {
+		c = capFromColumns(daily, monthly)
+	} else if err != sql.ErrNoRows {
+		return err
+	}
+
+	now := time.Now().UTC()
+	dayStart, monthStart := limits.Windows(now)
+	var used limits.Usage
+	err = tx.QueryRowContext(ctx, "SELECT COALESCE(SUM(amount) FILTER (WHERE created_at >= $4), 0), COALESCE(SUM(amount), 0) FROM mydb.outflows WHERE user_id = $1 AND operation = $2 AND currency = $3 AND created_at >= $5",
+		uid, key.Operation, key.Currency, dayStart, monthStart).Scan(&used.Daily, &used.Monthly)
+	if err != nil {
+		return err
+	}
+	if err := limits.NewAllowance(key, c, used, now).Check(int64(amount)); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO mydb.outflows (user_id, operation, currency, amount, created_at) VALUES ($1, $2, $3, $4, $5)",
+		uid, key.Operation, key.Currency, amount, now)
+	return err
+}
+
+// GetLimitOverrides returns the caps that replace the defaults for the user.
+func (r *WalletRepository) GetLimitOverrides(ctx context.Context, uid int32) (map[limits.Key]limits.Cap, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT operation, currency, daily, monthly FROM mydb.outflow_limits WHERE user_id = $1", uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := make(map[limits.Key]limits.Cap)
+	for rows.Next() {
+		var key limits.Key
+		var daily, monthly sql.NullInt64
+		if err := rows.Scan(&key.Operation, &key.Currency, &daily, &monthly); err != nil {
+			return nil, err
+		}
+		overrides[key] = capFromColumns(daily, monthly)
+	}
+	return overrides, rows.Err()
+}
+
+// GetOutflowUsage returns what left the wallet of the user per operation and currency in the day and month
+// containing now.
+func (r *WalletRepository) GetOutflowUsage(ctx context.Context, uid int32, now time.Time) (map[limits.Key]limits.Usage, error) {
+	dayStart, monthStart := limits.Windows(now)
+	rows, err := r.db.QueryContext(ctx, "SELECT operation, currency, COALESCE(SUM(amount) FILTER (WHERE created_at >= $2), 0), SUM(amount) FROM mydb.outflows WHERE user_id = $1 AND created_at >= $3 GROUP BY operation, currency",
+		uid, dayStart, monthStart)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	usage := make(map[limits.Key]limits.Usage)
+	for rows.Next() {
+		var key limits.Key
+		var used limits.Usage
+		if err := rows.Scan(&key.Operation, &key.Currency, &used.Daily, &used.Monthly); err != nil {
+			return nil, err
+		}
+		usage[key] = used
+	}
+	return usage, rows.Err()
+}
+
+// SetLimitOverride replaces the default caps of the operation in the currency for the user.
+func (r *WalletRepository) SetLimitOverride(ctx context.Context, uid int32, key limits.Key, c limits.Cap) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO mydb.outflow_limits (user_id, operation, currency, daily, monthly) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (user_id, operation, currency) DO UPDATE SET daily = EXCLUDED.daily, monthly = EXCLUDED.monthly, updated_at = CURRENT_TIMESTAMP",
+		uid, key.Operation, key.Currency, capColumn(c.Daily), capColumn(c.Monthly))
+	return err
+}
+
+// DeleteLimitOverride restores the default caps of the operation in the currency for the user.
+func (r *WalletRepository) DeleteLimitOverride(ctx context.Context, uid int32, key limits.Key) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM mydb.outflow_limits WHERE user_id = $1 AND operation = $2 AND currency = $3", uid, key.Operation, key.Currency)
+	return err
+}
+
+// capFromColumns converts stored caps, where NULL means not capped.
+func capFromColumns(daily sql.NullInt64, monthly sql.NullInt64) limits.Cap {
+	c := limits.NoCap
+	if daily.Valid {
+		c.Daily = daily.Int64
+	}
+	if monthly.Valid {
+		c.Monthly = monthly.Int64
+	}
+	return c
+}
+
+// capColumn converts the cap of a period for storage.
+func capColumn(v int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: v, Valid: v != limits.Unlimited}
+}
@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// LoginEvent is a recorded login attempt.
+type LoginEvent struct {
+	ID        int64
+	Username  string
+	IP        string
+	UserAgent string
+	Success   bool
+	// Reason is "ok" for successful logins, otherwise why the attempt failed.
+	Reason    string
+	CreatedAt time.Time
+}
+
+// LoginThrottle counts the recent failed login attempts for a username or client IP.
+type LoginThrottle struct {
+	Failures      int
+	LastFailureAt time.Time
+}
+
+// RecordLoginEvent stores a login attempt. It is linked to the account with the username, if there is one.
+func (r *WalletRepository) RecordLoginEvent(ctx context.Context, event LoginEvent) error {
+	_, err := r.db.ExecContext(ctx, "INSERT INTO mydb.login_events (user_id, username, ip, user_agent, success, reason) VALUES ((SELECT id FROM mydb.users WHERE username = $1), $1, $2, $3, $4, $5)",
+		event.Username, event.IP, event.UserAgent, event.Success, event.Reason)
+	return err
+}
+
+// ListLoginEvents returns the most recent login attempts on the account of the user, newest first.
+func (r *WalletRepository) ListLoginEvents(ctx context.Context, uid int32, limit int) ([]LoginEvent, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, username, ip, user_agent, success, reason, created_at FROM mydb.login_events WHERE user_id = $1 ORDER BY created_at DESC, id DESC LIMIT $2", uid, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []LoginEvent
+	for rows.Next() {
+		var event LoginEvent
+		if err := rows.Scan(&event.ID, &event.Username, &event.IP, &event.UserAgent, &event.Success, &event.Reason, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// ReserveLoginAttempt counts a login attempt as failed for every key before the credentials are checked.
+// The counters of the keys are locked and those with failures are passed to allow; unless allow returns an error, the attempt is
+// counted at now in the same transaction, so concurrent attempts always see each other. Failures older than
+// the window are forgotten and counting starts over.
+func (r *WalletRepository) ReserveLoginAttempt(ctx context.Context, keys []string, now time.Time, window time.Duration, allow func(map[string]LoginThrottle) error) error {
+	keys = append([]string(nil), keys...)
+	sort.Strings(keys)
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	// Missing counters are created first, a row that does not exist yet could not be locked
+	if _, err := tx.ExecContext(ctx, "INSERT INTO mydb.login_throttles (key, failures, last_failure_at) SELECT unnest($1::text[]), 0, $2 ORDER BY 1 ON CONFLICT (key) DO NOTHING", pq.Array(keys), now); err != nil {
+		tx.Rollback()
+		return err
+	}
+	rows, err := tx.QueryContext(ctx, "SELECT key, failures, last_failure_at FROM mydb.login_throttles WHERE key = ANY($1) ORDER BY key FOR UPDATE", pq.Array(keys))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	throttles := make(map[string]LoginThrottle)
+	for rows.Next() {
+		var key string
+		var throttle LoginThrottle
+		if err := rows.Scan(&key, &throttle.Failures, &throttle.LastFailureAt); err != nil {
+			rows.Close()
+			tx.Rollback()
+			return err
+		}
+		if throttle.Failures > 0 {
+			throttles[key] = throttle
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := allow(throttles); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE mydb.login_throttles SET
+			failures = CASE WHEN last_failure_at < $3 THEN 1 ELSE failures + 1 END,
+			last_failure_at = $2
+		WHERE key = ANY($1)`, pq.Array(keys), now, now.Add(-window)); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ReleaseLoginAttempt takes back an attempt reserved with ReserveLoginAttempt that turned out not to fail.
+func (r *WalletRepository) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, "UPDATE mydb.login_throttles SET failures = failures - 1 WHERE key = $1 AND failures > 0", key)
+	return err
+}
+
+// ResetLoginThrottle forgets the failed attempts of the key.
+func (r *WalletRepository) ResetLoginThrottle(ctx context.Context, key string) error {
+	_, err := r.db.ExecContext(ctx, "DELETE FROM mydb.login_throttles WHERE key = $1", key)
+	return err
+}
@@ -0,0 +1,53 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReserveLoginAttempt_CountsAfterLocking(t *testing.T) {
+	repo, mock := newTestRepository(t)
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mydb.login_throttles").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT key, failures, last_failure_at FROM mydb.login_throttles .* FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "failures", "last_failure_at"}).AddRow("ip:203.0.113.7", 0, now).AddRow("user:alice", 2, now))
+	mock.ExpectExec("UPDATE mydb.login_throttles SET").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	var seen map[string]LoginThrottle
+	err := repo.ReserveLoginAttempt(context.Background(), []string{"user:alice", "ip:203.0.113.7"}, now, time.Hour, func(throttles map[string]LoginThrottle) error {
+		seen = throttles
+		return nil
+	})
+
+	require.NoError(t, err)
+	// Counters created for the lock only are not failures
+	assert.Equal(t, map[string]LoginThrottle{"user:alice": {Failures: 2, LastFailureAt: now}}, seen)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestReserveLoginAttempt_RefusedIsNotCounted(t *testing.T) {
+	repo, mock := newTestRepository(t)
+	refused := errors.New("blocked")
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO mydb.login_throttles").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT key, failures, last_failure_at FROM mydb.login_throttles .* FOR UPDATE").
+		WillReturnRows(sqlmock.NewRows([]string{"key", "failures", "last_failure_at"}).AddRow("user:alice", 10, time.Now()))
+	mock.ExpectRollback()
+
+	err := repo.ReserveLoginAttempt(context.Background(), []string{"user:alice"}, time.Now(), time.Hour, func(map[string]LoginThrottle) error {
+		return refused
+	})
+
+	assert.ErrorIs(t, err, refused)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,194 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrRefreshTokenInvalid = errors.New("invalid refresh token")
+	ErrRefreshTokenExpired = errors.New("refresh token expired")
+	ErrRefreshTokenReused  = errors.New("refresh token reused, session revoked")
+	ErrSessionRevoked      = errors.New("session revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// sessionTouchInterval limits how often last_seen_at of a session is written.
+const sessionTouchInterval = time.Minute
+
+// Session represents a login session of a user.
+type Session struct {
+	ID         string
+	UserID     int32
+	Username   string
+	Roles      []string
+	DeviceName string
+	IP         string
+	UserAgent  string
+	CreatedAt  time.Time
+	LastSeenAt time.Time
+}
+
+// SessionDevice describes the device a session was started from.
+type SessionDevice struct {
+	Name      string
+	IP        string
+	UserAgent string
+}
+
+// CreateSession starts a new session for the user and stores its first refresh token.
+func (r *WalletRepository) CreateSession(ctx context.Context, uid int32, refreshTokenHash string, expiresAt time.Time, device SessionDevice) (string, error) {
+	sessionID := uuid.NewString()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO mydb.sessions (id, user_id, device_name, ip, user_agent) VALUES ($1, $2, $3, $4, $5)", sessionID, uid, device.Name, device.IP, device.UserAgent)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO mydb.refresh_tokens (session_id, token_hash, expires_at) VALUES ($1, $2, $3)", sessionID, refreshTokenHash, expiresAt)
+	if err != nil {
+		tx.Rollback()
+		return "", err
+	}
+
+	return sessionID, tx.Commit()
+}
+
+// RotateRefreshToken exchanges a refresh token for a new one in the same session. A refresh token can be
+// used only once: presenting an already used token means it was stolen, so the whole session is revoked
+// and ErrRefreshTokenReused is returned. The session is marked as seen from ip.
+func (r *WalletRepository) RotateRefreshToken(ctx context.Context, oldHash string, newHash string, expiresAt time.Time, ip string) (Session, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Session{}, err
+	}
+
+	var tokenID int32
+	var session Session
+	var tokenExpiresAt time.Time
+	var usedAt, revokedAt sql.NullTime
+	err = tx.QueryRowContext(ctx, "SELECT rt.id, rt.expires_at, rt.used_at, s.id, s.revoked_at, u.id, u.username, u.roles FROM mydb.refresh_tokens AS rt JOIN mydb.sessions AS s ON s.id = rt.session_id JOIN mydb.users AS u ON u.id = s.user_id WHERE rt.token_hash = $1 FOR UPDATE OF rt, s", oldHash).
+		Scan(&tokenID, &tokenExpiresAt, &usedAt, &session.ID, &revokedAt, &session.UserID, &session.Username, pq.Array(&session.Roles))
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return Session{}, ErrRefreshTokenInvalid
+	} else if err != nil {
+		tx.Rollback()
+		return Session{}, err
+	}
+
+	if revokedAt.Valid {
+		tx.Rollback()
+		return Session{}, ErrSessionRevoked
+	}
+
+	if usedAt.Valid {
+		slog.WarnContext(ctx, "Refresh token reuse detected, revoking session", slog.String("session_id", session.ID), slog.Int("user_id", int(session.UserID)))
+		if _, err := tx.ExecContext(ctx, "UPDATE mydb.sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1", session.ID); err != nil {
+			tx.Rollback()
+			return Session{}, err
+		}
+		if err := tx.Commit(); err != nil {
+			return Session{}, err
+		}
+		return Session{}, ErrRefreshTokenReused
+	}
+
+	if time.Now().After(tokenExpiresAt) {
+		tx.Rollback()
+		return Session{}, ErrRefreshTokenExpired
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE mydb.refresh_tokens SET used_at = CURRENT_TIMESTAMP WHERE id = $1", tokenID); err != nil {
+		tx.Rollback()
+		return Session{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO mydb.refresh_tokens (session_id, token_hash, expires_at) VALUES ($1, $2, $3)", session.ID, newHash, expiresAt); err != nil {
+		tx.Rollback()
+		return Session{}, err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE mydb.sessions SET last_seen_at = CURRENT_TIMESTAMP, ip = $1 WHERE id = $2", ip, session.ID); err != nil {
+		tx.Rollback()
+		return Session{}, err
+	}
+
+	return session, tx.Commit()
+}
+
+// RevokeSession revokes the session so that neither its access tokens nor its refresh tokens are accepted anymore.
+func (r *WalletRepository) RevokeSession(ctx context.Context, uid int32, sessionID string) error {
+	if _, err := uuid.Parse(sessionID); err != nil {
+		return ErrSessionNotFound
+	}
+	res, err := r.db.ExecContext(ctx, "UPDATE mydb.sessions SET revoked_at = CURRENT_TIMESTAMP WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL", sessionID, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of the user except the one with keepSessionID and returns how
+// many were revoked.
+func (r *WalletRepository) RevokeOtherSessions(ctx context.Context, uid int32, keepSessionID string) (int64, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE mydb.sessions SET revoked_at = CURRENT_TIMESTAMP WHERE user_id = $1 AND id::text <> $2 AND revoked_at IS NULL", uid, keepSessionID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// ListSessions returns the sessions of the user that are neither revoked nor expired, most recently seen first.
+func (r *WalletRepository) ListSessions(ctx context.Context, uid int32) ([]Session, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT s.id, s.user_id, s.device_name, s.ip, s.user_agent, s.created_at, s.last_seen_at FROM mydb.sessions AS s
+		WHERE s.user_id = $1 AND s.revoked_at IS NULL
+			AND EXISTS (SELECT 1 FROM mydb.refresh_tokens AS rt WHERE rt.session_id = s.id AND rt.used_at IS NULL AND rt.expires_at > $2)
+		ORDER BY s.last_seen_at DESC`, uid, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []Session
+	for rows.Next() {
+		var session Session
+		if err := rows.Scan(&session.ID, &session.UserID, &session.DeviceName, &session.IP, &session.UserAgent, &session.CreatedAt, &session.LastSeenAt); err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	return sessions, rows.Err()
+}
+
+// IsSessionActive reports whether the session exists and has not been revoked. As it is called for every
+// authenticated request, it also records that the session was seen, at most once per sessionTouchInterval.
+func (r *WalletRepository) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	var active bool
+	var lastSeenAt time.Time
+	err := r.db.QueryRowContext(ctx, "SELECT revoked_at IS NULL, last_seen_at FROM mydb.sessions WHERE id = $1", sessionID).Scan(&active, &lastSeenAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	if active && time.Since(lastSeenAt) > sessionTouchInterval {
+		if _, err := r.db.ExecContext(ctx, "UPDATE mydb.sessions SET last_seen_at = CURRENT_TIMESTAMP WHERE id = $1", sessionID); err != nil {
+			slog.ErrorContext(ctx, "Error updating last seen time of session", slog.String("session_id", sessionID), slog.Any("error", err))
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,110 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotEnrolled    = errors.New("two-factor authentication is not enrolled")
+)
+
+// GetUserByID retrieves a user together with its two-factor settings.
+func (r *WalletRepository) GetUserByID(ctx context.Context, uid int32) (User, error) {
+	var user User
+	var secret sql.NullString
+	err := r.db.QueryRowContext(ctx, "SELECT id,username,email,password,roles,totp_secret,totp_enabled,email_verified FROM mydb.users WHERE id = $1", uid).
+		Scan(&user.ID, &user.Username, &user.Email, &user.Password, pq.Array(&user.Roles), &secret, &user.TOTPEnabled, &user.EmailVerified)
+	if err == sql.ErrNoRows {
+		return User{}, ErrUserNotFound
+	}
+	user.TOTPSecret = secret.String
+	return user, err
+}
+
+// SetPendingTOTPSecret stores a new TOTP secret that becomes active once it is confirmed with EnableTOTP.
+func (r *WalletRepository) SetPendingTOTPSecret(ctx context.Context, uid int32, secret string) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE mydb.users SET totp_secret = $1, totp_last_step = NULL WHERE id = $2 AND NOT totp_enabled", secret, uid)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrTwoFactorAlreadyEnabled
+	}
+	return nil
+}
+
+// EnableTOTP activates the pending TOTP secret and replaces the user's recovery codes.
+func (r *WalletRepository) EnableTOTP(ctx context.Context, uid int32, step int64, recoveryCodeHashes []string) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE mydb.users SET totp_enabled = TRUE, totp_last_step = $1 WHERE id = $2 AND totp_secret IS NOT NULL AND NOT totp_enabled", step, uid)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return ErrTwoFactorNotEnrolled
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mydb.recovery_codes WHERE user_id = $1", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO mydb.recovery_codes (user_id, code_hash) VALUES ($1, $2)", uid, hash); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP removes the TOTP secret and all recovery codes of the user.
+func (r *WalletRepository) DisableTOTP(ctx context.Context, uid int32) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE mydb.users SET totp_secret = NULL, totp_enabled = FALSE, totp_last_step = NULL WHERE id = $1", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mydb.recovery_codes WHERE user_id = $1", uid); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ConsumeTOTPStep records the time step of an accepted TOTP code. It returns false when a code of the same
+// or a later step was already used, so every code is accepted at most once.
+func (r *WalletRepository) ConsumeTOTPStep(ctx context.Context, uid int32, step int64) (bool, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE mydb.users SET totp_last_step = $1 WHERE id = $2 AND (totp_last_step IS NULL OR totp_last_step < $1)", step, uid)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// UseRecoveryCode marks an unused recovery code of the user as used and reports whether one matched.
+func (r *WalletRepository) UseRecoveryCode(ctx context.Context, uid int32, codeHash string) (bool, error) {
+	res, err := r.db.ExecContext(ctx, "UPDATE mydb.recovery_codes SET used_at = CURRENT_TIMESTAMP WHERE id = (SELECT id FROM mydb.recovery_codes WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL LIMIT 1)", uid, codeHash)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
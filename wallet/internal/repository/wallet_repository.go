@@ -2,23 +2,47 @@ package repository
 
 import (
 	"context"
-	"crypto/sha256"
+	"crypto/tls"
 	"database/sql"
-	"encoding/hex"
 	"errors"
 	"fmt"
-	"log"
-	"os"
+	"log/slog"
 	"sync"
 	"time"
+	"wallet/internal/auth"
+	"wallet/internal/limits"
+	"wallet/internal/logging"
+	"wallet/internal/mail"
+	"wallet/internal/metrics"
+	"wallet/internal/tracing"
 
 	// "wallet-service/internal/model"
 
 	// pb "wallet/internal/grpc/proto-exchange/grpc/pb"
 	pb "github.com/SafetyDuck5676/grpc_duck/proto-exchange"
-	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/XSAM/otelsql"
 	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var (
+	ErrCurrencyNotFound     = errors.New("currency not found")
+	ErrWalletNotFound       = errors.New("wallet not found")
+	ErrInsufficientFunds    = errors.New("insufficient funds")
+	ErrUsernameTaken        = errors.New("username already exists")
+	ErrEmailTaken           = errors.New("email already exists")
+	ErrExchangerUnavailable = errors.New("exchange rates are temporarily unavailable")
+	ErrRateNotFound         = errors.New("no exchange rate for the currency pair")
 )
 
 // Wallet represents a wallet model.
@@ -30,30 +54,93 @@ type Wallet struct {
 
 // User represents a user model for the login system.
 type User struct {
-	ID       int32
-	Username string
-	Email    string
-	Password string // Stored as a hash
+	ID          int32
+	Username    string
+	Email       string
+	Password    string // Stored as a hash
+	Roles       []string
+	TOTPSecret  string
+	TOTPEnabled bool
+	// EmailVerified is set once the user confirmed the email address with a verification token.
+	EmailVerified bool
+}
+
+// ExchangeRate is a rate of the exchanger. UpdatedAt is when the exchanger last updated the rate, zero when
+// the exchanger did not report it.
+type ExchangeRate struct {
+	Rate      float32
+	UpdatedAt time.Time
 }
 
+// rateUpdatedAtHeader is the response header in which the exchanger reports when a rate was last updated.
+const rateUpdatedAtHeader = "rate-updated-at"
+
+// Token is the result of a login. When the user has two-factor authentication enabled, only a challenge
+// token is returned and has to be exchanged for the access and refresh tokens with a second factor.
 type Token struct {
-	Token string `json:"token"`
+	Token             string    `json:"token,omitempty"`
+	ExpiresAt         time.Time `json:"expires_at"`
+	RefreshToken      string    `json:"refresh_token,omitempty"`
+	TwoFactorRequired bool      `json:"two_factor_required,omitempty"`
+	ChallengeToken    string    `json:"challenge_token,omitempty"`
 }
 
 // WalletRepository handles wallet-related database operations.
 type WalletRepository struct {
-	db *sql.DB
-	mu sync.Mutex // To handle concurrent operations
+	db        *sql.DB
+	passwords *auth.PasswordHasher
+	exchanger pb.ExchangeServiceClient
+	health    healthpb.HealthClient
+	// exchangerTimeout bounds every call to the exchanger.
+	exchangerTimeout time.Duration
+	mu               sync.Mutex // To handle concurrent operations
 }
 
 // WalletRepositoryInterface defines the contract for wallet operations.
 type WalletRepositoryInterface interface {
 	GetBalance(ctx context.Context, username string) (map[string]int32, error)
-	UpdateBalance(ctx context.Context, uid int32, amount int32, currency string) error
+	UpdateBalance(ctx context.Context, uid int32, amount int32, currency string, outflow *limits.Outflow) error
+	ExchangeBalance(ctx context.Context, uid int32, from string, fromAmount int32, to string, toAmount int32, outflow *limits.Outflow) error
+	TransferBalance(ctx context.Context, fromUID int32, toUID int32, amount int32, currency string, outflow *limits.Outflow) error
 	GetExchangeRates(ctx context.Context) (map[string]float64, error)
-	GetExchangeRate(ctx context.Context, from string, to string) (float32, error)
-	RegisterUser(ctx context.Context, username, email, password string) error
-	Login(ctx context.Context, username, password string) (Token, error)
+	GetExchangeRate(ctx context.Context, from string, to string) (ExchangeRate, error)
+	RegisterUser(ctx context.Context, username, email, password string) (int32, error)
+	Login(ctx context.Context, username, password string) (User, error)
+	CreateSession(ctx context.Context, uid int32, refreshTokenHash string, expiresAt time.Time, device SessionDevice) (string, error)
+	RotateRefreshToken(ctx context.Context, oldHash string, newHash string, expiresAt time.Time, ip string) (Session, error)
+	RevokeSession(ctx context.Context, uid int32, sessionID string) error
+	RevokeOtherSessions(ctx context.Context, uid int32, keepSessionID string) (int64, error)
+	ListSessions(ctx context.Context, uid int32) ([]Session, error)
+	IsSessionActive(ctx context.Context, sessionID string) (bool, error)
+	SetUserRoles(ctx context.Context, username string, roles []string) error
+	GetUserByID(ctx context.Context, uid int32) (User, error)
+	SetPendingTOTPSecret(ctx context.Context, uid int32, secret string) error
+	EnableTOTP(ctx context.Context, uid int32, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, uid int32) error
+	ConsumeTOTPStep(ctx context.Context, uid int32, step int64) (bool, error)
+	UseRecoveryCode(ctx context.Context, uid int32, codeHash string) (bool, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+	CreateUserToken(ctx context.Context, uid int32, purpose string, tokenHash string, expiresAt time.Time, email mail.Message) error
+	VerifyEmail(ctx context.Context, tokenHash string) error
+	ResetPassword(ctx context.Context, tokenHash string, password string) error
+	PendingEmails(ctx context.Context, limit int, maxAttempts int) ([]mail.QueuedMessage, error)
+	MarkEmailSent(ctx context.Context, id int64) error
+	MarkEmailFailed(ctx context.Context, id int64, reason string) error
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	CreateAPIKey(ctx context.Context, key APIKey) (APIKey, error)
+	ListAPIKeys(ctx context.Context, uid int32) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, uid int32, id string) error
+	FindAPIKey(ctx context.Context, prefix string) (APIKey, error)
+	TouchAPIKey(ctx context.Context, id string) error
+	RecordLoginEvent(ctx context.Context, event LoginEvent) error
+	ListLoginEvents(ctx context.Context, uid int32, limit int) ([]LoginEvent, error)
+	ReserveLoginAttempt(ctx context.Context, keys []string, now time.Time, window time.Duration, allow func(map[string]LoginThrottle) error) error
+	ReleaseLoginAttempt(ctx context.Context, key string) error
+	ResetLoginThrottle(ctx context.Context, key string) error
+	GetLimitOverrides(ctx context.Context, uid int32) (map[limits.Key]limits.Cap, error)
+	GetOutflowUsage(ctx context.Context, uid int32, now time.Time) (map[limits.Key]limits.Usage, error)
+	SetLimitOverride(ctx context.Context, uid int32, key limits.Key, c limits.Cap) error
+	DeleteLimitOverride(ctx context.Context, uid int32, key limits.Key) error
 }
 
 // Config holds database configuration details.
@@ -64,18 +151,11 @@ type Config struct {
 	Password string
 	DBName   string
 	SSLMode  string
-}
 
-// LoadConfigFromEnv loads database configuration from environment variables.
-func LoadConfigFromEnv() Config {
-	return Config{
-		Host:     os.Getenv("DB_HOST"),
-		Port:     os.Getenv("DB_PORT"),
-		Username: os.Getenv("DB_USER"),
-		Password: os.Getenv("DB_PASSWORD"),
-		DBName:   os.Getenv("DB_NAME"),
-		SSLMode:  os.Getenv("DB_SSLMODE"),
-	}
+	// Connection pool settings
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
 }
 
 // NewPostgresDB initializes a new PostgreSQL database connection.
@@ -84,7 +164,10 @@ func NewPostgresDB(cfg Config) (*sql.DB, error) {
 		"host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
 		cfg.Host, cfg.Port, cfg.Username, cfg.Password, cfg.DBName, cfg.SSLMode,
 	)
-	db, err := sql.Open("postgres", dsn)
+	db, err := otelsql.Open("postgres", dsn,
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{OmitConnResetSession: true, OmitRows: true}),
+	)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -93,26 +176,38 @@ func NewPostgresDB(cfg Config) (*sql.DB, error) {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
 	// Connection pool settings
-	db.SetMaxOpenConns(50)
-	db.SetMaxIdleConns(25)
-	db.SetConnMaxLifetime(5 * time.Minute)
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	return db, nil
 }
 
-// NewWalletRepository creates a new WalletRepository instance.
-func NewWalletRepository(db *sql.DB) *WalletRepository {
-	return &WalletRepository{db: db}
+// NewWalletRepository creates a new WalletRepository instance fetching exchange rates over the exchanger
+// connection, see DialExchanger, giving each call at most exchangerTimeout. The caller closes the connection.
+func NewWalletRepository(db *sql.DB, passwords *auth.PasswordHasher, exchanger *grpc.ClientConn, exchangerTimeout time.Duration) *WalletRepository {
+	return &WalletRepository{
+		db:               db,
+		passwords:        passwords,
+		exchanger:        pb.NewExchangeServiceClient(exchanger),
+		health:           healthpb.NewHealthClient(exchanger),
+		exchangerTimeout: exchangerTimeout,
+	}
 }
 
 // GetBalance retrieves the balance of a specific wallet.
-func (r *WalletRepository) GetBalance(ctx context.Context, username string) (map[string]int32, error) {
-	log.Println("Get balances")
-	log.Println(username)
+func (r *WalletRepository) GetBalance(ctx context.Context, username string) (_ map[string]int32, err error) {
+	ctx, span := tracing.Start(ctx, "WalletRepository.GetBalance")
+	defer func() { tracing.End(span, err) }()
+
 	var balances = make(map[string]int32)
 
 	// Query to get the balance of a user's wallet
 	query := "SELECT balance, currency FROM mydb.users JOIN mydb.wallets AS wallets ON users.id = wallets.user_id JOIN mydb.balances AS balance ON wallets.id = balance.wallet_id JOIN mydb.currencies ON mydb.currencies.id = balance.currency_id WHERE users.username = $1"
 	rows, err := r.db.QueryContext(ctx, query, username)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
 	// Handle rows and scan the results
 	for rows.Next() {
@@ -123,17 +218,21 @@ func (r *WalletRepository) GetBalance(ctx context.Context, username string) (map
 		}
 		balances[currency] = balance
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, errors.New("user not found")
+	if len(balances) == 0 {
+		return nil, ErrWalletNotFound
 	}
-	return balances, err
+	return balances, nil
 }
 
-// UpdateBalance updates the wallet balance after acquiring a lock.
-func (r *WalletRepository) UpdateBalance(ctx context.Context, uid int32, amount int32, currency string) error {
-	log.Println("Updating balance")
-	log.Println(uid, amount, currency)
+// UpdateBalance updates the wallet balance after acquiring a lock. A withdrawal passes the outflow, which is
+// checked against the limits of the user in the same transaction.
+func (r *WalletRepository) UpdateBalance(ctx context.Context, uid int32, amount int32, currency string, outflow *limits.Outflow) (err error) {
+	ctx, span := tracing.Start(ctx, "WalletRepository.UpdateBalance", attribute.String("currency", currency))
+	defer func() { tracing.End(span, err) }()
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
@@ -143,16 +242,101 @@ func (r *WalletRepository) UpdateBalance(ctx context.Context, uid int32, amount
 		return err
 	}
 
+	if outflow != nil {
+		if err := checkOutflowTx(ctx, tx, uid, outflow, currency, -amount); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := updateBalanceTx(ctx, tx, uid, amount, currency); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// ExchangeBalance withdraws fromAmount in the source currency and deposits toAmount in the target currency
+// inside a single transaction, so either both balances change or neither does. The withdrawn amount is
+// checked against the limits of the user as outflow.
+func (r *WalletRepository) ExchangeBalance(ctx context.Context, uid int32, from string, fromAmount int32, to string, toAmount int32, outflow *limits.Outflow) (err error) {
+	ctx, span := tracing.Start(ctx, "WalletRepository.ExchangeBalance", attribute.String("from", from), attribute.String("to", to))
+	defer func() { tracing.End(span, err) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if outflow != nil {
+		if err := checkOutflowTx(ctx, tx, uid, outflow, from, fromAmount); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := updateBalanceTx(ctx, tx, uid, -fromAmount, from); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := updateBalanceTx(ctx, tx, uid, toAmount, to); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TransferBalance moves amount in the currency from the wallet of one user to the wallet of another inside a
+// single transaction. The amount is checked against the limits of the sender as outflow. The balances are
+// changed in the order of the user IDs, so opposite transfers between two users cannot deadlock.
+func (r *WalletRepository) TransferBalance(ctx context.Context, fromUID int32, toUID int32, amount int32, currency string, outflow *limits.Outflow) (err error) {
+	ctx, span := tracing.Start(ctx, "WalletRepository.TransferBalance", attribute.String("currency", currency))
+	defer func() { tracing.End(span, err) }()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if outflow != nil {
+		if err := checkOutflowTx(ctx, tx, fromUID, outflow, currency, amount); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	changes := []struct {
+		uid    int32
+		amount int32
+	}{{fromUID, -amount}, {toUID, amount}}
+	if toUID < fromUID {
+		changes[0], changes[1] = changes[1], changes[0]
+	}
+	for _, change := range changes {
+		if err := updateBalanceTx(ctx, tx, change.uid, change.amount, currency); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// updateBalanceTx adds amount to the user's balance in the given currency within an open transaction.
+// The caller is responsible for rolling back the transaction when an error is returned.
+func updateBalanceTx(ctx context.Context, tx *sql.Tx, uid int32, amount int32, currency string) error {
 	// Select currency ID by currency name
 	var currency_id int32
-	err = tx.QueryRowContext(ctx, "SELECT id FROM mydb.currencies WHERE currency = $1", currency).Scan(&currency_id)
+	err := tx.QueryRowContext(ctx, "SELECT id FROM mydb.currencies WHERE currency = $1", currency).Scan(&currency_id)
 	if err == sql.ErrNoRows {
-		log.Println("Currency not found")
-		tx.Rollback()
-		return errors.New("currency not found")
+		return ErrCurrencyNotFound
 	} else if err != nil {
-		log.Printf("Error with currency: %v", err)
-		tx.Rollback()
+		slog.ErrorContext(ctx, "Error looking up currency", slog.String("currency", currency), slog.Any("error", err))
 		return err
 	}
 
@@ -160,12 +344,9 @@ func (r *WalletRepository) UpdateBalance(ctx context.Context, uid int32, amount
 	var balance_id int32
 	err = tx.QueryRowContext(ctx, "SELECT mydb.balances.id FROM mydb.wallets INNER JOIN mydb.balances ON mydb.balances.wallet_id = mydb.wallets.id  WHERE mydb.wallets.user_id = $1 AND mydb.balances.currency_id = $2", uid, currency_id).Scan(&balance_id)
 	if err == sql.ErrNoRows {
-		log.Println("Wallet not found")
-		tx.Rollback()
-		return errors.New("wallet not found")
+		return ErrWalletNotFound
 	} else if err != nil {
-		log.Printf("Error with wallet: %v", err)
-		tx.Rollback()
+		slog.ErrorContext(ctx, "Error looking up wallet", slog.String("currency", currency), slog.Any("error", err))
 		return err
 	}
 
@@ -173,39 +354,30 @@ func (r *WalletRepository) UpdateBalance(ctx context.Context, uid int32, amount
 	var newBalance int32
 	err = tx.QueryRowContext(ctx, "UPDATE mydb.balances SET balance = balance + $1 WHERE id = $2 RETURNING balance", amount, balance_id).Scan(&newBalance)
 	if err != nil {
-		log.Printf("Error with update: %v", err)
-		log.Printf("Error with update: %v", "UPDATE mydb.balances SET balance = balance + $1 WHERE id = $2 RETURNING balance")
-		log.Println(amount, balance_id, currency_id)
-		tx.Rollback()
+		slog.ErrorContext(ctx, "Error updating balance", slog.Int("balance_id", int(balance_id)), slog.Any("error", err))
 		return err
 	}
 	if newBalance < 0 {
-		tx.Rollback()
-		return errors.New("insufficient funds")
+		return ErrInsufficientFunds
 	}
 
-	return tx.Commit()
+	return nil
 }
 
 // Get exchange rates from server
-func (r *WalletRepository) GetExchangeRates(ctx context.Context) (map[string]float64, error) {
-	// Set up a connection to the server.
-	conn, err := grpc.Dial("server:50051", grpc.WithInsecure())
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
-	}
-	defer conn.Close()
-	// Create a new client
-	client := pb.NewExchangeServiceClient(conn)
+func (r *WalletRepository) GetExchangeRates(ctx context.Context) (_ map[string]float64, err error) {
+	ctx, span := tracing.Start(ctx, "WalletRepository.GetExchangeRates")
+	defer func() { tracing.End(span, err) }()
 
 	// Create the context
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(exchangerContext(ctx), r.exchangerTimeout)
 	defer cancel()
 
 	// Call the GetExchangeRates method to retrieve the exchange rates from the server
-	res, err := client.GetExchangeRates(ctx, &pb.Empty{})
+	res, err := r.exchanger.GetExchangeRates(ctx, &pb.Empty{})
 	if err != nil {
-		log.Fatalf("could not get rates: %v", err)
+		slog.ErrorContext(ctx, "Could not get exchange rates", slog.Any("error", err))
+		return nil, exchangerError(err)
 	}
 	// Extract the rates from the response
 	rates := res.GetRates()
@@ -213,19 +385,13 @@ func (r *WalletRepository) GetExchangeRates(ctx context.Context) (map[string]flo
 	return rates, nil
 }
 
-// GetExchangeRate retrieves the exchange rate between two currencies.
-func (r *WalletRepository) GetExchangeRate(ctx context.Context, from string, to string) (float32, error) {
-	// Set up a connection to the server.
-	conn, err := grpc.Dial("server:50051", grpc.WithInsecure())
-	if err != nil {
-		log.Fatalf("did not connect: %v", err)
-	}
-	defer conn.Close()
-	// Create a new client
-	client := pb.NewExchangeServiceClient(conn)
+// GetExchangeRate retrieves the exchange rate between two currencies and when the exchanger last updated it.
+func (r *WalletRepository) GetExchangeRate(ctx context.Context, from string, to string) (_ ExchangeRate, err error) {
+	ctx, span := tracing.Start(ctx, "WalletRepository.GetExchangeRate", attribute.String("from", from), attribute.String("to", to))
+	defer func() { tracing.End(span, err) }()
 
 	// Create the context
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(exchangerContext(ctx), r.exchangerTimeout)
 	defer cancel()
 
 	// Make a new Currency request
@@ -235,26 +401,84 @@ func (r *WalletRepository) GetExchangeRate(ctx context.Context, from string, to
 	}
 
 	// Call the GetExchangeRateForCurrency method to retrieve the exchange rate between two currencies from the server
-	res, err := client.GetExchangeRateForCurrency(ctx, req)
+	var header metadata.MD
+	res, err := r.exchanger.GetExchangeRateForCurrency(ctx, req, grpc.Header(&header))
 	if err != nil {
-		log.Println(from, to)
-		log.Println("could not get rate: ", err)
-		return 0, err
+		slog.ErrorContext(ctx, "Could not get exchange rate", slog.String("from", from), slog.String("to", to), slog.Any("error", err))
+		return ExchangeRate{}, exchangerError(err)
+	}
+	// Extract the rate from the response and its update time from the header
+	rate := ExchangeRate{Rate: res.GetRate()}
+	if values := header.Get(rateUpdatedAtHeader); len(values) > 0 {
+		if rate.UpdatedAt, err = time.Parse(time.RFC3339Nano, values[0]); err != nil {
+			slog.WarnContext(ctx, "Invalid rate update time", slog.String("value", values[0]))
+			rate.UpdatedAt = time.Time{}
+		}
 	}
-	// Extract the rate from the response
-	rate := res.GetRate()
 
 	return rate, nil
 }
 
-// RegisterUser creates a new user account and wallets.
-func (r *WalletRepository) RegisterUser(ctx context.Context, username string, email string, password string) error {
+// exchangerError reports the exchanger being unreachable or too slow as ErrExchangerUnavailable, and a
+// currency pair it has no rate for as ErrRateNotFound.
+func exchangerError(err error) error {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded:
+		return fmt.Errorf("%w: %v", ErrExchangerUnavailable, err)
+	case codes.NotFound:
+		return fmt.Errorf("%w: %s", ErrRateNotFound, status.Convert(err).Message())
+	}
+	return err
+}
+
+// DialExchanger sets up the connection to the exchanger at addr, encrypted with tlsConfig unless it is nil.
+// It connects lazily and reconnects when the exchanger restarts. Calls are counted in the metrics and
+// traced, with the trace context propagated to the exchanger.
+func DialExchanger(addr string, tlsConfig *tls.Config) (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if tlsConfig != nil {
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	return grpc.Dial(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithUnaryInterceptor(metrics.UnaryClientInterceptor),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
+}
+
+// PingDatabase checks that a database connection can be established.
+func (r *WalletRepository) PingDatabase(ctx context.Context) error {
+	return r.db.PingContext(ctx)
+}
+
+// PingExchanger asks the exchanger whether it can serve rates, using the standard gRPC health service.
+func (r *WalletRepository) PingExchanger(ctx context.Context) error {
+	res, err := r.health.Check(exchangerContext(ctx), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		return err
+	}
+	if res.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+		return fmt.Errorf("exchanger is %s", res.GetStatus())
+	}
+	return nil
+}
+
+// exchangerContext passes the request ID on to the exchanger, so its logs can be correlated with ours.
+func exchangerContext(ctx context.Context) context.Context {
+	if id := logging.RequestID(ctx); id != "" {
+		return metadata.AppendToOutgoingContext(ctx, "x-request-id", id)
+	}
+	return ctx
+}
+
+// RegisterUser creates a new user account and wallets and returns the ID of the user.
+func (r *WalletRepository) RegisterUser(ctx context.Context, username string, email string, password string) (int32, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 	// start a new transaction on the database
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// check if the username or email already exists
@@ -263,25 +487,29 @@ func (r *WalletRepository) RegisterUser(ctx context.Context, username string, em
 	err = tx.QueryRow("SELECT username,email FROM mydb.users WHERE username = $1 OR email = $2", username, email).Scan(&usernameScan, &emailScan)
 	if err != nil && err != sql.ErrNoRows {
 		tx.Rollback()
-		return err
+		return 0, err
 	}
 
 	if usernameScan == username {
 		tx.Rollback()
-		return errors.New("username already exists")
+		return 0, ErrUsernameTaken
 	}
 
 	if emailScan == email {
 		tx.Rollback()
-		return errors.New("email already exists")
+		return 0, ErrEmailTaken
 	}
 
 	// insert the new user into the database
-	hashedPassword := hashPassword(password)
+	hashedPassword, err := r.passwords.Hash(password)
+	if err != nil {
+		tx.Rollback()
+		return 0, err
+	}
 	var lastInsertID int32
 	err = r.db.QueryRowContext(ctx, "INSERT INTO mydb.users (username, email, password) VALUES ($1, $2, $3) RETURNING id", username, email, hashedPassword).Scan(&lastInsertID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// create wallets for the new user
@@ -292,7 +520,7 @@ func (r *WalletRepository) RegisterUser(ctx context.Context, username string, em
 
 	if errUSD != nil || errRUB != nil || errEUR != nil {
 		tx.Rollback()
-		return errors.New("failed to create wallets")
+		return 0, errors.New("failed to create wallets")
 	}
 
 	// create balances for the new wallets
@@ -301,48 +529,59 @@ func (r *WalletRepository) RegisterUser(ctx context.Context, username string, em
 	_, errEUR = r.db.ExecContext(ctx, "INSERT INTO mydb.balances (balance,wallet_id, currency_id) VALUES ($1, $2, $3) RETURNING id", 0, eurWallet, 3)
 	if errUSD != nil || errRUB != nil || errEUR != nil {
 		tx.Rollback()
-		return errors.New("failed to create balances")
+		return 0, errors.New("failed to create balances")
 
 	}
-	return tx.Commit()
+	return lastInsertID, tx.Commit()
 }
 
-// Login verifies the user credentials and generates a JWT token.
-func (r *WalletRepository) Login(ctx context.Context, username, password string) (Token, error) {
-	var uid int32
-	var hashedPassword string
+// ErrInvalidCredentials is returned by Login for an unknown username or a wrong password.
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Login verifies the user credentials and returns the authenticated user.
+func (r *WalletRepository) Login(ctx context.Context, username, password string) (User, error) {
+	var user User
 
 	// Query the database for the user's ID and hashed password
-	err := r.db.QueryRowContext(ctx, "SELECT id,password FROM mydb.users WHERE username = $1", username).
-		Scan(&uid, &hashedPassword)
+	err := r.db.QueryRowContext(ctx, "SELECT id,username,email,password,roles,totp_enabled FROM mydb.users WHERE username = $1", username).
+		Scan(&user.ID, &user.Username, &user.Email, &user.Password, pq.Array(&user.Roles), &user.TOTPEnabled)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			return Token{}, errors.New("invalid username or password")
+			return User{}, ErrInvalidCredentials
 		}
-		return Token{}, err
+		return User{}, err
 	}
 
-	if hashPassword(password) != hashedPassword {
-		return Token{}, errors.New("invalid username or password")
+	ok, needsRehash, err := r.passwords.Verify(password, user.Password)
+	if err != nil {
+		return User{}, err
+	}
+	if !ok {
+		return User{}, ErrInvalidCredentials
 	}
 
-	// Generate JWT token with user ID and username
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"uid":      float64(uid),
-		"username": username,
-		"exp":      time.Now().Add(time.Hour * 72).Unix(),
-	})
-
-	// Sign the token with a secret key
-	tokenString, err := token.SignedString([]byte("your_secret_key"))
-	if err != nil {
-		return Token{}, err
+	// Transparently upgrade legacy or outdated hashes now that the plain password is known
+	if needsRehash {
+		if rehashed, err := r.passwords.Hash(password); err != nil {
+			slog.ErrorContext(ctx, "Error rehashing password", slog.Any("error", err))
+		} else if _, err := r.db.ExecContext(ctx, "UPDATE mydb.users SET password = $1 WHERE id = $2 AND password = $3", rehashed, user.ID, user.Password); err != nil {
+			slog.ErrorContext(ctx, "Error storing rehashed password", slog.Any("error", err))
+		} else {
+			user.Password = rehashed
+		}
 	}
-	return Token{Token: tokenString}, nil
+
+	return user, nil
 }
 
-// hashPassword hashes a password using SHA-256.
-func hashPassword(password string) string {
-	hash := sha256.Sum256([]byte(password))
-	return hex.EncodeToString(hash[:])
+// SetUserRoles replaces the roles of a user. The new roles are applied to tokens issued from then on.
+func (r *WalletRepository) SetUserRoles(ctx context.Context, username string, roles []string) error {
+	res, err := r.db.ExecContext(ctx, "UPDATE mydb.users SET roles = $1 WHERE username = $2", pq.Array(roles), username)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
 }
@@ -5,173 +5,160 @@ import (
 	"database/sql"
 	"testing"
 	"time"
-	"wallet/internal/model"
 
 	"github.com/DATA-DOG/go-sqlmock"
-	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-func TestGetBalance_Success(t *testing.T) {
+func newTestRepository(t *testing.T) (*WalletRepository, sqlmock.Sqlmock) {
 	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
 
-	repo := NewWalletRepository(db)
+	return NewWalletRepository(db, nil, nil, time.Second), mock
+}
 
-	walletID := uuid.New()
-	expectedBalance := int64(5000)
+func TestGetBalance_Success(t *testing.T) {
+	repo, mock := newTestRepository(t)
 
-	mock.ExpectQuery("SELECT balance FROM wallets WHERE id = \\$1").
-		WithArgs(walletID).
-		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(expectedBalance))
+	mock.ExpectQuery("SELECT balance, currency FROM mydb.users").
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "currency"}).AddRow(5000, "USD"))
 
-	balance, err := repo.GetBalance(context.Background(), walletID)
+	balances, err := repo.GetBalance(context.Background(), "alice")
 
 	assert.NoError(t, err)
-	assert.Equal(t, expectedBalance, balance)
-	mock.ExpectationsWereMet()
+	assert.Equal(t, map[string]int32{"USD": 5000}, balances)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestGetBalance_WalletNotFound(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	repo := NewWalletRepository(db)
+	repo, mock := newTestRepository(t)
 
-	walletID := uuid.New()
+	mock.ExpectQuery("SELECT balance, currency FROM mydb.users").
+		WithArgs("bob").
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "currency"}))
 
-	mock.ExpectQuery("SELECT balance FROM wallets WHERE id = \\$1").
-		WithArgs(walletID).
-		WillReturnError(sql.ErrNoRows)
+	balances, err := repo.GetBalance(context.Background(), "bob")
 
-	balance, err := repo.GetBalance(context.Background(), walletID)
-
-	assert.Error(t, err)
-	assert.Equal(t, int64(0), balance)
-	assert.Equal(t, "wallet not found", err.Error())
-	mock.ExpectationsWereMet()
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+	assert.Empty(t, balances)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestUpdateBalance_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	repo := NewWalletRepository(db)
-
-	walletID := uuid.New()
-	initialBalance := int64(5000)
-	amountToAdd := int64(2000)
-	newBalance := initialBalance + amountToAdd
+	repo, mock := newTestRepository(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT balance FROM wallets WHERE id = \\$1 FOR UPDATE").
-		WithArgs(walletID).
-		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(initialBalance))
-	mock.ExpectExec("UPDATE wallets SET balance = \\$1, updated_at = \\$2 WHERE id = \\$3").
-		WithArgs(newBalance, sqlmock.AnyArg(), walletID).
-		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT id FROM mydb.currencies").WithArgs("USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT mydb.balances.id FROM mydb.wallets").WithArgs(int32(1), int32(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("UPDATE mydb.balances SET balance = balance \\+ \\$1").WithArgs(int32(2000), int32(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(7000))
 	mock.ExpectCommit()
 
-	err = repo.UpdateBalance(context.Background(), walletID, amountToAdd)
+	err := repo.UpdateBalance(context.Background(), 1, 2000, "USD", nil)
 
 	assert.NoError(t, err)
-	mock.ExpectationsWereMet()
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestUpdateBalance_WalletNotFound(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	repo := NewWalletRepository(db)
-
-	walletID := uuid.New()
-	amountToAdd := int64(2000)
+	repo, mock := newTestRepository(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT balance FROM wallets WHERE id = \\$1 FOR UPDATE").
-		WithArgs(walletID).
+	mock.ExpectQuery("SELECT id FROM mydb.currencies").WithArgs("USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT mydb.balances.id FROM mydb.wallets").WithArgs(int32(1), int32(2)).
 		WillReturnError(sql.ErrNoRows)
 	mock.ExpectRollback()
 
-	err = repo.UpdateBalance(context.Background(), walletID, amountToAdd)
+	err := repo.UpdateBalance(context.Background(), 1, 2000, "USD", nil)
 
-	assert.Error(t, err)
-	assert.Equal(t, "wallet not found", err.Error())
-	mock.ExpectationsWereMet()
+	assert.ErrorIs(t, err, ErrWalletNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestUpdateBalance_InsufficientFunds(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
-
-	repo := NewWalletRepository(db)
-
-	walletID := uuid.New()
-	initialBalance := int64(5000)
-	amountToWithdraw := int64(-6000)
+	repo, mock := newTestRepository(t)
 
 	mock.ExpectBegin()
-	mock.ExpectQuery("SELECT balance FROM wallets WHERE id = \\$1 FOR UPDATE").
-		WithArgs(walletID).
-		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(initialBalance))
+	mock.ExpectQuery("SELECT id FROM mydb.currencies").WithArgs("USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT mydb.balances.id FROM mydb.wallets").WithArgs(int32(1), int32(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("UPDATE mydb.balances SET balance = balance \\+ \\$1").WithArgs(int32(-6000), int32(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(-1000))
 	mock.ExpectRollback()
 
-	err = repo.UpdateBalance(context.Background(), walletID, amountToWithdraw)
+	err := repo.UpdateBalance(context.Background(), 1, -6000, "USD", nil)
 
-	assert.Error(t, err)
-	assert.Equal(t, "insufficient funds", err.Error())
-	mock.ExpectationsWereMet()
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
 func TestGetWallet_Success(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+	repo, mock := newTestRepository(t)
 
-	repo := NewWalletRepository(db)
+	mock.ExpectQuery("SELECT balance, currency FROM mydb.users").
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"balance", "currency"}).AddRow(5000, "USD").AddRow(0, "EUR").AddRow(120000, "RUB"))
 
-	walletID := uuid.New()
-	expectedWallet := &model.Wallet{
-		ID:        walletID,
-		Balance:   5000,
-		UpdatedAt: time.Now(),
-	}
+	wallet, err := repo.GetBalance(context.Background(), "alice")
 
-	mock.ExpectQuery("SELECT id, balance, updated_at FROM wallets WHERE id = \\$1").
-		WithArgs(walletID).
-		WillReturnRows(sqlmock.NewRows([]string{"id", "balance", "updated_at"}).
-			AddRow(expectedWallet.ID, expectedWallet.Balance, expectedWallet.UpdatedAt))
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int32{"USD": 5000, "EUR": 0, "RUB": 120000}, wallet)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-	wallet, err := repo.GetWallet(context.Background(), walletID)
+func TestGetWallet_CurrencyNotFound(t *testing.T) {
+	repo, mock := newTestRepository(t)
 
-	assert.NoError(t, err)
-	assert.Equal(t, expectedWallet.ID, wallet.ID)
-	assert.Equal(t, expectedWallet.Balance, wallet.Balance)
-	mock.ExpectationsWereMet()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM mydb.currencies").WithArgs("XYZ").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+
+	err := repo.UpdateBalance(context.Background(), 1, 2000, "XYZ", nil)
+
+	assert.ErrorIs(t, err, ErrCurrencyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func TestGetWallet_WalletNotFound(t *testing.T) {
-	db, mock, err := sqlmock.New()
-	assert.NoError(t, err)
-	defer db.Close()
+func TestTransferBalance_LocksBalancesInUserOrder(t *testing.T) {
+	repo, mock := newTestRepository(t)
 
-	repo := NewWalletRepository(db)
+	// bob (ID 2) sends to alice (ID 1): alice's balance is changed first
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT id FROM mydb.currencies").WithArgs("USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT mydb.balances.id FROM mydb.wallets").WithArgs(int32(1), int32(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(7))
+	mock.ExpectQuery("UPDATE mydb.balances SET balance = balance \\+ \\$1").WithArgs(int32(2000), int32(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(7000))
+	mock.ExpectQuery("SELECT id FROM mydb.currencies").WithArgs("USD").
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(2))
+	mock.ExpectQuery("SELECT mydb.balances.id FROM mydb.wallets").WithArgs(int32(2), int32(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(9))
+	mock.ExpectQuery("UPDATE mydb.balances SET balance = balance \\+ \\$1").WithArgs(int32(-2000), int32(9)).
+		WillReturnRows(sqlmock.NewRows([]string{"balance"}).AddRow(-500))
+	mock.ExpectRollback()
 
-	walletID := uuid.New()
+	err := repo.TransferBalance(context.Background(), 2, 1, 2000, "USD", nil)
 
-	mock.ExpectQuery("SELECT id, balance, updated_at FROM wallets WHERE id = \\$1").
-		WithArgs(walletID).
-		WillReturnError(sql.ErrNoRows)
+	assert.ErrorIs(t, err, ErrInsufficientFunds)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
 
-	wallet, err := repo.GetWallet(context.Background(), walletID)
+func TestExchangerError(t *testing.T) {
+	assert.ErrorIs(t, exchangerError(status.Error(codes.NotFound, "exchange rate not found: USD to XYZ")), ErrRateNotFound)
+	assert.ErrorIs(t, exchangerError(status.Error(codes.Unavailable, "connection refused")), ErrExchangerUnavailable)
+	assert.ErrorIs(t, exchangerError(status.Error(codes.DeadlineExceeded, "deadline exceeded")), ErrExchangerUnavailable)
 
-	assert.Error(t, err)
-	assert.Nil(t, wallet)
-	assert.Equal(t, "wallet not found", err.Error())
-	mock.ExpectationsWereMet()
+	internal := status.Error(codes.Internal, "failed to get exchange rate")
+	assert.Equal(t, internal, exchangerError(internal))
 }
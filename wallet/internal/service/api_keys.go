@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/logging"
+	"wallet/internal/repository"
+)
+
+var (
+	ErrInvalidAPIKey = errors.New("invalid api key")
+	ErrAPIKeyScope   = errors.New("api key scopes must be permissions of the key owner")
+	ErrAPIKeyRequest = errors.New("invalid api key request")
+)
+
+// adminKeyScopes are the scopes an administrator may grant to a key owned by another user. Keys that move
+// money or administer users can only be created by their owner, so an administrator cannot act as anyone else.
+var adminKeyScopes = []string{auth.PermWalletRead, auth.PermRatesRead}
+
+// CreateAPIKey creates an API key owned by the user and returns it together with the key itself,
+// which is not stored and cannot be shown again.
+func (s *WalletService) CreateAPIKey(ctx context.Context, uid int32, name string, scopes []string, expiresAt *time.Time) (repository.APIKey, string, error) {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return repository.APIKey{}, "", err
+	}
+	return s.createAPIKey(ctx, user, name, scopes, expiresAt)
+}
+
+// CreateUserAPIKey creates a read-only API key owned by another user, e.g. for the reporting of an organization.
+// The key is limited to adminKeyScopes and its creation is written to the audit log.
+func (s *WalletService) CreateUserAPIKey(ctx context.Context, adminID int32, username string, name string, scopes []string, expiresAt *time.Time) (repository.APIKey, string, error) {
+	for _, scope := range scopes {
+		if !contains(adminKeyScopes, scope) {
+			return repository.APIKey{}, "", fmt.Errorf("%w: %q cannot be granted by an administrator", ErrAPIKeyScope, scope)
+		}
+	}
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return repository.APIKey{}, "", err
+	}
+	created, key, err := s.createAPIKey(ctx, user, name, scopes, expiresAt)
+	if err != nil {
+		return repository.APIKey{}, "", err
+	}
+
+	logging.Audit(ctx, "API key created for user",
+		slog.Int("admin_id", int(adminID)),
+		slog.Int("user_id", int(user.ID)),
+		slog.String("api_key_id", created.ID),
+		slog.Any("scopes", scopes),
+	)
+	return created, key, nil
+}
+
+func (s *WalletService) createAPIKey(ctx context.Context, owner repository.User, name string, scopes []string, expiresAt *time.Time) (repository.APIKey, string, error) {
+	if strings.TrimSpace(name) == "" {
+		return repository.APIKey{}, "", fmt.Errorf("%w: name is required", ErrAPIKeyRequest)
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return repository.APIKey{}, "", fmt.Errorf("%w: expiry must be in the future", ErrAPIKeyRequest)
+	}
+	if len(scopes) == 0 {
+		return repository.APIKey{}, "", fmt.Errorf("%w: at least one scope is required", ErrAPIKeyScope)
+	}
+	granted := auth.PermissionsFor(owner.Roles)
+	for _, scope := range scopes {
+		if !contains(granted, scope) {
+			return repository.APIKey{}, "", fmt.Errorf("%w: %q", ErrAPIKeyScope, scope)
+		}
+	}
+
+	key, prefix, hash, err := auth.NewAPIKey()
+	if err != nil {
+		return repository.APIKey{}, "", err
+	}
+	created, err := s.repo.CreateAPIKey(ctx, repository.APIKey{
+		UserID:    owner.ID,
+		Username:  owner.Username,
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    scopes,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return repository.APIKey{}, "", err
+	}
+	return created, key, nil
+}
+
+// ListAPIKeys returns the API keys of the user.
+func (s *WalletService) ListAPIKeys(ctx context.Context, uid int32) ([]repository.APIKey, error) {
+	return s.repo.ListAPIKeys(ctx, uid)
+}
+
+// RevokeAPIKey revokes an API key of the user.
+func (s *WalletService) RevokeAPIKey(ctx context.Context, uid int32, id string) error {
+	return s.repo.RevokeAPIKey(ctx, uid, id)
+}
+
+// VerifyAPIKey authenticates an API key and returns the principal it acts as. The key is limited to the
+// scopes it was created with that its owner still holds, so removing a role from the owner also takes the
+// permissions away from their keys.
+func (s *WalletService) VerifyAPIKey(ctx context.Context, key string) (*auth.Principal, error) {
+	prefix, ok := auth.APIKeyPrefixOf(key)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	stored, err := s.repo.FindAPIKey(ctx, prefix)
+	if errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidAPIKey
+	} else if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(auth.HashOpaqueToken(key)), []byte(stored.KeyHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if stored.RevokedAt != nil || (stored.ExpiresAt != nil && time.Now().After(*stored.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	granted := auth.PermissionsFor(stored.OwnerRoles)
+	var scopes []string
+	for _, scope := range stored.Scopes {
+		if contains(granted, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if err := s.repo.TouchAPIKey(ctx, stored.ID); err != nil {
+		slog.ErrorContext(ctx, "Error recording use of api key", slog.String("api_key_id", stored.ID), slog.Any("error", err))
+	}
+
+	return &auth.Principal{
+		UserID:   stored.UserID,
+		Username: stored.Username,
+		APIKeyID: stored.ID,
+		Roles:    stored.OwnerRoles,
+		Scopes:   scopes,
+	}, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// apiKeyRepository stores API keys in memory.
+type apiKeyRepository struct {
+	fakeRepository
+
+	keys    map[string]repository.APIKey
+	touched int
+}
+
+func (f *apiKeyRepository) CreateAPIKey(ctx context.Context, key repository.APIKey) (repository.APIKey, error) {
+	key.ID = "key-1"
+	key.OwnerRoles = []string{auth.RoleUser}
+	f.keys[key.Prefix] = key
+	return key, nil
+}
+
+func (f *apiKeyRepository) GetUserByUsername(ctx context.Context, username string) (repository.User, error) {
+	return repository.User{ID: 2, Username: username, Roles: []string{auth.RoleUser}}, nil
+}
+
+func (f *apiKeyRepository) FindAPIKey(ctx context.Context, prefix string) (repository.APIKey, error) {
+	key, ok := f.keys[prefix]
+	if !ok {
+		return repository.APIKey{}, repository.ErrAPIKeyNotFound
+	}
+	return key, nil
+}
+
+func (f *apiKeyRepository) TouchAPIKey(ctx context.Context, id string) error {
+	f.touched++
+	return nil
+}
+
+func TestAPIKey_CreateAndVerify(t *testing.T) {
+	repo := &apiKeyRepository{keys: map[string]repository.APIKey{}}
+	srv := NewWalletService(repo, nil, Config{})
+
+	created, key, err := srv.CreateAPIKey(context.Background(), 1, "payouts", []string{auth.PermWalletRead}, nil)
+	require.NoError(t, err)
+	assert.True(t, auth.IsAPIKey(key))
+	assert.Equal(t, auth.HashOpaqueToken(key), created.KeyHash)
+
+	principal, err := srv.VerifyAPIKey(context.Background(), key)
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), principal.UserID)
+	assert.Equal(t, "key-1", principal.APIKeyID)
+	assert.Empty(t, principal.SessionID)
+	assert.True(t, principal.Can(auth.PermWalletRead))
+	assert.False(t, principal.Can(auth.PermWalletWrite))
+	assert.Equal(t, 1, repo.touched)
+
+	_, err = srv.VerifyAPIKey(context.Background(), key+"x")
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
+
+func TestAPIKey_ScopesBeyondOwnerPermissionsAreRejected(t *testing.T) {
+	repo := &apiKeyRepository{keys: map[string]repository.APIKey{}}
+	srv := NewWalletService(repo, nil, Config{})
+
+	_, _, err := srv.CreateAPIKey(context.Background(), 1, "admin", []string{auth.PermUsersAdmin}, nil)
+	assert.ErrorIs(t, err, ErrAPIKeyScope)
+}
+
+func TestCreateUserAPIKey_OnlyReadScopes(t *testing.T) {
+	repo := &apiKeyRepository{keys: map[string]repository.APIKey{}}
+	srv := NewWalletService(repo, nil, Config{})
+
+	created, _, err := srv.CreateUserAPIKey(context.Background(), 1, "acme", "reports", []string{auth.PermWalletRead, auth.PermRatesRead}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int32(2), created.UserID)
+
+	for _, scope := range []string{auth.PermWalletWrite, auth.PermWalletAdjust, auth.PermUsersAdmin} {
+		_, _, err := srv.CreateUserAPIKey(context.Background(), 1, "acme", "payouts", []string{auth.PermWalletRead, scope}, nil)
+		assert.ErrorIs(t, err, ErrAPIKeyScope, scope)
+	}
+	assert.Len(t, repo.keys, 1)
+}
+
+func TestAPIKey_RevokedOrExpiredKeysAreRejected(t *testing.T) {
+	repo := &apiKeyRepository{keys: map[string]repository.APIKey{}}
+	srv := NewWalletService(repo, nil, Config{})
+
+	created, key, err := srv.CreateAPIKey(context.Background(), 1, "payouts", []string{auth.PermWalletRead}, nil)
+	require.NoError(t, err)
+
+	past := time.Now().Add(-time.Minute)
+	revoked := repo.keys[created.Prefix]
+	revoked.RevokedAt = &past
+	repo.keys[created.Prefix] = revoked
+	_, err = srv.VerifyAPIKey(context.Background(), key)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+
+	expired := repo.keys[created.Prefix]
+	expired.RevokedAt = nil
+	expired.ExpiresAt = &past
+	repo.keys[created.Prefix] = expired
+	_, err = srv.VerifyAPIKey(context.Background(), key)
+	assert.ErrorIs(t, err, ErrInvalidAPIKey)
+}
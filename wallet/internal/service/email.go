@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/mail"
+	"wallet/internal/repository"
+)
+
+// Lifetimes of the single-use tokens sent by email.
+const (
+	verifyEmailTTL   = 24 * time.Hour
+	passwordResetTTL = time.Hour
+)
+
+var (
+	ErrEmailNotVerified     = errors.New("email address is not verified")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+	ErrPasswordRequired     = errors.New("password must not be empty")
+)
+
+// SendVerificationEmail issues a new email verification token for the user and queues the email carrying it.
+// Tokens sent earlier stop working.
+func (s *WalletService) SendVerificationEmail(ctx context.Context, uid int32) error {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	return s.sendVerificationEmail(ctx, user.ID, user.Username, user.Email)
+}
+
+func (s *WalletService) sendVerificationEmail(ctx context.Context, uid int32, username string, email string) error {
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\nplease confirm your email address by opening the link below:\n\n%s\n\n"+
+			"The link is valid for %s. Deposits, withdrawals and exchanges are available once the address is confirmed.\n",
+			username, s.link("/api/v1/email/verify", token), verifyEmailTTL),
+	}
+	return s.repo.CreateUserToken(ctx, uid, repository.TokenPurposeVerifyEmail, hash, time.Now().Add(verifyEmailTTL), msg)
+}
+
+// VerifyEmail consumes an email verification token and marks the address of its user as verified.
+func (s *WalletService) VerifyEmail(ctx context.Context, token string) error {
+	if token == "" {
+		return repository.ErrUserTokenInvalid
+	}
+	return s.repo.VerifyEmail(ctx, auth.HashOpaqueToken(token))
+}
+
+// RequestPasswordReset queues an email with a password reset token for the user registered with the address.
+// Unknown addresses are silently ignored so the endpoint does not reveal which addresses have an account.
+func (s *WalletService) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.repo.GetUserByEmail(ctx, email)
+	if errors.Is(err, repository.ErrUserNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	token, hash, err := auth.NewOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	msg := mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\na password reset was requested for your account. Use the token below to choose a new password:\n\n%s\n\n"+
+			"The token is valid for %s. If you did not request a reset, you can ignore this email.\n",
+			user.Username, token, passwordResetTTL),
+	}
+	return s.repo.CreateUserToken(ctx, user.ID, repository.TokenPurposePasswordReset, hash, time.Now().Add(passwordResetTTL), msg)
+}
+
+// ResetPassword consumes a password reset token and sets the new password. All sessions of the user are revoked.
+func (s *WalletService) ResetPassword(ctx context.Context, token string, password string) error {
+	if token == "" {
+		return repository.ErrUserTokenInvalid
+	}
+	if strings.TrimSpace(password) == "" {
+		return ErrPasswordRequired
+	}
+	return s.repo.ResetPassword(ctx, auth.HashOpaqueToken(token), password)
+}
+
+// requireVerifiedEmail returns ErrEmailNotVerified unless the user confirmed the email address.
+func (s *WalletService) requireVerifiedEmail(ctx context.Context, uid int32) error {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if !user.EmailVerified {
+		return ErrEmailNotVerified
+	}
+	return nil
+}
+
+// link returns a link to the API path carrying the token, absolute when a public URL is configured.
+func (s *WalletService) link(path string, token string) string {
+	return strings.TrimRight(s.cfg.PublicURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
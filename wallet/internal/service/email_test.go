@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/mail"
+	"wallet/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mailRepository records the tokens and emails created by the service.
+type mailRepository struct {
+	fakeRepository
+
+	tokenHash string
+	purpose   string
+	emails    []mail.Message
+}
+
+func (f *mailRepository) GetUserByEmail(ctx context.Context, email string) (repository.User, error) {
+	if email != "alice@example.com" {
+		return repository.User{}, repository.ErrUserNotFound
+	}
+	return repository.User{ID: 1, Username: "alice", Email: email}, nil
+}
+
+func (f *mailRepository) CreateUserToken(ctx context.Context, uid int32, purpose string, tokenHash string, expiresAt time.Time, email mail.Message) error {
+	f.purpose = purpose
+	f.tokenHash = tokenHash
+	f.emails = append(f.emails, email)
+	return nil
+}
+
+func TestWithdraw_RequiresVerifiedEmail(t *testing.T) {
+	srv := NewWalletService(&fakeRepository{unverified: true}, nil, Config{})
+
+	err := srv.Withdraw(context.Background(), 1, 100, "USD")
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+
+	_, err = srv.Exchange(context.Background(), 1, "USD", "EUR", 100, Slippage{})
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+}
+
+func TestRequestPasswordReset_SendsTokenByEmail(t *testing.T) {
+	repo := &mailRepository{}
+	srv := NewWalletService(repo, nil, Config{})
+
+	require.NoError(t, srv.RequestPasswordReset(context.Background(), "alice@example.com"))
+	require.Len(t, repo.emails, 1)
+	assert.Equal(t, repository.TokenPurposePasswordReset, repo.purpose)
+	assert.Equal(t, "alice@example.com", repo.emails[0].To)
+	// Only the hash is stored, the token itself is only in the email
+	assert.NotContains(t, repo.emails[0].Body, repo.tokenHash)
+}
+
+func TestRequestPasswordReset_UnknownEmailIsIgnored(t *testing.T) {
+	repo := &mailRepository{}
+	srv := NewWalletService(repo, nil, Config{})
+
+	assert.NoError(t, srv.RequestPasswordReset(context.Background(), "nobody@example.com"))
+	assert.Empty(t, repo.emails)
+}
+
+func TestSendVerificationEmail_LinksToVerifyEndpoint(t *testing.T) {
+	repo := &mailRepository{fakeRepository: fakeRepository{unverified: true}}
+	srv := NewWalletService(repo, nil, Config{PublicURL: "https://wallet.example.com/"})
+
+	require.NoError(t, srv.SendVerificationEmail(context.Background(), 1))
+	require.Len(t, repo.emails, 1)
+	assert.Equal(t, repository.TokenPurposeVerifyEmail, repo.purpose)
+
+	// The link carries the token whose hash the repository stored
+	prefix := "https://wallet.example.com/api/v1/email/verify?token="
+	i := strings.Index(repo.emails[0].Body, prefix)
+	require.GreaterOrEqual(t, i, 0)
+	token := strings.Fields(repo.emails[0].Body[i+len(prefix):])[0]
+	assert.Equal(t, repo.tokenHash, auth.HashOpaqueToken(token))
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+	"wallet/internal/limits"
+	"wallet/internal/metrics"
+	"wallet/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var (
+	ErrSameCurrency     = errors.New("currencies must differ")
+	ErrAmountTooSmall   = errors.New("amount is too small to exchange")
+	ErrAmountTooLarge   = errors.New("amount is too large to exchange")
+	ErrInvalidTolerance = errors.New("tolerance must be between 0 and 1")
+)
+
+// Slippage describes how far the live exchange rate may move against the user before an exchange is refused.
+// ExpectedRate is the rate the user saw when deciding to exchange and Tolerance is the accepted relative
+// deviation from it (0.01 means 1%). MinToAmount is an absolute floor for the net credited target amount.
+// Zero values disable the corresponding check.
+type Slippage struct {
+	ExpectedRate float32
+	Tolerance    float64
+	MinToAmount  int32
+}
+
+// Fee is a single fee charged on an exchange, in units of the target currency.
+type Fee struct {
+	Name   string
+	Amount int32
+}
+
+// Quote is the full calculation of an exchange. It is produced by the same code for previews and for
+// executed exchanges, so a preview always shows what an exchange at the same rate would do. RateTimestamp is
+// when the exchanger last updated the rate, zero when it did not report it.
+type Quote struct {
+	FromCurrency    string
+	ToCurrency      string
+	FromAmount      int32
+	Rate            float32
+	RateTimestamp   time.Time
+	GrossToAmount   int32
+	Fees            []Fee
+	TotalFees       int32
+	NetToAmount     int32
+	SufficientFunds bool
+}
+
+// PreviewExchange calculates what exchanging amount would yield at the live rate without moving any money,
+// and reports whether the user currently holds enough of the source currency.
+func (s *WalletService) PreviewExchange(ctx context.Context, username string, from string, to string, amount int32) (_ Quote, err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.PreviewExchange", attribute.String("from", from), attribute.String("to", to))
+	defer func() { tracing.End(span, err) }()
+
+	quote, err := s.quote(ctx, from, to, amount)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	balances, err := s.repo.GetBalance(ctx, username)
+	if err != nil {
+		return Quote{}, err
+	}
+	quote.SufficientFunds = balances[from] >= amount
+
+	return quote, nil
+}
+
+// Exchange converts amount from one currency to another at the live rate. The exchange is refused with
+// ErrSlippageExceeded if the rate moved beyond the limits in slippage; otherwise the source amount is
+// withdrawn, within the outflow caps of the user, and the net target amount deposited in a single
+// transaction.
+func (s *WalletService) Exchange(ctx context.Context, uid int32, from string, to string, amount int32, slippage Slippage) (_ Quote, err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.Exchange", attribute.String("from", from), attribute.String("to", to))
+	defer func() { tracing.End(span, err) }()
+
+	if slippage.Tolerance < 0 || slippage.Tolerance >= 1 {
+		return Quote{}, ErrInvalidTolerance
+	}
+	if err := s.requireVerifiedEmail(ctx, uid); err != nil {
+		return Quote{}, err
+	}
+
+	quote, err := s.quote(ctx, from, to, amount)
+	if err != nil {
+		return Quote{}, err
+	}
+	if err := slippage.check(quote); err != nil {
+		return Quote{}, err
+	}
+
+	if err := s.repo.ExchangeBalance(ctx, uid, from, amount, to, quote.NetToAmount, s.outflow(limits.OperationExchange, from)); err != nil {
+		return Quote{}, err
+	}
+	quote.SufficientFunds = true
+	metrics.RecordOperation(metrics.OperationExchange, from, amount)
+
+	return quote, nil
+}
+
+// quote fetches the live rate and calculates the gross amount, fees and net amount of an exchange.
+func (s *WalletService) quote(ctx context.Context, from string, to string, amount int32) (Quote, error) {
+	if amount <= 0 {
+		return Quote{}, ErrInvalidAmount
+	}
+	if from == to {
+		return Quote{}, ErrSameCurrency
+	}
+
+	rate, err := s.repo.GetExchangeRate(ctx, from, to)
+	if err != nil {
+		return Quote{}, err
+	}
+
+	gross, err := toAmount(math.Round(float64(amount) * float64(rate.Rate)))
+	if err != nil {
+		return Quote{}, err
+	}
+	quote := Quote{
+		FromCurrency:  from,
+		ToCurrency:    to,
+		FromAmount:    amount,
+		Rate:          rate.Rate,
+		RateTimestamp: rate.UpdatedAt,
+		GrossToAmount: gross,
+		Fees:          []Fee{},
+	}
+
+	if s.cfg.ExchangeFeeBps > 0 {
+		fee, err := toAmount(math.Ceil(float64(quote.GrossToAmount) * float64(s.cfg.ExchangeFeeBps) / 10000))
+		if err != nil {
+			return Quote{}, err
+		}
+		quote.Fees = append(quote.Fees, Fee{Name: "exchange_fee", Amount: fee})
+	}
+	for _, fee := range quote.Fees {
+		quote.TotalFees += fee.Amount
+	}
+	quote.NetToAmount = quote.GrossToAmount - quote.TotalFees
+
+	if quote.NetToAmount <= 0 {
+		return Quote{}, ErrAmountTooSmall
+	}
+	return quote, nil
+}
+
+// toAmount converts a calculated amount to minor units, refusing with ErrAmountTooLarge what does not fit.
+func toAmount(v float64) (int32, error) {
+	if !(v <= math.MaxInt32) {
+		return 0, ErrAmountTooLarge
+	}
+	return int32(v), nil
+}
+
+// check verifies the quoted rate and net target amount against the slippage limits.
+func (sl Slippage) check(quote Quote) error {
+	if sl.ExpectedRate > 0 {
+		minRate := float64(sl.ExpectedRate) * (1 - sl.Tolerance)
+		if float64(quote.Rate) < minRate {
+			return fmt.Errorf("%w: live rate %g is below expected rate %g with tolerance %g",
+				ErrSlippageExceeded, quote.Rate, sl.ExpectedRate, sl.Tolerance)
+		}
+	}
+	if sl.MinToAmount > 0 && quote.NetToAmount < sl.MinToAmount {
+		return fmt.Errorf("%w: target amount %d is below minimum %d at live rate %g",
+			ErrSlippageExceeded, quote.NetToAmount, sl.MinToAmount, quote.Rate)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/limits"
+	"wallet/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeRepository overrides the repository methods used by the tests; calling any other method panics.
+type fakeRepository struct {
+	repository.WalletRepositoryInterface
+
+	rate      float32
+	rateAt    time.Time
+	balances  map[string]int32
+	exchanged []int32
+	outflow   *limits.Outflow
+	revoked   bool
+	// unverified makes GetUserByID return a user whose email address is not verified.
+	unverified bool
+}
+
+func (f *fakeRepository) GetUserByID(ctx context.Context, uid int32) (repository.User, error) {
+	return repository.User{ID: uid, Username: "alice", Email: "alice@example.com", Roles: []string{auth.RoleUser}, EmailVerified: !f.unverified}, nil
+}
+
+func (f *fakeRepository) IsSessionActive(ctx context.Context, sessionID string) (bool, error) {
+	return !f.revoked, nil
+}
+
+func (f *fakeRepository) GetBalance(ctx context.Context, username string) (map[string]int32, error) {
+	return f.balances, nil
+}
+
+func (f *fakeRepository) GetExchangeRate(ctx context.Context, from string, to string) (repository.ExchangeRate, error) {
+	return repository.ExchangeRate{Rate: f.rate, UpdatedAt: f.rateAt}, nil
+}
+
+func (f *fakeRepository) ExchangeBalance(ctx context.Context, uid int32, from string, fromAmount int32, to string, toAmount int32, outflow *limits.Outflow) error {
+	f.exchanged = []int32{fromAmount, toAmount}
+	f.outflow = outflow
+	return nil
+}
+
+func TestExchange_WithinTolerance(t *testing.T) {
+	repo := &fakeRepository{rate: 0.84}
+	srv := NewWalletService(repo, nil, Config{})
+
+	res, err := srv.Exchange(context.Background(), 1, "USD", "EUR", 10000, Slippage{ExpectedRate: 0.85, Tolerance: 0.02})
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8400), res.NetToAmount)
+	assert.Equal(t, []int32{10000, 8400}, repo.exchanged)
+}
+
+func TestExchange_RateMovedBeyondTolerance(t *testing.T) {
+	repo := &fakeRepository{rate: 0.80}
+	srv := NewWalletService(repo, nil, Config{})
+
+	_, err := srv.Exchange(context.Background(), 1, "USD", "EUR", 10000, Slippage{ExpectedRate: 0.85, Tolerance: 0.01})
+
+	assert.True(t, errors.Is(err, ErrSlippageExceeded))
+	assert.Nil(t, repo.exchanged)
+}
+
+func TestExchange_BelowMinimumTargetAmount(t *testing.T) {
+	repo := &fakeRepository{rate: 0.85}
+	srv := NewWalletService(repo, nil, Config{})
+
+	_, err := srv.Exchange(context.Background(), 1, "USD", "EUR", 10000, Slippage{MinToAmount: 9000})
+
+	assert.True(t, errors.Is(err, ErrSlippageExceeded))
+	assert.Nil(t, repo.exchanged)
+}
+
+func TestExchange_AmountTooLarge(t *testing.T) {
+	repo := &fakeRepository{rate: 100}
+	srv := NewWalletService(repo, nil, Config{ExchangeFeeBps: 100})
+
+	// 1,000,000.00 at a rate of 100 does not fit into the int32 balance in minor units
+	_, err := srv.Exchange(context.Background(), 1, "USD", "RUB", 100000000, Slippage{})
+
+	assert.ErrorIs(t, err, ErrAmountTooLarge)
+	assert.Nil(t, repo.exchanged)
+}
+
+func TestPreviewExchange_FeeBreakdown(t *testing.T) {
+	rateAt := time.Date(2024, 5, 10, 11, 59, 0, 0, time.UTC)
+	repo := &fakeRepository{rate: 0.85, rateAt: rateAt, balances: map[string]int32{"USD": 5000}}
+	srv := NewWalletService(repo, nil, Config{ExchangeFeeBps: 100})
+
+	quote, err := srv.PreviewExchange(context.Background(), "user", "USD", "EUR", 10000)
+
+	assert.NoError(t, err)
+	assert.Equal(t, int32(8500), quote.GrossToAmount)
+	assert.Equal(t, rateAt, quote.RateTimestamp)
+	assert.Equal(t, []Fee{{Name: "exchange_fee", Amount: 85}}, quote.Fees)
+	assert.Equal(t, int32(8415), quote.NetToAmount)
+	assert.False(t, quote.SufficientFunds)
+	assert.Nil(t, repo.exchanged)
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+	"wallet/internal/limits"
+)
+
+// ErrInvalidLimit is returned for a limit override of an unknown operation or with a negative cap.
+var ErrInvalidLimit = errors.New("invalid limit")
+
+// outflow describes money leaving the wallet through operation, capped by the configured defaults unless
+// the user has an override.
+func (s *WalletService) outflow(operation string, currency string) *limits.Outflow {
+	return &limits.Outflow{Operation: operation, Defaults: s.cfg.Limits.Cap(operation, currency)}
+}
+
+// Allowances returns what is left of the outflow caps of the user for every capped operation in every
+// currency of the wallet, sorted by operation and currency.
+func (s *WalletService) Allowances(ctx context.Context, uid int32, username string) ([]limits.Allowance, error) {
+	balances, err := s.repo.GetBalance(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := s.repo.GetLimitOverrides(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	usage, err := s.repo.GetOutflowUsage(ctx, uid, now)
+	if err != nil {
+		return nil, err
+	}
+
+	currencies := make([]string, 0, len(balances))
+	for currency := range balances {
+		currencies = append(currencies, currency)
+	}
+	sort.Strings(currencies)
+
+	allowances := make([]limits.Allowance, 0, len(limits.Operations)*len(currencies))
+	for _, operation := range limits.Operations {
+		for _, currency := range currencies {
+			key := limits.Key{Operation: operation, Currency: currency}
+			c, ok := overrides[key]
+			if !ok {
+				c = s.cfg.Limits.Cap(operation, currency)
+			}
+			allowances = append(allowances, limits.NewAllowance(key, c, usage[key], now))
+		}
+	}
+	return allowances, nil
+}
+
+// SetUserLimit replaces the default caps of the operation in the currency for a user.
+func (s *WalletService) SetUserLimit(ctx context.Context, username string, key limits.Key, c limits.Cap) error {
+	if !limits.IsOperation(key.Operation) {
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidLimit, key.Operation)
+	}
+	if !c.Valid() {
+		return fmt.Errorf("%w: caps must not be negative", ErrInvalidLimit)
+	}
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	return s.repo.SetLimitOverride(ctx, user.ID, key, c)
+}
+
+// ResetUserLimit restores the default caps of the operation in the currency for a user.
+func (s *WalletService) ResetUserLimit(ctx context.Context, username string, key limits.Key) error {
+	if !limits.IsOperation(key.Operation) {
+		return fmt.Errorf("%w: unknown operation %q", ErrInvalidLimit, key.Operation)
+	}
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	return s.repo.DeleteLimitOverride(ctx, user.ID, key)
+}
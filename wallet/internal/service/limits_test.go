@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wallet/internal/limits"
+	"wallet/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// limitsRepository keeps limit overrides and outflow usage in memory.
+type limitsRepository struct {
+	fakeRepository
+
+	overrides map[limits.Key]limits.Cap
+	usage     map[limits.Key]limits.Usage
+	withdrawn *limits.Outflow
+}
+
+func (f *limitsRepository) UpdateBalance(ctx context.Context, uid int32, amount int32, currency string, outflow *limits.Outflow) error {
+	f.withdrawn = outflow
+	return nil
+}
+
+func (f *limitsRepository) GetUserByUsername(ctx context.Context, username string) (repository.User, error) {
+	if username != "alice" {
+		return repository.User{}, repository.ErrUserNotFound
+	}
+	return repository.User{ID: 1, Username: username}, nil
+}
+
+func (f *limitsRepository) GetLimitOverrides(ctx context.Context, uid int32) (map[limits.Key]limits.Cap, error) {
+	return f.overrides, nil
+}
+
+func (f *limitsRepository) GetOutflowUsage(ctx context.Context, uid int32, now time.Time) (map[limits.Key]limits.Usage, error) {
+	return f.usage, nil
+}
+
+func (f *limitsRepository) SetLimitOverride(ctx context.Context, uid int32, key limits.Key, c limits.Cap) error {
+	f.overrides[key] = c
+	return nil
+}
+
+var testPolicy = limits.Policy{
+	limits.OperationWithdraw: {"USD": {Daily: 1000, Monthly: 10000}},
+	limits.OperationExchange: {"USD": {Daily: 2000, Monthly: limits.Unlimited}},
+}
+
+func TestWithdrawAndExchange_PassDefaultCaps(t *testing.T) {
+	repo := &limitsRepository{fakeRepository: fakeRepository{rate: 0.9}}
+	srv := NewWalletService(repo, nil, Config{Limits: testPolicy})
+
+	require.NoError(t, srv.Withdraw(context.Background(), 1, 500, "USD"))
+	assert.Equal(t, &limits.Outflow{Operation: limits.OperationWithdraw, Defaults: limits.Cap{Daily: 1000, Monthly: 10000}}, repo.withdrawn)
+
+	require.NoError(t, srv.Withdraw(context.Background(), 1, 500, "EUR"))
+	assert.Equal(t, limits.NoCap, repo.withdrawn.Defaults)
+
+	_, err := srv.Exchange(context.Background(), 1, "USD", "EUR", 500, Slippage{})
+	require.NoError(t, err)
+	assert.Equal(t, &limits.Outflow{Operation: limits.OperationExchange, Defaults: limits.Cap{Daily: 2000, Monthly: limits.Unlimited}}, repo.outflow)
+}
+
+func TestAllowances(t *testing.T) {
+	repo := &limitsRepository{
+		fakeRepository: fakeRepository{balances: map[string]int32{"USD": 0, "EUR": 0}},
+		overrides:      map[limits.Key]limits.Cap{{Operation: limits.OperationWithdraw, Currency: "EUR"}: {Daily: 300, Monthly: limits.Unlimited}},
+		usage:          map[limits.Key]limits.Usage{{Operation: limits.OperationWithdraw, Currency: "USD"}: {Daily: 400, Monthly: 4000}},
+	}
+	srv := NewWalletService(repo, nil, Config{Limits: testPolicy})
+
+	allowances, err := srv.Allowances(context.Background(), 1, "alice")
+	require.NoError(t, err)
+
+	require.Len(t, allowances, len(limits.Operations)*2)
+	eur, usd := allowances[0], allowances[1]
+	assert.Equal(t, "EUR", eur.Currency)
+	assert.Equal(t, int64(300), eur.Daily.Remaining)
+	assert.Equal(t, limits.Unlimited, eur.Monthly.Remaining)
+	assert.Equal(t, limits.OperationWithdraw, usd.Operation)
+	assert.Equal(t, int64(600), usd.Daily.Remaining)
+	assert.Equal(t, int64(6000), usd.Monthly.Remaining)
+	assert.Equal(t, limits.OperationTransfer, allowances[5].Operation)
+	assert.Equal(t, limits.Unlimited, allowances[5].Daily.Limit)
+}
+
+func TestSetUserLimit(t *testing.T) {
+	repo := &limitsRepository{overrides: map[limits.Key]limits.Cap{}}
+	srv := NewWalletService(repo, nil, Config{})
+	key := limits.Key{Operation: limits.OperationTransfer, Currency: "USD"}
+
+	require.NoError(t, srv.SetUserLimit(context.Background(), "alice", key, limits.Cap{Daily: 0, Monthly: limits.Unlimited}))
+	assert.Equal(t, limits.Cap{Daily: 0, Monthly: limits.Unlimited}, repo.overrides[key])
+
+	err := srv.SetUserLimit(context.Background(), "alice", limits.Key{Operation: "deposit", Currency: "USD"}, limits.NoCap)
+	assert.True(t, errors.Is(err, ErrInvalidLimit))
+	err = srv.SetUserLimit(context.Background(), "alice", key, limits.Cap{Daily: -5, Monthly: 0})
+	assert.True(t, errors.Is(err, ErrInvalidLimit))
+	err = srv.SetUserLimit(context.Background(), "bob", key, limits.NoCap)
+	assert.True(t, errors.Is(err, repository.ErrUserNotFound))
+}
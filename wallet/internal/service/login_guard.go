@@ -0,0 +1,174 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"wallet/internal/repository"
+)
+
+// ClientInfo describes the client a request came from. DeviceName is an optional name the client gives
+// itself at login, shown in the list of sessions.
+type ClientInfo struct {
+	IP         string
+	UserAgent  string
+	DeviceName string
+}
+
+// ThrottlePolicy defines how failed login attempts for one key are slowed down. The first FreeAttempts
+// failures are not delayed, then every further failure doubles the delay starting at BaseDelay up to
+// MaxDelay. From LockoutAfter failures on the key is locked for LockoutDuration.
+type ThrottlePolicy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+// LoginThrottleConfig holds the brute-force protection settings. Failures older than Window are forgotten.
+type LoginThrottleConfig struct {
+	PerUsername ThrottlePolicy
+	PerIP       ThrottlePolicy
+	Window      time.Duration
+}
+
+// DefaultLoginThrottle is lenient enough for mistyped passwords and NATed offices while making
+// password guessing impractical.
+var DefaultLoginThrottle = LoginThrottleConfig{
+	PerUsername: ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute, LockoutAfter: 10, LockoutDuration: 15 * time.Minute},
+	PerIP:       ThrottlePolicy{FreeAttempts: 20, BaseDelay: time.Second, MaxDelay: time.Minute, LockoutAfter: 100, LockoutDuration: time.Hour},
+	Window:      time.Hour,
+}
+
+// Reasons recorded in the login history.
+const (
+	loginReasonOK                  = "ok"
+	loginReasonInvalidCredentials  = "invalid_credentials"
+	loginReasonInvalidSecondFactor = "invalid_second_factor"
+	loginReasonThrottled           = "throttled"
+	loginReasonChallenge           = "two_factor_required"
+)
+
+// ErrLoginThrottled is returned, wrapped in a LoginThrottledError, while login attempts are blocked.
+var ErrLoginThrottled = errors.New("too many failed login attempts")
+
+// LoginThrottledError tells how long the client has to wait before the next login attempt.
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return fmt.Sprintf("%s, retry in %s", ErrLoginThrottled, e.RetryAfter.Round(time.Second))
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrLoginThrottled
+}
+
+// delay returns how long after the last failure the next attempt is blocked.
+func (p ThrottlePolicy) delay(failures int) time.Duration {
+	if p.LockoutAfter > 0 && failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+	if failures < p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	return delay
+}
+
+func usernameThrottleKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipThrottleKey(ip string) string {
+	return "ip:" + ip
+}
+
+// throttleKeys returns the policy of every key the attempt is counted against: the username and the client IP.
+func (s *WalletService) throttleKeys(username string, client ClientInfo) map[string]ThrottlePolicy {
+	policies := map[string]ThrottlePolicy{usernameThrottleKey(username): s.cfg.LoginThrottle.PerUsername}
+	if client.IP != "" {
+		policies[ipThrottleKey(client.IP)] = s.cfg.LoginThrottle.PerIP
+	}
+	return policies
+}
+
+// reserveLoginAttempt counts the attempt as failed for the username and the client IP before the credentials
+// are checked, so that concurrent guesses cannot all pass the throttle. It returns a LoginThrottledError,
+// without counting the attempt, while attempts for either key are blocked. Attempts that turn out not to
+// fail are taken back with releaseLoginAttempt.
+func (s *WalletService) reserveLoginAttempt(ctx context.Context, username string, client ClientInfo) error {
+	policies := s.throttleKeys(username, client)
+	keys := make([]string, 0, len(policies))
+	for key := range policies {
+		keys = append(keys, key)
+	}
+
+	now := time.Now()
+	return s.repo.ReserveLoginAttempt(ctx, keys, now, s.cfg.LoginThrottle.Window, func(throttles map[string]repository.LoginThrottle) error {
+		var retryAfter time.Duration
+		for key, throttle := range throttles {
+			if now.Sub(throttle.LastFailureAt) > s.cfg.LoginThrottle.Window {
+				continue
+			}
+			if wait := throttle.LastFailureAt.Add(policies[key].delay(throttle.Failures)).Sub(now); wait > retryAfter {
+				retryAfter = wait
+			}
+		}
+		if retryAfter > 0 {
+			return &LoginThrottledError{RetryAfter: retryAfter}
+		}
+		return nil
+	})
+}
+
+// releaseLoginAttempt takes back the attempt reserved for the username and the client IP, because the
+// credentials were right or could not be checked.
+func (s *WalletService) releaseLoginAttempt(ctx context.Context, username string, client ClientInfo) {
+	for key := range s.throttleKeys(username, client) {
+		if err := s.repo.ReleaseLoginAttempt(ctx, key); err != nil {
+			slog.ErrorContext(ctx, "Error releasing login attempt", slog.Any("error", err))
+		}
+	}
+}
+
+// recordLoginSuccess forgets the failed attempts of the username. The counter of the client IP is kept,
+// so that an attacker cannot reset it by logging into an account of their own.
+func (s *WalletService) recordLoginSuccess(ctx context.Context, username string) {
+	if err := s.repo.ResetLoginThrottle(ctx, usernameThrottleKey(username)); err != nil {
+		slog.ErrorContext(ctx, "Error resetting failed login attempts", slog.Any("error", err))
+	}
+}
+
+// recordLoginEvent adds the attempt to the login history of the account.
+func (s *WalletService) recordLoginEvent(ctx context.Context, username string, client ClientInfo, reason string) {
+	event := repository.LoginEvent{
+		Username:  username,
+		IP:        client.IP,
+		UserAgent: client.UserAgent,
+		Success:   reason == loginReasonOK,
+		Reason:    reason,
+	}
+	if err := s.repo.RecordLoginEvent(ctx, event); err != nil {
+		slog.ErrorContext(ctx, "Error recording login event", slog.Any("error", err))
+	}
+}
+
+// LoginHistory returns the most recent login attempts on the account of the user.
+func (s *WalletService) LoginHistory(ctx context.Context, uid int32, limit int) ([]repository.LoginEvent, error) {
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+	return s.repo.ListLoginEvents(ctx, uid, limit)
+}
@@ -0,0 +1,176 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// loginRepository keeps login throttles and events in memory and accepts only the password "secret".
+type loginRepository struct {
+	fakeRepository
+
+	throttles map[string]repository.LoginThrottle
+	events    []repository.LoginEvent
+}
+
+func (f *loginRepository) Login(ctx context.Context, username, password string) (repository.User, error) {
+	if password != "secret" {
+		return repository.User{}, repository.ErrInvalidCredentials
+	}
+	return repository.User{ID: 1, Username: username, TOTPEnabled: true}, nil
+}
+
+func (f *loginRepository) ReserveLoginAttempt(ctx context.Context, keys []string, now time.Time, window time.Duration, allow func(map[string]repository.LoginThrottle) error) error {
+	throttles := make(map[string]repository.LoginThrottle)
+	for _, key := range keys {
+		if throttle, ok := f.throttles[key]; ok {
+			throttles[key] = throttle
+		}
+	}
+	if err := allow(throttles); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		throttle := f.throttles[key]
+		throttle.Failures++
+		throttle.LastFailureAt = now
+		f.throttles[key] = throttle
+	}
+	return nil
+}
+
+func (f *loginRepository) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	if throttle, ok := f.throttles[key]; ok && throttle.Failures > 0 {
+		throttle.Failures--
+		f.throttles[key] = throttle
+	}
+	return nil
+}
+
+func (f *loginRepository) ResetLoginThrottle(ctx context.Context, key string) error {
+	delete(f.throttles, key)
+	return nil
+}
+
+func (f *loginRepository) RecordLoginEvent(ctx context.Context, event repository.LoginEvent) error {
+	f.events = append(f.events, event)
+	return nil
+}
+
+func TestThrottlePolicy_Delay(t *testing.T) {
+	p := ThrottlePolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxDelay: 10 * time.Second, LockoutAfter: 10, LockoutDuration: time.Hour}
+
+	for failures, want := range map[int]time.Duration{
+		0:  0,
+		2:  0,
+		3:  time.Second,
+		4:  2 * time.Second,
+		5:  4 * time.Second,
+		9:  10 * time.Second,
+		10: time.Hour,
+	} {
+		assert.Equal(t, want, p.delay(failures), "failures=%d", failures)
+	}
+}
+
+func TestLogin_ThrottlesRepeatedFailures(t *testing.T) {
+	repo := &loginRepository{throttles: map[string]repository.LoginThrottle{}}
+	srv := NewWalletService(repo, nil, Config{LoginThrottle: DefaultLoginThrottle})
+	client := ClientInfo{IP: "203.0.113.7", UserAgent: "test"}
+
+	for i := 0; i < DefaultLoginThrottle.PerUsername.FreeAttempts; i++ {
+		_, err := srv.Login(context.Background(), "alice", "wrong", client)
+		require.ErrorIs(t, err, repository.ErrInvalidCredentials)
+	}
+
+	// Even the right password is refused while the username is blocked
+	_, err := srv.Login(context.Background(), "Alice", "secret", client)
+	var throttled *LoginThrottledError
+	require.True(t, errors.As(err, &throttled))
+	assert.Greater(t, throttled.RetryAfter, time.Duration(0))
+
+	require.Len(t, repo.events, 4)
+	assert.Equal(t, loginReasonInvalidCredentials, repo.events[0].Reason)
+	assert.Equal(t, loginReasonThrottled, repo.events[3].Reason)
+	assert.Equal(t, "203.0.113.7", repo.events[3].IP)
+	assert.False(t, repo.events[3].Success)
+}
+
+func TestLogin_CountsOnlyFailedAttempts(t *testing.T) {
+	repo := &loginRepository{throttles: map[string]repository.LoginThrottle{}}
+	srv := NewWalletService(repo, newTestTokens(t), Config{LoginThrottle: DefaultLoginThrottle})
+	client := ClientInfo{IP: "203.0.113.7", UserAgent: "test"}
+
+	_, err := srv.Login(context.Background(), "alice", "wrong", client)
+	require.ErrorIs(t, err, repository.ErrInvalidCredentials)
+	assert.Equal(t, 1, repo.throttles["user:alice"].Failures)
+	assert.Equal(t, 1, repo.throttles["ip:203.0.113.7"].Failures)
+
+	// The attempt reserved before the password check is taken back once the password is right
+	_, err = srv.Login(context.Background(), "alice", "secret", client)
+	require.NoError(t, err)
+	assert.Equal(t, 1, repo.throttles["user:alice"].Failures, "the username is reset only after the second factor")
+	assert.Equal(t, 1, repo.throttles["ip:203.0.113.7"].Failures)
+
+	// Blocked attempts are refused without being counted
+	repo.throttles["user:alice"] = repository.LoginThrottle{Failures: DefaultLoginThrottle.PerUsername.LockoutAfter, LastFailureAt: time.Now()}
+	_, err = srv.Login(context.Background(), "alice", "wrong", client)
+	require.ErrorIs(t, err, ErrLoginThrottled)
+	assert.Equal(t, DefaultLoginThrottle.PerUsername.LockoutAfter, repo.throttles["user:alice"].Failures)
+	assert.Equal(t, 1, repo.throttles["ip:203.0.113.7"].Failures)
+}
+
+// twoFactorRepository has alice enrolled in two-factor authentication with the recovery code "good-code".
+type twoFactorRepository struct {
+	loginRepository
+
+	disabled bool
+}
+
+func (f *twoFactorRepository) GetUserByID(ctx context.Context, uid int32) (repository.User, error) {
+	return repository.User{ID: uid, Username: "alice", TOTPEnabled: true}, nil
+}
+
+func (f *twoFactorRepository) UseRecoveryCode(ctx context.Context, uid int32, codeHash string) (bool, error) {
+	return codeHash == auth.HashRecoveryCode("good-code"), nil
+}
+
+func (f *twoFactorRepository) DisableTOTP(ctx context.Context, uid int32) error {
+	f.disabled = true
+	return nil
+}
+
+func TestDisableTOTP_CountsFailedReauthentication(t *testing.T) {
+	repo := &twoFactorRepository{loginRepository: loginRepository{throttles: map[string]repository.LoginThrottle{}}}
+	srv := NewWalletService(repo, nil, Config{LoginThrottle: DefaultLoginThrottle})
+	client := ClientInfo{IP: "203.0.113.7", UserAgent: "test"}
+
+	err := srv.DisableTOTP(context.Background(), 1, "wrong", "good-code", client)
+	require.ErrorIs(t, err, repository.ErrInvalidCredentials)
+	err = srv.DisableTOTP(context.Background(), 1, "secret", "bad-code", client)
+	require.ErrorIs(t, err, ErrInvalidTwoFactorCode)
+	assert.Equal(t, 2, repo.throttles["user:alice"].Failures)
+
+	// Once the username is blocked, even the right password and code are refused
+	repo.throttles["user:alice"] = repository.LoginThrottle{Failures: DefaultLoginThrottle.PerUsername.LockoutAfter, LastFailureAt: time.Now()}
+	err = srv.DisableTOTP(context.Background(), 1, "secret", "good-code", client)
+	require.ErrorIs(t, err, ErrLoginThrottled)
+	assert.False(t, repo.disabled)
+
+	require.Len(t, repo.events, 3)
+	assert.Equal(t, loginReasonInvalidCredentials, repo.events[0].Reason)
+	assert.Equal(t, loginReasonInvalidSecondFactor, repo.events[1].Reason)
+	assert.Equal(t, loginReasonThrottled, repo.events[2].Reason)
+
+	delete(repo.throttles, "user:alice")
+	require.NoError(t, srv.DisableTOTP(context.Background(), 1, "secret", "good-code", client))
+	assert.True(t, repo.disabled)
+	assert.Equal(t, 0, repo.throttles["user:alice"].Failures)
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/repository"
+)
+
+// recoveryCodeCount is the number of recovery codes generated when two-factor authentication is confirmed.
+const recoveryCodeCount = 10
+
+// TOTPEnrollment is a freshly generated TOTP secret waiting for confirmation.
+type TOTPEnrollment struct {
+	Secret string
+	URI    string
+}
+
+// EnrollTOTP generates a new TOTP secret for the user. It is not required at login until confirmed.
+func (s *WalletService) EnrollTOTP(ctx context.Context, uid int32) (TOTPEnrollment, error) {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return TOTPEnrollment{}, err
+	}
+	if err := s.repo.SetPendingTOTPSecret(ctx, uid, secret); err != nil {
+		return TOTPEnrollment{}, err
+	}
+
+	issuer := s.cfg.TOTPIssuer
+	if issuer == "" {
+		issuer = "Wallet"
+	}
+	return TOTPEnrollment{Secret: secret, URI: auth.TOTPURI(issuer, user.Username, secret)}, nil
+}
+
+// ConfirmTOTP enables two-factor authentication once the user proves the authenticator app produces valid
+// codes, and returns the recovery codes. They are shown only this once.
+func (s *WalletService) ConfirmTOTP(ctx context.Context, uid int32, code string) ([]string, error) {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	if user.TOTPEnabled {
+		return nil, repository.ErrTwoFactorAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, repository.ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, hashes, err := auth.NewRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.repo.EnableTOTP(ctx, uid, step, hashes); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// CompleteTwoFactorLogin exchanges the challenge token returned by Login and a TOTP or recovery code
+// for a new session. Wrong codes count as failed login attempts of the user.
+func (s *WalletService) CompleteTwoFactorLogin(ctx context.Context, challengeToken string, code string, client ClientInfo) (repository.Token, error) {
+	claims, err := s.tokens.VerifyChallenge(challengeToken)
+	if err != nil {
+		return repository.Token{}, ErrInvalidChallenge
+	}
+
+	if err := s.reserveLoginAttempt(ctx, claims.Username, client); err != nil {
+		if errors.Is(err, ErrLoginThrottled) {
+			s.recordLoginEvent(ctx, claims.Username, client, loginReasonThrottled)
+		}
+		return repository.Token{}, err
+	}
+
+	user, err := s.repo.GetUserByID(ctx, claims.UserID)
+	if err != nil {
+		s.releaseLoginAttempt(ctx, claims.Username, client)
+		return repository.Token{}, err
+	}
+	if err := s.checkSecondFactor(ctx, user, code); errors.Is(err, ErrInvalidTwoFactorCode) {
+		s.recordLoginEvent(ctx, user.Username, client, loginReasonInvalidSecondFactor)
+		return repository.Token{}, err
+	} else if err != nil {
+		s.releaseLoginAttempt(ctx, claims.Username, client)
+		return repository.Token{}, err
+	}
+
+	s.releaseLoginAttempt(ctx, claims.Username, client)
+	s.recordLoginSuccess(ctx, user.Username)
+	s.recordLoginEvent(ctx, user.Username, client, loginReasonOK)
+	return s.startSession(ctx, user, client)
+}
+
+// DisableTOTP turns two-factor authentication off after the user re-authenticates with the password and
+// a current TOTP or recovery code. The re-authentication is throttled like a login, and a wrong password
+// or code counts as a failed login attempt of the user.
+func (s *WalletService) DisableTOTP(ctx context.Context, uid int32, password string, code string, client ClientInfo) error {
+	user, err := s.repo.GetUserByID(ctx, uid)
+	if err != nil {
+		return err
+	}
+	if !user.TOTPEnabled {
+		return repository.ErrTwoFactorNotEnrolled
+	}
+
+	if err := s.reserveLoginAttempt(ctx, user.Username, client); err != nil {
+		if errors.Is(err, ErrLoginThrottled) {
+			s.recordLoginEvent(ctx, user.Username, client, loginReasonThrottled)
+		}
+		return err
+	}
+	if _, err := s.repo.Login(ctx, user.Username, password); errors.Is(err, repository.ErrInvalidCredentials) {
+		s.recordLoginEvent(ctx, user.Username, client, loginReasonInvalidCredentials)
+		return err
+	} else if err != nil {
+		s.releaseLoginAttempt(ctx, user.Username, client)
+		return err
+	}
+	if err := s.checkSecondFactor(ctx, user, code); errors.Is(err, ErrInvalidTwoFactorCode) {
+		s.recordLoginEvent(ctx, user.Username, client, loginReasonInvalidSecondFactor)
+		return err
+	} else if err != nil {
+		s.releaseLoginAttempt(ctx, user.Username, client)
+		return err
+	}
+	s.releaseLoginAttempt(ctx, user.Username, client)
+
+	return s.repo.DisableTOTP(ctx, uid)
+}
+
+// checkSecondFactor accepts either a TOTP code that was not used before or an unused recovery code.
+func (s *WalletService) checkSecondFactor(ctx context.Context, user repository.User, code string) error {
+	if !user.TOTPEnabled {
+		return repository.ErrTwoFactorNotEnrolled
+	}
+
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		fresh, err := s.repo.ConsumeTOTPStep(ctx, user.ID, step)
+		if err != nil {
+			return err
+		}
+		if !fresh {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	used, err := s.repo.UseRecoveryCode(ctx, user.ID, auth.HashRecoveryCode(code))
+	if err != nil {
+		return err
+	}
+	if !used {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+var (
+	ErrInvalidTwoFactorCode = errors.New("invalid two-factor code")
+	ErrInvalidChallenge     = errors.New("invalid or expired two-factor challenge")
+)
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+	"wallet/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// ValuationItem is a single balance converted into the valuation currency. RateTimestamp is when the exchanger
+// last updated the rate, zero for the valuation currency itself and when the exchanger did not report it.
+type ValuationItem struct {
+	Currency      string
+	Balance       int32
+	Rate          float32
+	RateTimestamp time.Time
+	Value         int64
+}
+
+// Valuation is the value of all balances of a user expressed in one currency.
+type Valuation struct {
+	Currency string
+	Items    []ValuationItem
+	Total    int64
+}
+
+// ValueBalances converts every balance of the user into the given currency using the live exchange rates
+// and returns the per-currency values together with their total.
+func (s *WalletService) ValueBalances(ctx context.Context, username string, currency string) (_ Valuation, err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.ValueBalances", attribute.String("currency", currency))
+	defer func() { tracing.End(span, err) }()
+
+	balances, err := s.repo.GetBalance(ctx, username)
+	if err != nil {
+		return Valuation{}, err
+	}
+
+	// Iterate in a stable order so the response does not change between calls.
+	currencies := make([]string, 0, len(balances))
+	for c := range balances {
+		currencies = append(currencies, c)
+	}
+	sort.Strings(currencies)
+
+	valuation := Valuation{Currency: currency, Items: make([]ValuationItem, 0, len(currencies))}
+	for _, c := range currencies {
+		item := ValuationItem{Currency: c, Balance: balances[c], Rate: 1}
+		if c != currency {
+			rate, err := s.repo.GetExchangeRate(ctx, c, currency)
+			if err != nil {
+				return Valuation{}, err
+			}
+			item.Rate = rate.Rate
+			item.RateTimestamp = rate.UpdatedAt
+		}
+		item.Value = int64(math.Round(float64(item.Balance) * float64(item.Rate)))
+
+		valuation.Items = append(valuation.Items, item)
+		valuation.Total += item.Value
+	}
+
+	return valuation, nil
+}
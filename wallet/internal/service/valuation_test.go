@@ -0,0 +1,26 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValueBalances(t *testing.T) {
+	rateAt := time.Date(2024, 5, 10, 11, 59, 0, 0, time.UTC)
+	repo := &fakeRepository{rate: 2, rateAt: rateAt, balances: map[string]int32{"USD": 10000, "EUR": 5000}}
+	srv := NewWalletService(repo, nil, Config{})
+
+	valuation, err := srv.ValueBalances(context.Background(), "user", "USD")
+
+	assert.NoError(t, err)
+	assert.Len(t, valuation.Items, 2)
+	assert.Equal(t, "EUR", valuation.Items[0].Currency)
+	assert.Equal(t, int64(10000), valuation.Items[0].Value)
+	assert.Equal(t, rateAt, valuation.Items[0].RateTimestamp)
+	assert.Equal(t, float32(1), valuation.Items[1].Rate)
+	assert.True(t, valuation.Items[1].RateTimestamp.IsZero())
+	assert.Equal(t, int64(20000), valuation.Total)
+}
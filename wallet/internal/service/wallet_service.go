@@ -3,63 +3,362 @@ package service
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/limits"
+	"wallet/internal/logging"
+	"wallet/internal/metrics"
 	"wallet/internal/repository"
+	"wallet/internal/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type WalletServiceInterface interface {
 	Deposit(ctx context.Context, uid int32, amount int32, currency string) error
 	Withdraw(ctx context.Context, uid int32, amount int32, currency string) error
+	Transfer(ctx context.Context, uid int32, to string, amount int32, currency string) error
 	GetBalance(ctx context.Context, username string) (map[string]int32, error)
+	ValueBalances(ctx context.Context, username string, currency string) (Valuation, error)
 	GetExchangeRates(ctx context.Context) (map[string]float64, error)
 	GetExchangeRate(ctx context.Context, from string, to string) (float32, error)
+	PreviewExchange(ctx context.Context, username string, from string, to string, amount int32) (Quote, error)
+	Exchange(ctx context.Context, uid int32, from string, to string, amount int32, slippage Slippage) (Quote, error)
 	RegisterUser(ctx context.Context, username, email, password string) error
-	Login(ctx context.Context, username, password string) (repository.Token, error)
+	Login(ctx context.Context, username, password string, client ClientInfo) (repository.Token, error)
+	RefreshToken(ctx context.Context, refreshToken string, client ClientInfo) (repository.Token, error)
+	Logout(ctx context.Context, uid int32, sessionID string) error
+	ListSessions(ctx context.Context, uid int32) ([]repository.Session, error)
+	RevokeSession(ctx context.Context, uid int32, sessionID string) error
+	RevokeOtherSessions(ctx context.Context, uid int32, currentSessionID string) (int64, error)
+	VerifyToken(ctx context.Context, token string) (*auth.Claims, error)
+	SetUserRoles(ctx context.Context, username string, roles []string) error
+	AdjustBalance(ctx context.Context, adminID int32, username string, amount int32, currency string, reason string) error
+	EnrollTOTP(ctx context.Context, uid int32) (TOTPEnrollment, error)
+	ConfirmTOTP(ctx context.Context, uid int32, code string) ([]string, error)
+	CompleteTwoFactorLogin(ctx context.Context, challengeToken string, code string, client ClientInfo) (repository.Token, error)
+	LoginHistory(ctx context.Context, uid int32, limit int) ([]repository.LoginEvent, error)
+	DisableTOTP(ctx context.Context, uid int32, password string, code string, client ClientInfo) error
+	SendVerificationEmail(ctx context.Context, uid int32) error
+	VerifyEmail(ctx context.Context, token string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, password string) error
+	CreateAPIKey(ctx context.Context, uid int32, name string, scopes []string, expiresAt *time.Time) (repository.APIKey, string, error)
+	CreateUserAPIKey(ctx context.Context, adminID int32, username string, name string, scopes []string, expiresAt *time.Time) (repository.APIKey, string, error)
+	ListAPIKeys(ctx context.Context, uid int32) ([]repository.APIKey, error)
+	RevokeAPIKey(ctx context.Context, uid int32, id string) error
+	VerifyAPIKey(ctx context.Context, key string) (*auth.Principal, error)
+	Allowances(ctx context.Context, uid int32, username string) ([]limits.Allowance, error)
+	SetUserLimit(ctx context.Context, username string, key limits.Key, c limits.Cap) error
+	ResetUserLimit(ctx context.Context, username string, key limits.Key) error
+	JWKS() auth.JWKS
+}
+
+// Config holds the business settings of the wallet service.
+type Config struct {
+	// ExchangeFeeBps is the exchange fee in basis points of the gross target amount (100 = 1%).
+	ExchangeFeeBps int32
+	// TOTPIssuer is the account issuer shown in authenticator apps.
+	TOTPIssuer string
+	// PublicURL is the externally reachable base URL of the service, used for links in emails.
+	PublicURL string
+	// LoginThrottle slows down repeated failed logins.
+	LoginThrottle LoginThrottleConfig
+	// Limits are the default caps of the outflows of every user.
+	Limits limits.Policy
 }
 
 type WalletService struct {
-	repo repository.WalletRepositoryInterface
+	repo   repository.WalletRepositoryInterface
+	tokens *auth.Manager
+	cfg    Config
+}
+
+func NewWalletService(repo repository.WalletRepositoryInterface, tokens *auth.Manager, cfg Config) *WalletService {
+	return &WalletService{repo: repo, tokens: tokens, cfg: cfg}
 }
 
-func NewWalletService(repo repository.WalletRepositoryInterface) *WalletService {
-	return &WalletService{repo: repo}
+func (s *WalletService) Deposit(ctx context.Context, uid int32, amount int32, currency string) (err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.Deposit", attribute.String("currency", currency))
+	defer func() { tracing.End(span, err) }()
+
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if err := s.requireVerifiedEmail(ctx, uid); err != nil {
+		return err
+	}
+	if err := s.repo.UpdateBalance(ctx, uid, amount, currency, nil); err != nil {
+		return err
+	}
+	metrics.RecordOperation(metrics.OperationDeposit, currency, amount)
+	return nil
 }
 
-func (s *WalletService) Deposit(ctx context.Context, uid int32, amount int32, currency string) error {
+func (s *WalletService) Withdraw(ctx context.Context, uid int32, amount int32, currency string) (err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.Withdraw", attribute.String("currency", currency))
+	defer func() { tracing.End(span, err) }()
+
 	if amount <= 0 {
-		return errors.New("amount must be greater than zero")
+		return ErrInvalidAmount
+	}
+	if err := s.requireVerifiedEmail(ctx, uid); err != nil {
+		return err
 	}
-	return s.repo.UpdateBalance(ctx, uid, amount, currency)
+
+	if err := s.repo.UpdateBalance(ctx, uid, -amount, currency, s.outflow(limits.OperationWithdraw, currency)); err != nil {
+		return err
+	}
+	metrics.RecordOperation(metrics.OperationWithdraw, currency, amount)
+	return nil
 }
 
-func (s *WalletService) Withdraw(ctx context.Context, uid int32, amount int32, currency string) error {
+// Transfer moves amount in the currency from the wallet of the user to the wallet of the user named to. Like a
+// withdrawal it requires a verified email address and is capped by the outflow limits of the sender.
+func (s *WalletService) Transfer(ctx context.Context, uid int32, to string, amount int32, currency string) (err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.Transfer", attribute.String("currency", currency))
+	defer func() { tracing.End(span, err) }()
+
 	if amount <= 0 {
-		return errors.New("amount must be greater than zero")
+		return ErrInvalidAmount
+	}
+	if err := s.requireVerifiedEmail(ctx, uid); err != nil {
+		return err
+	}
+	recipient, err := s.repo.GetUserByUsername(ctx, to)
+	if err != nil {
+		return err
+	}
+	if recipient.ID == uid {
+		return ErrSelfTransfer
 	}
 
-	return s.repo.UpdateBalance(ctx, uid, -amount, currency)
+	if err := s.repo.TransferBalance(ctx, uid, recipient.ID, amount, currency, s.outflow(limits.OperationTransfer, currency)); err != nil {
+		return err
+	}
+	metrics.RecordOperation(metrics.OperationTransfer, currency, amount)
+	return nil
 }
 
-func (s *WalletService) GetBalance(ctx context.Context, username string) (map[string]int32, error) {
+func (s *WalletService) GetBalance(ctx context.Context, username string) (_ map[string]int32, err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.GetBalance")
+	defer func() { tracing.End(span, err) }()
+
 	return s.repo.GetBalance(ctx, username)
 }
 
-func (s *WalletService) GetExchangeRates(ctx context.Context) (map[string]float64, error) {
+func (s *WalletService) GetExchangeRates(ctx context.Context) (_ map[string]float64, err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.GetExchangeRates")
+	defer func() { tracing.End(span, err) }()
+
 	return s.repo.GetExchangeRates(ctx)
 }
 
-func (s *WalletService) GetExchangeRate(ctx context.Context, from string, to string) (float32, error) {
-	return s.repo.GetExchangeRate(ctx, from, to)
+func (s *WalletService) GetExchangeRate(ctx context.Context, from string, to string) (_ float32, err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.GetExchangeRate", attribute.String("from", from), attribute.String("to", to))
+	defer func() { tracing.End(span, err) }()
+
+	rate, err := s.repo.GetExchangeRate(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
+	return rate.Rate, nil
 }
 
+// RegisterUser creates the user and queues the email verification email. A failure to queue the email does
+// not undo the registration, the user can request a new verification email after logging in.
 func (s *WalletService) RegisterUser(ctx context.Context, username string, email string, password string) error {
-	return s.repo.RegisterUser(ctx, username, email, password)
+	uid, err := s.repo.RegisterUser(ctx, username, email, password)
+	if err != nil {
+		return err
+	}
+	if err := s.sendVerificationEmail(ctx, uid, username, email); err != nil {
+		slog.ErrorContext(ctx, "Error queueing verification email", slog.Int("user_id", int(uid)), slog.Any("error", err))
+	}
+	return nil
+}
+
+// Login verifies the user credentials, starts a new session and issues its access and refresh tokens.
+// Users with two-factor authentication enabled get a challenge token instead, see CompleteTwoFactorLogin.
+// Repeated failures for the username or from the client IP block further attempts for a growing time.
+func (s *WalletService) Login(ctx context.Context, username string, password string, client ClientInfo) (repository.Token, error) {
+	if err := s.reserveLoginAttempt(ctx, username, client); err != nil {
+		if errors.Is(err, ErrLoginThrottled) {
+			s.recordLoginEvent(ctx, username, client, loginReasonThrottled)
+		}
+		return repository.Token{}, err
+	}
+
+	user, err := s.repo.Login(ctx, username, password)
+	if errors.Is(err, repository.ErrInvalidCredentials) {
+		s.recordLoginEvent(ctx, username, client, loginReasonInvalidCredentials)
+		return repository.Token{}, err
+	}
+	s.releaseLoginAttempt(ctx, username, client)
+	if err != nil {
+		return repository.Token{}, err
+	}
+
+	if user.TOTPEnabled {
+		challenge, expiresAt, err := s.tokens.IssueChallenge(user.ID, user.Username)
+		if err != nil {
+			return repository.Token{}, err
+		}
+		s.recordLoginEvent(ctx, user.Username, client, loginReasonChallenge)
+		return repository.Token{TwoFactorRequired: true, ChallengeToken: challenge, ExpiresAt: expiresAt}, nil
+	}
+
+	s.recordLoginSuccess(ctx, user.Username)
+	s.recordLoginEvent(ctx, user.Username, client, loginReasonOK)
+	return s.startSession(ctx, user, client)
+}
+
+// startSession creates a session for the authenticated user and issues its access and refresh tokens.
+func (s *WalletService) startSession(ctx context.Context, user repository.User, client ClientInfo) (repository.Token, error) {
+	refreshToken, refreshHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return repository.Token{}, err
+	}
+	device := repository.SessionDevice{Name: client.DeviceName, IP: client.IP, UserAgent: client.UserAgent}
+	if device.Name == "" {
+		device.Name = client.UserAgent
+	}
+	if name := []rune(device.Name); len(name) > 255 {
+		device.Name = string(name[:255])
+	}
+	sessionID, err := s.repo.CreateSession(ctx, user.ID, refreshHash, time.Now().Add(s.tokens.RefreshTTL()), device)
+	if err != nil {
+		return repository.Token{}, err
+	}
+
+	return s.issueTokens(user.ID, user.Username, sessionID, user.Roles, refreshToken)
+}
+
+// RefreshToken rotates the refresh token and issues a new access token for the same session.
+func (s *WalletService) RefreshToken(ctx context.Context, refreshToken string, client ClientInfo) (repository.Token, error) {
+	newRefreshToken, newHash, err := auth.NewRefreshToken()
+	if err != nil {
+		return repository.Token{}, err
+	}
+	session, err := s.repo.RotateRefreshToken(ctx, auth.HashRefreshToken(refreshToken), newHash, time.Now().Add(s.tokens.RefreshTTL()), client.IP)
+	if err != nil {
+		return repository.Token{}, err
+	}
+
+	return s.issueTokens(session.UserID, session.Username, session.ID, session.Roles, newRefreshToken)
+}
+
+// Logout revokes the session, invalidating its access and refresh tokens.
+func (s *WalletService) Logout(ctx context.Context, uid int32, sessionID string) error {
+	return s.repo.RevokeSession(ctx, uid, sessionID)
+}
+
+// ListSessions returns the active sessions of the user.
+func (s *WalletService) ListSessions(ctx context.Context, uid int32) ([]repository.Session, error) {
+	return s.repo.ListSessions(ctx, uid)
+}
+
+// RevokeSession revokes one of the sessions of the user, logging out the device it belongs to.
+func (s *WalletService) RevokeSession(ctx context.Context, uid int32, sessionID string) error {
+	return s.repo.RevokeSession(ctx, uid, sessionID)
+}
+
+// RevokeOtherSessions revokes all sessions of the user except the current one.
+func (s *WalletService) RevokeOtherSessions(ctx context.Context, uid int32, currentSessionID string) (int64, error) {
+	return s.repo.RevokeOtherSessions(ctx, uid, currentSessionID)
+}
+
+// issueTokens signs an access token for the session and pairs it with the refresh token.
+func (s *WalletService) issueTokens(uid int32, username string, sessionID string, roles []string, refreshToken string) (repository.Token, error) {
+	token, expiresAt, err := s.tokens.Issue(uid, username, sessionID, roles)
+	if err != nil {
+		return repository.Token{}, err
+	}
+	return repository.Token{Token: token, ExpiresAt: expiresAt, RefreshToken: refreshToken}, nil
+}
+
+// VerifyToken validates an access token and returns its claims. Tokens of revoked sessions are rejected.
+func (s *WalletService) VerifyToken(ctx context.Context, token string) (*auth.Claims, error) {
+	claims, err := s.tokens.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+	if claims.SessionID == "" {
+		return nil, ErrTokenRevoked
+	}
+
+	active, err := s.repo.IsSessionActive(ctx, claims.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrTokenRevoked
+	}
+	return claims, nil
+}
+
+// SetUserRoles replaces the roles of a user after checking that every role is known.
+func (s *WalletService) SetUserRoles(ctx context.Context, username string, roles []string) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("%w: at least one role is required", ErrInvalidRoles)
+	}
+	for _, role := range roles {
+		if !auth.IsRole(role) {
+			return fmt.Errorf("%w: unknown role %q", ErrInvalidRoles, role)
+		}
+	}
+	return s.repo.SetUserRoles(ctx, username, roles)
+}
+
+// AdjustBalance corrects the balance of a user on behalf of an admin, e.g. after a failed payout. A negative
+// amount takes money out. Adjustments skip the email verification and the outflow limits but never overdraw
+// the wallet, and each one is written to the audit log with its reason.
+func (s *WalletService) AdjustBalance(ctx context.Context, adminID int32, username string, amount int32, currency string, reason string) (err error) {
+	ctx, span := tracing.Start(ctx, "WalletService.AdjustBalance", attribute.String("currency", currency))
+	defer func() { tracing.End(span, err) }()
+
+	if amount == 0 {
+		return fmt.Errorf("%w: amount must not be zero", ErrInvalidAdjustment)
+	}
+	if strings.TrimSpace(reason) == "" {
+		return fmt.Errorf("%w: a reason is required", ErrInvalidAdjustment)
+	}
+	user, err := s.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return err
+	}
+	if err := s.repo.UpdateBalance(ctx, user.ID, amount, currency, nil); err != nil {
+		return err
+	}
+
+	logging.Audit(ctx, "Balance adjusted", slog.Int("admin_id", int(adminID)), slog.Int("user_id", int(user.ID)),
+		slog.String("currency", currency), slog.Int("amount", int(amount)), slog.String("reason", reason))
+	if amount > 0 {
+		metrics.RecordOperation(metrics.OperationAdjustmentCredit, currency, amount)
+	} else {
+		metrics.RecordOperation(metrics.OperationAdjustmentDebit, currency, -amount)
+	}
+	return nil
 }
 
-func (s *WalletService) Login(ctx context.Context, username string, password string) (repository.Token, error) {
-	return s.repo.Login(ctx, username, password)
+// JWKS returns the public keys that verify access tokens.
+func (s *WalletService) JWKS() auth.JWKS {
+	return s.tokens.JWKS()
 }
 
 var (
-	ErrInsufficientFunds = errors.New("insufficient funds")
-	ErrWalletNotFound    = errors.New("wallet not found")
+	// ErrInsufficientFunds and ErrWalletNotFound are detected by the repository while updating balances.
+	ErrInsufficientFunds = repository.ErrInsufficientFunds
+	ErrWalletNotFound    = repository.ErrWalletNotFound
+	ErrSlippageExceeded  = errors.New("exchange rate moved beyond the accepted slippage")
+	ErrTokenRevoked      = errors.New("token revoked")
+	ErrInvalidAmount     = errors.New("amount must be greater than zero")
+	ErrInvalidRoles      = errors.New("invalid roles")
+	ErrInvalidAdjustment = errors.New("invalid balance adjustment")
+	ErrSelfTransfer      = errors.New("cannot transfer to your own wallet")
+	// ErrLimitExceeded is returned, wrapped in a *limits.ExceededError, when an outflow exceeds a cap.
+	ErrLimitExceeded = limits.ErrLimitExceeded
 )
@@ -0,0 +1,150 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+	"wallet/internal/auth"
+	"wallet/internal/limits"
+	"wallet/internal/repository"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTokens(t *testing.T) *auth.Manager {
+	tokens, err := auth.NewManager(auth.Config{
+		Algorithm:    auth.AlgorithmHS256,
+		SigningKeyID: "test",
+		Keys:         []auth.Key{{ID: "test", Secret: []byte("0123456789abcdef0123456789abcdef")}},
+		Issuer:       auth.DefaultIssuer,
+		Audience:     auth.DefaultAudience,
+		AccessTTL:    time.Hour,
+		RefreshTTL:   24 * time.Hour,
+	})
+	require.NoError(t, err)
+	return tokens
+}
+
+func TestVerifyToken_RejectsRevokedSession(t *testing.T) {
+	tokens := newTestTokens(t)
+	repo := &fakeRepository{}
+	srv := NewWalletService(repo, tokens, Config{})
+
+	token, _, err := tokens.Issue(1, "alice", "session-1", []string{auth.RoleUser})
+	require.NoError(t, err)
+
+	claims, err := srv.VerifyToken(context.Background(), token)
+	require.NoError(t, err)
+	assert.Equal(t, "session-1", claims.SessionID)
+
+	repo.revoked = true
+	_, err = srv.VerifyToken(context.Background(), token)
+	assert.True(t, errors.Is(err, ErrTokenRevoked))
+}
+
+// sessionRepository records the device of the sessions created at login.
+type sessionRepository struct {
+	loginRepository
+
+	device repository.SessionDevice
+}
+
+func (f *sessionRepository) Login(ctx context.Context, username, password string) (repository.User, error) {
+	return repository.User{ID: 1, Username: username, Roles: []string{auth.RoleUser}}, nil
+}
+
+func (f *sessionRepository) CreateSession(ctx context.Context, uid int32, refreshTokenHash string, expiresAt time.Time, device repository.SessionDevice) (string, error) {
+	f.device = device
+	return "session-1", nil
+}
+
+func TestLogin_RecordsSessionDevice(t *testing.T) {
+	repo := &sessionRepository{loginRepository: loginRepository{throttles: map[string]repository.LoginThrottle{}}}
+	srv := NewWalletService(repo, newTestTokens(t), Config{LoginThrottle: DefaultLoginThrottle})
+
+	_, err := srv.Login(context.Background(), "alice", "secret", ClientInfo{IP: "203.0.113.7", UserAgent: "curl/8.0"})
+	require.NoError(t, err)
+	// Without a device name the user agent describes the device
+	assert.Equal(t, repository.SessionDevice{Name: "curl/8.0", IP: "203.0.113.7", UserAgent: "curl/8.0"}, repo.device)
+
+	_, err = srv.Login(context.Background(), "alice", "secret", ClientInfo{IP: "203.0.113.7", UserAgent: "curl/8.0", DeviceName: "Work laptop"})
+	require.NoError(t, err)
+	assert.Equal(t, "Work laptop", repo.device.Name)
+}
+
+// adjustRepository records the balance changes of AdjustBalance.
+type adjustRepository struct {
+	fakeRepository
+
+	updates []int32
+	outflow *limits.Outflow
+}
+
+func (f *adjustRepository) GetUserByUsername(ctx context.Context, username string) (repository.User, error) {
+	return repository.User{ID: 7, Username: username}, nil
+}
+
+func (f *adjustRepository) UpdateBalance(ctx context.Context, uid int32, amount int32, currency string, outflow *limits.Outflow) error {
+	f.updates = append(f.updates, amount)
+	f.outflow = outflow
+	return nil
+}
+
+func TestAdjustBalance(t *testing.T) {
+	repo := &adjustRepository{}
+	srv := NewWalletService(repo, nil, Config{Limits: testPolicy})
+
+	require.NoError(t, srv.AdjustBalance(context.Background(), 1, "bob", -2500, "USD", "reverse failed payout"))
+	assert.Equal(t, []int32{-2500}, repo.updates)
+	assert.Nil(t, repo.outflow, "adjustments are not capped by the outflow limits")
+
+	err := srv.AdjustBalance(context.Background(), 1, "bob", 0, "USD", "nothing")
+	assert.ErrorIs(t, err, ErrInvalidAdjustment)
+	err = srv.AdjustBalance(context.Background(), 1, "bob", 100, "USD", " ")
+	assert.ErrorIs(t, err, ErrInvalidAdjustment)
+	assert.Len(t, repo.updates, 1)
+}
+
+// transferRepository knows alice (ID 1) and bob (ID 2) and records the transfers between them.
+type transferRepository struct {
+	fakeRepository
+
+	transfers [][2]int32
+	outflow   *limits.Outflow
+}
+
+func (f *transferRepository) GetUserByUsername(ctx context.Context, username string) (repository.User, error) {
+	switch username {
+	case "alice":
+		return repository.User{ID: 1, Username: username}, nil
+	case "bob":
+		return repository.User{ID: 2, Username: username}, nil
+	}
+	return repository.User{}, repository.ErrUserNotFound
+}
+
+func (f *transferRepository) TransferBalance(ctx context.Context, fromUID int32, toUID int32, amount int32, currency string, outflow *limits.Outflow) error {
+	f.transfers = append(f.transfers, [2]int32{fromUID, toUID})
+	f.outflow = outflow
+	return nil
+}
+
+func TestTransfer(t *testing.T) {
+	repo := &transferRepository{}
+	policy := limits.Policy{limits.OperationTransfer: {"USD": {Daily: 500, Monthly: 5000}}}
+	srv := NewWalletService(repo, nil, Config{Limits: policy})
+
+	require.NoError(t, srv.Transfer(context.Background(), 1, "bob", 250, "USD"))
+	assert.Equal(t, [][2]int32{{1, 2}}, repo.transfers)
+	// The transfer is checked against the transfer caps of the sender
+	assert.Equal(t, &limits.Outflow{Operation: limits.OperationTransfer, Defaults: limits.Cap{Daily: 500, Monthly: 5000}}, repo.outflow)
+
+	assert.ErrorIs(t, srv.Transfer(context.Background(), 1, "alice", 250, "USD"), ErrSelfTransfer)
+	assert.ErrorIs(t, srv.Transfer(context.Background(), 1, "carol", 250, "USD"), repository.ErrUserNotFound)
+	assert.ErrorIs(t, srv.Transfer(context.Background(), 1, "bob", 0, "USD"), ErrInvalidAmount)
+	repo.unverified = true
+	assert.ErrorIs(t, srv.Transfer(context.Background(), 1, "bob", 250, "USD"), ErrEmailNotVerified)
+	assert.Len(t, repo.transfers, 1)
+}
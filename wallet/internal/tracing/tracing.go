@@ -0,0 +1,124 @@
+// Package tracing sets up OpenTelemetry tracing and provides helpers for spans around service and
+// repository calls.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName identifies the spans created by the wallet itself, as opposed to instrumentation libraries.
+const tracerName = "wallet"
+
+// Supported span exporters.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// Config holds the tracing settings.
+type Config struct {
+	// Exporter is none, stdout or otlp. The OTLP exporter also honors the standard OTEL_EXPORTER_OTLP_*
+	// environment variables.
+	Exporter string
+	// Endpoint is the URL of the OTLP/gRPC collector, e.g. http://localhost:4317.
+	Endpoint string
+	// ServiceName is reported as service.name of every span.
+	ServiceName string
+	// SampleRatio is the fraction of new traces that are recorded, between 0 and 1. Traces started by a
+	// caller follow the caller's sampling decision.
+	SampleRatio float64
+}
+
+// LoadConfig loads the tracing configuration from TRACING_EXPORTER, OTEL_EXPORTER_OTLP_ENDPOINT,
+// OTEL_SERVICE_NAME and TRACING_SAMPLE_RATIO as returned by getenv, defaulting to no export, "wallet"
+// and sampling every trace.
+func LoadConfig(getenv func(string) string) (Config, error) {
+	cfg := Config{
+		Exporter:    getenv("TRACING_EXPORTER"),
+		Endpoint:    getenv("OTEL_EXPORTER_OTLP_ENDPOINT"),
+		ServiceName: getenv("OTEL_SERVICE_NAME"),
+		SampleRatio: 1,
+	}
+	if cfg.Exporter == "" {
+		cfg.Exporter = ExporterNone
+	}
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = "wallet"
+	}
+	if value := getenv("TRACING_SAMPLE_RATIO"); value != "" {
+		ratio, err := strconv.ParseFloat(value, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return Config{}, fmt.Errorf("invalid TRACING_SAMPLE_RATIO %q: must be between 0 and 1", value)
+		}
+		cfg.SampleRatio = ratio
+	}
+	return cfg, nil
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator. The stdout exporter
+// writes to w. The returned function flushes pending spans and must be called before exiting.
+func Setup(ctx context.Context, cfg Config, w io.Writer) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(cfg.Exporter) {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(w))
+	case ExporterOTLP:
+		var opts []otlptracegrpc.Option
+		if cfg.Endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(cfg.Endpoint))
+		}
+		exporter, err = otlptracegrpc.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("invalid tracing exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s span exporter: %w", cfg.Exporter, err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Start starts a span named after the called component and method, e.g. "WalletService.Deposit".
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks the span as failed when err is not nil and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
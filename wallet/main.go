@@ -1,51 +1,247 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"wallet/internal/auth"
+	"wallet/internal/config"
 	"wallet/internal/handler"
+	"wallet/internal/health"
+	"wallet/internal/logging"
+	"wallet/internal/mail"
+	"wallet/internal/metrics"
+	"wallet/internal/migrate"
+	"wallet/internal/openapi"
+	"wallet/internal/ratelimit"
 	"wallet/internal/repository"
 	"wallet/internal/service"
+	"wallet/internal/tracing"
+	"wallet/migrations"
 
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
 )
 
 func main() {
-	// Load environment variables
-	err := godotenv.Load("config.env")
+	// Settings come from config.env, the environment and flags, in increasing precedence
+	cfg, err := config.Load(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if cfg.PrintConfig {
+		cfg.Print(os.Stdout)
+		return
+	}
+
+	logger, err := logging.New(cfg.Logging, os.Stdout)
+	if err != nil {
+		fatal("Invalid logging configuration", err)
+	}
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing, os.Stdout)
 	if err != nil {
-		log.Fatalf("Error loading config.env file: %v", err)
+		fatal("Failed to set up tracing", err)
 	}
 
-	db, err := repository.NewPostgresDB(repository.Config{
-		Host:     os.Getenv("DB_HOST"),
-		Port:     os.Getenv("DB_PORT"),
-		Username: os.Getenv("DB_USER"),
-		Password: os.Getenv("DB_PASSWORD"),
-		DBName:   os.Getenv("DB_NAME"),
-		SSLMode:  os.Getenv("DB_SSLMODE"),
-	})
+	db, err := repository.NewPostgresDB(cfg.Database)
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("Failed to connect to database", err)
 	}
 
-	repo := repository.NewWalletRepository(db)
-	srv := service.NewWalletService(repo)
+	// The schema is changed by the migrate subcommand only, the server refuses to run on an outdated one
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		fatal("Invalid migrations", err)
+	}
+	if len(cfg.Command) > 0 {
+		err := migrate.Run(context.Background(), migrator, cfg.Command[1:], os.Stdout)
+		db.Close()
+		if err != nil {
+			fatal("Migration failed", err)
+		}
+		return
+	}
+	if err := migrator.Check(context.Background()); err != nil {
+		fatal("Refusing to start, apply the migrations with the migrate up command", err)
+	}
+	metrics.RegisterDB(db)
+
+	tokens, err := auth.NewManager(cfg.Tokens)
+	if err != nil {
+		fatal("Failed to load token signing keys", err)
+	}
+
+	exchangerConn, err := repository.DialExchanger(cfg.Exchanger.Addr, cfg.Exchanger.TLS)
+	if err != nil {
+		fatal("Failed to set up exchanger connection", err)
+	}
+
+	repo := repository.NewWalletRepository(db, auth.NewPasswordHasher(cfg.Passwords), exchangerConn, cfg.Exchanger.Timeout)
+	srv := service.NewWalletService(repo, tokens, cfg.Service)
 	hnd := handler.NewWalletHandler(srv)
 
+	// Stop on SIGINT or SIGTERM, see shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Deliver queued emails in the background
+	sender, err := mail.NewSender(cfg.Mail)
+	if err != nil {
+		fatal("Invalid mail configuration", err)
+	}
+	dispatcherDone := make(chan struct{})
+	go func() {
+		mail.NewDispatcher(repo, sender, cfg.MailDispatchInterval).Run(ctx)
+		close(dispatcherDone)
+	}()
+
+	// Readiness requires the database and the exchanger, liveness only a running process
+	probes := health.NewChecker(cfg.HTTP.HealthCheckTimeout)
+	probes.Add("database", repo.PingDatabase)
+	probes.Add("exchanger", repo.PingExchanger)
+
+	// Requests are checked against the OpenAPI document before the handlers run
+	validator, err := openapi.NewValidator()
+	if err != nil {
+		fatal("Invalid OpenAPI document", err)
+	}
+
 	router := mux.NewRouter()
-	router.HandleFunc("/api/v1/balance", hnd.GetBalance).Methods("GET")
-	router.HandleFunc("/api/v1/wallet/deposit", hnd.WalletDeposit).Methods("POST")
-	router.HandleFunc("/api/v1/wallet/withdraw", hnd.WalletWithdraw).Methods("POST")
-	router.HandleFunc("/api/v1/rates", hnd.GetExchangeRates).Methods("GET")
-	router.HandleFunc("/api/v1/rate", hnd.GetExchangeRate).Methods("POST")
-	router.HandleFunc("/api/v1/exchange", hnd.Exchange).Methods("POST")
-	router.HandleFunc("/api/v1/register", hnd.RegisterUser).Methods("POST")
-	router.HandleFunc("/api/v1/login", hnd.Login).Methods("POST")
-
-	log.Println("Starting server on :8080...")
-	log.Fatal(http.ListenAndServe(":8080", router))
+	router.Use(otelmux.Middleware(cfg.Tracing.ServiceName, otelmux.WithFilter(handler.TraceRequest)))
+	router.Use(handler.ClientIP(cfg.HTTP.TrustedProxies), handler.LogRequests, handler.ObserveRequests, handler.LimitBody(cfg.HTTP.MaxBodyBytes), validator.Middleware)
+	router.Handle("/metrics", promhttp.Handler()).Methods("GET")
+	router.HandleFunc("/healthz", probes.Live).Methods("GET")
+	router.HandleFunc("/readyz", probes.Ready).Methods("GET")
+	router.HandleFunc("/.well-known/jwks.json", hnd.JWKS).Methods("GET")
+	router.HandleFunc("/openapi.yaml", openapi.ServeSpec).Methods("GET")
+	api := router.PathPrefix("/api/v1").Subrouter()
+
+	// Requests are limited per route group, per IP address before authentication and per user after it.
+	// The memory store limits each replica on its own
+	limiter := ratelimit.NewMemoryStore()
+	defaultLimit := handler.RateLimit(limiter, "default", cfg.RateLimits.Default)
+
+	// Public routes, reachable without a token
+	public := api.NewRoute().Subrouter()
+	public.Use(handler.RateLimit(limiter, "auth", cfg.RateLimits.Auth))
+	public.HandleFunc("/register", hnd.RegisterUser).Methods("POST")
+	public.HandleFunc("/login", hnd.Login).Methods("POST")
+	public.HandleFunc("/login/2fa", hnd.LoginTwoFactor).Methods("POST")
+	public.HandleFunc("/token/refresh", hnd.RefreshToken).Methods("POST")
+	public.HandleFunc("/email/verify", hnd.VerifyEmail).Methods("GET")
+	public.HandleFunc("/password/forgot", hnd.ForgotPassword).Methods("POST")
+	public.HandleFunc("/password/reset", hnd.ResetPassword).Methods("POST")
+
+	// Authenticated routes, the principal is available in the request context and
+	// every group requires the permission its routes need
+	private := api.NewRoute().Subrouter()
+	private.Use(handler.RateLimit(limiter, "ip", cfg.RateLimits.IP), hnd.Authenticate)
+
+	// Account management is only available to users logged in with a session, not to API keys
+	account := private.NewRoute().Subrouter()
+	account.Use(hnd.RequireSession, defaultLimit)
+	account.HandleFunc("/logout", hnd.Logout).Methods("POST")
+	account.HandleFunc("/2fa/enroll", hnd.EnrollTOTP).Methods("POST")
+	account.HandleFunc("/2fa/confirm", hnd.ConfirmTOTP).Methods("POST")
+	account.HandleFunc("/2fa/disable", hnd.DisableTOTP).Methods("POST")
+	account.HandleFunc("/email/verify/resend", hnd.ResendVerificationEmail).Methods("POST")
+	account.HandleFunc("/api-keys", hnd.CreateAPIKey).Methods("POST")
+	account.HandleFunc("/api-keys", hnd.ListAPIKeys).Methods("GET")
+	account.HandleFunc("/api-keys/{id}", hnd.RevokeAPIKey).Methods("DELETE")
+	account.HandleFunc("/login-history", hnd.LoginHistory).Methods("GET")
+	account.HandleFunc("/sessions", hnd.ListSessions).Methods("GET")
+	account.HandleFunc("/sessions", hnd.RevokeOtherSessions).Methods("DELETE")
+	account.HandleFunc("/sessions/{id}", hnd.RevokeSession).Methods("DELETE")
+
+	walletRead := private.NewRoute().Subrouter()
+	walletRead.Use(hnd.RequirePermission(auth.PermWalletRead), defaultLimit)
+	walletRead.HandleFunc("/balance", hnd.GetBalance).Methods("GET")
+	walletRead.HandleFunc("/exchange/preview", hnd.PreviewExchange).Methods("POST")
+	walletRead.HandleFunc("/limits", hnd.GetLimits).Methods("GET")
+
+	walletWrite := private.NewRoute().Subrouter()
+	walletWrite.Use(hnd.RequirePermission(auth.PermWalletWrite), handler.RateLimit(limiter, "write", cfg.RateLimits.Write))
+	walletWrite.HandleFunc("/wallet/deposit", hnd.WalletDeposit).Methods("POST")
+	walletWrite.HandleFunc("/wallet/withdraw", hnd.WalletWithdraw).Methods("POST")
+	walletWrite.HandleFunc("/wallet/transfer", hnd.WalletTransfer).Methods("POST")
+	walletWrite.HandleFunc("/exchange", hnd.Exchange).Methods("POST")
+
+	ratesRead := private.NewRoute().Subrouter()
+	ratesRead.Use(hnd.RequirePermission(auth.PermRatesRead), defaultLimit)
+	ratesRead.HandleFunc("/rates", hnd.GetExchangeRates).Methods("GET")
+	ratesRead.HandleFunc("/rate", hnd.GetExchangeRate).Methods("POST")
+
+	// Admin-only routes
+	usersAdmin := private.PathPrefix("/admin").Subrouter()
+	usersAdmin.Use(hnd.RequirePermission(auth.PermUsersAdmin))
+	usersAdmin.Use(hnd.RequireSession, defaultLimit)
+	usersAdmin.HandleFunc("/users/{username}/roles", hnd.SetUserRoles).Methods("PUT")
+	usersAdmin.HandleFunc("/users/{username}/api-keys", hnd.CreateUserAPIKey).Methods("POST")
+	usersAdmin.HandleFunc("/users/{username}/limits/{operation}/{currency}", hnd.SetUserLimit).Methods("PUT")
+	usersAdmin.HandleFunc("/users/{username}/limits/{operation}/{currency}", hnd.ResetUserLimit).Methods("DELETE")
+
+	walletAdjust := private.PathPrefix("/admin").Subrouter()
+	walletAdjust.Use(hnd.RequirePermission(auth.PermWalletAdjust))
+	walletAdjust.Use(hnd.RequireSession, defaultLimit)
+	walletAdjust.HandleFunc("/users/{username}/balance/adjust", hnd.AdjustBalance).Methods("POST")
+
+	server := &http.Server{
+		Addr:              cfg.HTTP.Addr,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.HTTP.ReadHeaderTimeout,
+		ReadTimeout:       cfg.HTTP.ReadTimeout,
+		WriteTimeout:      cfg.HTTP.WriteTimeout,
+		IdleTimeout:       cfg.HTTP.IdleTimeout,
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		slog.Info("Starting server", slog.String("addr", server.Addr))
+		serverErr <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		fatal("Server stopped", err)
+	case <-ctx.Done():
+	}
+
+	// Drain in-flight requests before releasing the connections they use, so deploys do not cut
+	// transactions short
+	slog.Info("Shutting down, draining in-flight requests", slog.Duration("timeout", cfg.HTTP.ShutdownTimeout))
+	probes.Drain()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.HTTP.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Error draining in-flight requests", slog.Any("error", err))
+	}
+	<-dispatcherDone
+	if err := exchangerConn.Close(); err != nil {
+		slog.Error("Error closing exchanger connection", slog.Any("error", err))
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("Error closing database", slog.Any("error", err))
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		slog.Error("Error flushing traces", slog.Any("error", err))
+	}
+	slog.Info("Server stopped")
+}
+
+// fatal logs the error and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, slog.Any("error", err))
+	os.Exit(1)
 }
@@ -0,0 +1,8 @@
+SET LOCAL search_path TO mydb;
+
+DROP TABLE IF EXISTS balances;
+DROP TABLE IF EXISTS wallets;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS currencies;
+
+DROP SCHEMA IF EXISTS mydb;
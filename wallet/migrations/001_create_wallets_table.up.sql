@@ -2,7 +2,7 @@
 
 CREATE SCHEMA IF NOT EXISTS mydb;
 
-SET search_path TO mydb;
+SET LOCAL search_path TO mydb;
 
 -- -----------------------------------------------------
 -- Table: currencies
@@ -37,7 +37,7 @@ CREATE TABLE IF NOT EXISTS wallets (
     ON UPDATE NO ACTION
 );
 
-CREATE INDEX user_idx ON wallets (user_id);
+CREATE INDEX IF NOT EXISTS user_idx ON wallets (user_id);
 
 -- -----------------------------------------------------
 -- Table: balances
@@ -59,9 +59,9 @@ CREATE TABLE IF NOT EXISTS balances (
     ON UPDATE NO ACTION
 );
 
-CREATE INDEX currency_idx ON balances (currency_id);
-CREATE INDEX wallet_idx ON balances (wallet_id);
+CREATE INDEX IF NOT EXISTS currency_idx ON balances (currency_id);
+CREATE INDEX IF NOT EXISTS wallet_idx ON balances (wallet_id);
 
-INSERT INTO mydb.currencies (currency) VALUES ('RUB');
-INSERT INTO mydb.currencies (currency) VALUES ('USD');
-INSERT INTO mydb.currencies (currency) VALUES ('EUR');
+INSERT INTO currencies (currency)
+SELECT c FROM (VALUES ('RUB'), ('USD'), ('EUR')) AS v (c)
+WHERE NOT EXISTS (SELECT 1 FROM currencies);
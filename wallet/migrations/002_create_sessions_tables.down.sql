@@ -0,0 +1,4 @@
+SET LOCAL search_path TO mydb;
+
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS sessions;
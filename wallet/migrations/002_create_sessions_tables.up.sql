@@ -0,0 +1,39 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- Table: sessions
+-- A session is created on login and groups every refresh token rotated from it.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS sessions (
+  id UUID PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at TIMESTAMPTZ,
+  CONSTRAINT session_user_fk
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS session_user_idx ON sessions (user_id);
+
+-- -----------------------------------------------------
+-- Table: refresh_tokens
+-- Only the SHA-256 hash of a refresh token is stored.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+  id SERIAL PRIMARY KEY,
+  session_id UUID NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT refresh_token_session_fk
+    FOREIGN KEY (session_id)
+    REFERENCES sessions (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_session_idx ON refresh_tokens (session_id);
@@ -0,0 +1,3 @@
+SET LOCAL search_path TO mydb;
+
+ALTER TABLE users DROP COLUMN IF EXISTS roles;
@@ -0,0 +1,6 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- Roles of a user, mapped to permissions by the wallet service.
+-- -----------------------------------------------------
+ALTER TABLE users ADD COLUMN IF NOT EXISTS roles TEXT[] NOT NULL DEFAULT '{user}';
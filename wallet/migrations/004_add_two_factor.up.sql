@@ -0,0 +1,28 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- TOTP two-factor authentication. totp_secret is set on enrollment
+-- and only used for login once totp_enabled is confirmed.
+-- -----------------------------------------------------
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret VARCHAR(64);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_step BIGINT;
+
+-- -----------------------------------------------------
+-- Table: recovery_codes
+-- Single-use codes replacing a TOTP code, only their SHA-256 hash is stored.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  code_hash VARCHAR(64) NOT NULL,
+  used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT recovery_code_user_fk
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS recovery_code_user_idx ON recovery_codes (user_id);
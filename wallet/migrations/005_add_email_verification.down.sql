@@ -0,0 +1,6 @@
+SET LOCAL search_path TO mydb;
+
+DROP TABLE IF EXISTS email_outbox;
+DROP TABLE IF EXISTS user_tokens;
+
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified;
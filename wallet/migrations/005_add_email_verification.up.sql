@@ -0,0 +1,54 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- Email verification. Money movement is blocked until the
+-- user confirmed their address. Users registered before
+-- verification existed are trusted, new users start unverified.
+-- -----------------------------------------------------
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN;
+UPDATE users SET email_verified = TRUE WHERE email_verified IS NULL;
+ALTER TABLE users ALTER COLUMN email_verified SET DEFAULT FALSE;
+ALTER TABLE users ALTER COLUMN email_verified SET NOT NULL;
+
+-- -----------------------------------------------------
+-- Table: user_tokens
+-- Single-use, expiring tokens sent by email for verification
+-- and password reset, only their SHA-256 hash is stored.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS user_tokens (
+  id SERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  purpose VARCHAR(32) NOT NULL,
+  token_hash VARCHAR(64) NOT NULL UNIQUE,
+  expires_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT user_token_user_fk
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS user_token_user_idx ON user_tokens (user_id, purpose);
+
+-- -----------------------------------------------------
+-- Table: email_outbox
+-- Emails are written here in the same transaction as the change
+-- that caused them and delivered asynchronously. A dispatcher
+-- claims a batch until locked_until, so replicas do not send
+-- the same email; the body is cleared once it is sent.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS email_outbox (
+  id BIGSERIAL PRIMARY KEY,
+  recipient VARCHAR(255) NOT NULL,
+  subject VARCHAR(255) NOT NULL,
+  body TEXT NOT NULL,
+  attempts INTEGER NOT NULL DEFAULT 0,
+  last_error TEXT,
+  locked_until TIMESTAMPTZ,
+  sent_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS email_outbox_pending_idx ON email_outbox (created_at) WHERE sent_at IS NULL;
@@ -0,0 +1,3 @@
+SET LOCAL search_path TO mydb;
+
+DROP TABLE IF EXISTS api_keys;
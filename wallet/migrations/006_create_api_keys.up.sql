@@ -0,0 +1,27 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- Table: api_keys
+-- Keys used by backend services instead of a user login. The key
+-- acts as its owner, limited to its scopes. prefix identifies the
+-- key, only the SHA-256 hash of the full key is stored.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS api_keys (
+  id UUID PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  prefix VARCHAR(32) NOT NULL UNIQUE,
+  key_hash VARCHAR(64) NOT NULL,
+  scopes TEXT[] NOT NULL,
+  expires_at TIMESTAMPTZ,
+  last_used_at TIMESTAMPTZ,
+  revoked_at TIMESTAMPTZ,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT api_key_user_fk
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS api_key_user_idx ON api_keys (user_id);
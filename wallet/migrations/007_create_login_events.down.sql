@@ -0,0 +1,4 @@
+SET LOCAL search_path TO mydb;
+
+DROP TABLE IF EXISTS login_throttles;
+DROP TABLE IF EXISTS login_events;
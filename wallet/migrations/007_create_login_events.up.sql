@@ -0,0 +1,35 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- Table: login_events
+-- Every login attempt, successful or not. user_id is NULL when the
+-- username does not belong to an account.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS login_events (
+  id BIGSERIAL PRIMARY KEY,
+  user_id INTEGER,
+  username VARCHAR(255) NOT NULL,
+  ip VARCHAR(64) NOT NULL,
+  user_agent TEXT NOT NULL,
+  success BOOLEAN NOT NULL,
+  reason VARCHAR(64) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT login_event_user_fk
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS login_event_user_idx ON login_events (user_id, created_at DESC);
+
+-- -----------------------------------------------------
+-- Table: login_throttles
+-- Recent failed login attempts per key, a key is either a
+-- username ("user:<name>") or a client IP ("ip:<address>").
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS login_throttles (
+  key VARCHAR(255) PRIMARY KEY,
+  failures INTEGER NOT NULL,
+  last_failure_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,10 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- Device details of sessions, shown to the user in the list of
+-- active sessions. last_seen_at is updated as the session is used.
+-- -----------------------------------------------------
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS device_name VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS ip VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS user_agent TEXT NOT NULL DEFAULT '';
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP;
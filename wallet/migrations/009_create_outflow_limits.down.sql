@@ -0,0 +1,4 @@
+SET LOCAL search_path TO mydb;
+
+DROP TABLE IF EXISTS outflow_limits;
+DROP TABLE IF EXISTS outflows;
@@ -0,0 +1,43 @@
+SET LOCAL search_path TO mydb;
+
+-- -----------------------------------------------------
+-- Table: outflows
+-- Money that left a wallet through a capped operation, summed up
+-- to check the daily and monthly limits. Written in the same
+-- transaction as the balance change. created_at is in UTC.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS outflows (
+  id BIGSERIAL PRIMARY KEY,
+  user_id INTEGER NOT NULL,
+  operation VARCHAR(16) NOT NULL,
+  currency VARCHAR(3) NOT NULL,
+  amount BIGINT NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL,
+  CONSTRAINT outflow_user_fk
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);
+
+CREATE INDEX IF NOT EXISTS outflow_user_idx ON outflows (user_id, operation, currency, created_at);
+
+-- -----------------------------------------------------
+-- Table: outflow_limits
+-- Per-user caps replacing the configured defaults of an operation
+-- in a currency. NULL means the period is not capped.
+-- -----------------------------------------------------
+CREATE TABLE IF NOT EXISTS outflow_limits (
+  user_id INTEGER NOT NULL,
+  operation VARCHAR(16) NOT NULL,
+  currency VARCHAR(3) NOT NULL,
+  daily BIGINT CHECK (daily >= 0),
+  monthly BIGINT CHECK (monthly >= 0),
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, operation, currency),
+  CONSTRAINT outflow_limit_user_fk
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE NO ACTION
+    ON UPDATE NO ACTION
+);